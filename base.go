@@ -3,24 +3,37 @@ package pprpc
 import (
 	"crypto/rand"
 	"crypto/tls"
+	"errors"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/pprpc/packets"
 )
 
 // error
 const (
-	// CmdIDNotReg 命令ID没有注册，不支持
+	// CmdIDNotReg 命令ID没有注册，不支持(同 status.Unimplemented)
 	CmdIDNotReg uint64 = 1
 )
 
+// ErrServerClosed Stop/Shutdown 之后 Serve 返回该错误.
+var ErrServerClosed = errors.New("pprpc: Server closed")
+
+// ErrSeqExhausted 连接的 CmdSeq 已经回绕, 不能再发起 AEAD 加密的调用(同一个会话密钥下Key和nonce会重复), 需要重新连接.
+var ErrSeqExhausted = errors.New("pprpc: CmdSeq exhausted, reconnect to renew session key")
+
+// shutdownPollInterval Shutdown 检查处理中报文的间隔
+const shutdownPollInterval = 100 * time.Millisecond
+
+// maxSeqID CmdSeq 最大值(packets 编码上限)
+const maxSeqID uint64 = 268435455
+
 var seqID uint64 // 全局唯一ID
 
-// GetSeqID 获得一个全局的唯一ID（在一定时间范围内,但数字大于 4294836215 会从1开始计数.）
+// GetSeqID 获得一个全局的唯一ID（在一定时间范围内,但数字大于 268435455 会从1开始计数.）
 func GetSeqID() uint64 {
-	if seqID > 4294836215 {
-		seqID = 0
-	}
-	return atomic.AddUint64(&seqID, 1)
+	return GetMaxSeqID(maxSeqID)
 }
 
 // GetTLSConfig 传入TLS Key相关文件，返回配置对象.
@@ -38,8 +51,124 @@ func GetTLSConfig(tlsCrt, tlsKey string) (*tls.Config, error) {
 
 // GetMaxSeqID 设置最大的seqid
 func GetMaxSeqID(max uint64) uint64 {
-	if seqID > max {
-		seqID = 0
+	for {
+		old := atomic.LoadUint64(&seqID)
+		v := old + 1
+		if v > max {
+			v = 1
+		}
+		if atomic.CompareAndSwapUint64(&seqID, old, v) {
+			return v
+		}
+	}
+}
+
+// seqAllocator 连接内的 CmdSeq 分配器, 跳过仍在等待应答的序列号.
+type seqAllocator struct {
+	mu      sync.Mutex
+	last    uint64
+	wrapped bool      // 序列号已经回绕
+	pending *sync.Map // CmdSeq -> 等待应答的通道
+	// 收到 GOAWAY 后不再发起新的调用, 等待中的调用结束后调用 onDrained
+	draining  bool
+	onDrained func()
+}
+
+func newSeqAllocator(pending *sync.Map) *seqAllocator {
+	sa := new(seqAllocator)
+	sa.pending = pending
+	return sa
+}
+
+// next 分配序列号(调用方持有锁), AEAD 加密类型在序列号回绕后返回 ErrSeqExhausted.
+func (sa *seqAllocator) next(encType uint8) (uint64, error) {
+	aead := packets.IsAEAD(encType)
+	for {
+		if sa.last >= maxSeqID {
+			if aead {
+				return 0, ErrSeqExhausted
+			}
+			sa.last = 0
+			sa.wrapped = true
+		}
+		if aead && sa.wrapped {
+			return 0, ErrSeqExhausted
+		}
+		sa.last++
+		if _, ok := sa.pending.Load(sa.last); !ok {
+			return sa.last, nil
+		}
+	}
+}
+
+// Next 分配一个不与等待中的调用冲突的序列号.
+func (sa *seqAllocator) Next(encType uint8) (uint64, error) {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	return sa.next(encType)
+}
+
+// Acquire 分配序列号并登记等待通道, 调用结束后需要 Release.
+func (sa *seqAllocator) Acquire(v interface{}, encType uint8) (uint64, error) {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	seq, err := sa.next(encType)
+	if err != nil {
+		return 0, err
+	}
+	sa.pending.Store(seq, v)
+	return seq, nil
+}
+
+// Release 释放序列号
+func (sa *seqAllocator) Release(seq uint64) {
+	sa.pending.Delete(seq)
+	sa.checkDrained()
+}
+
+// Drain 进入 draining 状态, 没有等待中的调用(包括流)时调用 fn.
+func (sa *seqAllocator) Drain(fn func()) {
+	sa.mu.Lock()
+	sa.draining = true
+	sa.onDrained = fn
+	sa.mu.Unlock()
+	sa.checkDrained()
+}
+
+// Draining 是否已经收到 GOAWAY.
+func (sa *seqAllocator) Draining() bool {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	return sa.draining
+}
+
+// Reset 重新连接(重新协商会话密钥)后退出 draining 状态, 重新开始分配序列号.
+func (sa *seqAllocator) Reset() {
+	sa.mu.Lock()
+	sa.last = 0
+	sa.wrapped = false
+	sa.draining = false
+	sa.onDrained = nil
+	sa.mu.Unlock()
+}
+
+func (sa *seqAllocator) checkDrained() {
+	sa.mu.Lock()
+	fn := sa.onDrained
+	if fn == nil {
+		sa.mu.Unlock()
+		return
+	}
+	idle := true
+	sa.pending.Range(func(k, v interface{}) bool {
+		idle = false
+		return false
+	})
+	if idle {
+		sa.onDrained = nil
+	}
+	sa.mu.Unlock()
+	if idle {
+		fn()
 	}
-	return atomic.AddUint64(&seqID, 1)
 }
@@ -0,0 +1,100 @@
+package pprpc
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/pprpc/packets"
+)
+
+func TestSeqAllocatorExhausted(t *testing.T) {
+	sa := newSeqAllocator(new(sync.Map))
+	sa.last = maxSeqID - 1
+	if seq, err := sa.Next(packets.AES256GCM); err != nil || seq != maxSeqID {
+		t.Fatalf("seq: %d, err: %v", seq, err)
+	}
+	// AEAD 加密的调用不允许序列号回绕
+	if _, err := sa.Acquire(nil, packets.AES256GCM); !errors.Is(err, ErrSeqExhausted) {
+		t.Fatalf("err: %v", err)
+	}
+	// 其他加密类型回绕, 之后 AEAD 加密的调用仍然失败
+	if seq, err := sa.Next(packets.AES256CFB); err != nil || seq != 1 {
+		t.Fatalf("seq: %d, err: %v", seq, err)
+	}
+	if _, err := sa.Next(packets.CHACHA20POLY1305); !errors.Is(err, ErrSeqExhausted) {
+		t.Fatalf("err: %v", err)
+	}
+	// 重新连接(重新协商会话密钥)后恢复
+	sa.Reset()
+	if seq, err := sa.Next(packets.AES256GCM); err != nil || seq != 1 {
+		t.Fatalf("seq: %d, err: %v", seq, err)
+	}
+}
+
+func TestSeqAllocatorConcurrent(t *testing.T) {
+	sa := newSeqAllocator(new(sync.Map))
+	// 从接近最大值开始, 覆盖回绕
+	sa.last = maxSeqID - 100
+	var (
+		mu      sync.Mutex
+		held    = make(map[uint64]bool)
+		wrapped bool
+		wg      sync.WaitGroup
+	)
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				seq, err := sa.Acquire(struct{}{}, packets.AESNONE)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if seq == 0 || seq > maxSeqID || held[seq] {
+					mu.Unlock()
+					t.Errorf("duplicate or invalid seq: %d", seq)
+					return
+				}
+				held[seq] = true
+				if seq < 100 {
+					wrapped = true
+				}
+				mu.Unlock()
+				runtime.Gosched()
+
+				mu.Lock()
+				delete(held, seq)
+				mu.Unlock()
+				sa.Release(seq)
+			}
+		}()
+	}
+	wg.Wait()
+	if !wrapped {
+		t.Fatal("seq not wrapped")
+	}
+}
+
+func TestSeqAllocatorSkipPending(t *testing.T) {
+	sa := newSeqAllocator(new(sync.Map))
+	sa.last = maxSeqID - 1
+	a, _ := sa.Acquire(struct{}{}, packets.AESNONE)
+	b, _ := sa.Acquire(struct{}{}, packets.AESNONE)
+	if a != maxSeqID || b != 1 {
+		t.Fatalf("seq: %d, %d", a, b)
+	}
+	// 回绕后跳过仍在等待应答的序列号
+	sa.last = maxSeqID
+	if c, _ := sa.Acquire(struct{}{}, packets.AESNONE); c != 2 {
+		t.Fatalf("seq: %d", c)
+	}
+	sa.Release(b)
+	sa.last = maxSeqID - 1
+	if c, _ := sa.Next(packets.AESNONE); c != 1 {
+		t.Fatalf("seq: %d", c)
+	}
+}
@@ -0,0 +1,86 @@
+// Package metrics 运行指标采集: 每个命令的调用次数, 耗时和状态码, 各类报文的收发字节数,
+// 活动连接数, 客户端重连次数和 UDP 丢弃的报文数.
+//
+// 默认不采集, 通过 SetCollector 设置采集器; Registry 为内置实现, Handler 以 Prometheus 文本格式输出:
+//
+//	reg := metrics.NewRegistry()
+//	metrics.SetCollector(reg)
+//	http.Handle("/metrics", reg.Handler())
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 调用方
+const (
+	SideServer = "server"
+	SideClient = "client"
+)
+
+// 数据方向
+const (
+	DirIn  = "in"
+	DirOut = "out"
+)
+
+// UDP 报文丢弃原因
+const (
+	// DropSessFull 服务端连接数达到上限
+	DropSessFull = "sess_full"
+	// DropFragInvalid 分片序号或者个数错误, 重复的分片
+	DropFragInvalid = "frag_invalid"
+	// DropFragMem 分片重组缓存超过上限
+	DropFragMem = "frag_mem"
+	// DropFragTimeout 分片重组超时
+	DropFragTimeout = "frag_timeout"
+	// DropRelDup 可靠模式下重复或者超出接收窗口的报文
+	DropRelDup = "rel_dup"
+)
+
+// Collector 指标采集器, 需要支持并发调用且不能阻塞.
+type Collector interface {
+	// ObserveCall 一次调用完成: side 为 SideServer/SideClient, code 为状态码(0 成功).
+	ObserveCall(side string, cmdid uint64, cmdName string, code uint64, d time.Duration)
+	// AddBytes 收发一个报文: dir 为 DirIn/DirOut, pkgType 为报文类型名称(hb, cmd, av, customer, file, kx);
+	// 由 pprpc 通过 packets.SetStatsHook 转发, 每个报文调用一次.
+	AddBytes(dir, pkgType string, n int64)
+	// AddConns 活动连接数变化: proto 为 tcp/udp, side 为 SideServer/SideClient.
+	AddConns(proto, side string, delta int64)
+	// IncReconnect 客户端重连一次.
+	IncReconnect(proto string)
+	// IncDrop UDP 丢弃一个报文, reason 为 Drop*.
+	IncDrop(reason string)
+}
+
+type holder struct {
+	c Collector
+}
+
+var collector atomic.Value
+
+func init() {
+	collector.Store(holder{nopCollector{}})
+}
+
+// SetCollector 设置采集器, nil 停止采集.
+func SetCollector(c Collector) {
+	if c == nil {
+		c = nopCollector{}
+	}
+	collector.Store(holder{c})
+}
+
+// Get 获取当前的采集器.
+func Get() Collector {
+	return collector.Load().(holder).c
+}
+
+type nopCollector struct{}
+
+func (nopCollector) ObserveCall(string, uint64, string, uint64, time.Duration) {}
+func (nopCollector) AddBytes(string, string, int64)                            {}
+func (nopCollector) AddConns(string, string, int64)                            {}
+func (nopCollector) IncReconnect(string)                                       {}
+func (nopCollector) IncDrop(string)                                            {}
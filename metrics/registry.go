@@ -0,0 +1,290 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefBuckets 调用耗时直方图默认的分桶(秒)
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type callKey struct {
+	side    string
+	cmdid   uint64
+	cmdName string
+	code    uint64
+}
+
+type durationKey struct {
+	side    string
+	cmdid   uint64
+	cmdName string
+}
+
+type bytesKey struct {
+	dir     string
+	pkgType string
+}
+
+// bytesCounter 收发的字节数和报文数, 每个报文都会更新, 使用原子操作不加锁.
+type bytesCounter struct {
+	bytes   uint64
+	packets uint64
+}
+
+type connsKey struct {
+	proto string
+	side  string
+}
+
+type histogram struct {
+	counts []uint64 // 与 buckets 对应, 不累加
+	sum    float64
+	count  uint64
+}
+
+// Registry 内置的采集器, 在内存中汇总指标.
+type Registry struct {
+	mu      sync.Mutex
+	buckets []float64
+
+	calls      map[callKey]uint64
+	durations  map[durationKey]*histogram
+	bytes      sync.Map // bytesKey -> *bytesCounter
+	conns      map[connsKey]int64
+	reconnects map[string]uint64
+	drops      map[string]uint64
+}
+
+// NewRegistry 创建 Registry, buckets 为调用耗时的分桶(秒, 升序), 为空时使用 DefBuckets.
+func NewRegistry(buckets ...float64) *Registry {
+	r := new(Registry)
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	r.buckets = append([]float64(nil), buckets...)
+	sort.Float64s(r.buckets)
+	r.calls = make(map[callKey]uint64)
+	r.durations = make(map[durationKey]*histogram)
+	r.conns = make(map[connsKey]int64)
+	r.reconnects = make(map[string]uint64)
+	r.drops = make(map[string]uint64)
+	return r
+}
+
+// ObserveCall 实现 Collector.
+func (r *Registry) ObserveCall(side string, cmdid uint64, cmdName string, code uint64, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls[callKey{side, cmdid, cmdName, code}]++
+
+	dk := durationKey{side, cmdid, cmdName}
+	h, ok := r.durations[dk]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(r.buckets))}
+		r.durations[dk] = h
+	}
+	v := d.Seconds()
+	if i := sort.SearchFloat64s(r.buckets, v); i < len(r.buckets) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// AddBytes 实现 Collector.
+func (r *Registry) AddBytes(dir, pkgType string, n int64) {
+	k := bytesKey{dir, pkgType}
+	v, ok := r.bytes.Load(k)
+	if !ok {
+		v, _ = r.bytes.LoadOrStore(k, new(bytesCounter))
+	}
+	c := v.(*bytesCounter)
+	atomic.AddUint64(&c.bytes, uint64(n))
+	atomic.AddUint64(&c.packets, 1)
+}
+
+// AddConns 实现 Collector.
+func (r *Registry) AddConns(proto, side string, delta int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conns[connsKey{proto, side}] += delta
+}
+
+// IncReconnect 实现 Collector.
+func (r *Registry) IncReconnect(proto string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reconnects[proto]++
+}
+
+// IncDrop 实现 Collector.
+func (r *Registry) IncDrop(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.drops[reason]++
+}
+
+// WriteText 以 Prometheus 文本格式(0.0.4)输出所有指标.
+func (r *Registry) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	r.mu.Lock()
+	r.writeCalls(bw)
+	r.writeDurations(bw)
+	r.writeBytes(bw)
+	r.writeConns(bw)
+	r.writeCounterMap(bw, "pprpc_client_reconnects_total", "Client reconnect attempts.", "proto", r.reconnects)
+	r.writeCounterMap(bw, "pprpc_udp_drops_total", "UDP packets dropped.", "reason", r.drops)
+	r.mu.Unlock()
+	return bw.Flush()
+}
+
+// Handler 返回输出指标的 http.Handler.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+func (r *Registry) writeCalls(w *bufio.Writer) {
+	writeHeader(w, "pprpc_calls_total", "RPC calls completed, by status code.", "counter")
+	keys := make([]callKey, 0, len(r.calls))
+	for k := range r.calls {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.side != b.side {
+			return a.side < b.side
+		}
+		if a.cmdid != b.cmdid {
+			return a.cmdid < b.cmdid
+		}
+		return a.code < b.code
+	})
+	for _, k := range keys {
+		writeSample(w, "pprpc_calls_total", labels("side", k.side, "cmdid", strconv.FormatUint(k.cmdid, 10),
+			"cmd", k.cmdName, "code", strconv.FormatUint(k.code, 10)), float64(r.calls[k]))
+	}
+}
+
+func (r *Registry) writeDurations(w *bufio.Writer) {
+	const name = "pprpc_call_duration_seconds"
+	writeHeader(w, name, "RPC call latency.", "histogram")
+	keys := make([]durationKey, 0, len(r.durations))
+	for k := range r.durations {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].side != keys[j].side {
+			return keys[i].side < keys[j].side
+		}
+		return keys[i].cmdid < keys[j].cmdid
+	})
+	for _, k := range keys {
+		h := r.durations[k]
+		lv := []string{"side", k.side, "cmdid", strconv.FormatUint(k.cmdid, 10), "cmd", k.cmdName}
+		var cum uint64
+		for i, b := range r.buckets {
+			cum += h.counts[i]
+			writeSample(w, name+"_bucket", labels(append(lv, "le", formatFloat(b))...), float64(cum))
+		}
+		writeSample(w, name+"_bucket", labels(append(lv, "le", "+Inf")...), float64(h.count))
+		writeSample(w, name+"_sum", labels(lv...), h.sum)
+		writeSample(w, name+"_count", labels(lv...), float64(h.count))
+	}
+}
+
+func (r *Registry) writeBytes(w *bufio.Writer) {
+	counters := make(map[bytesKey]bytesCounter)
+	var keys []bytesKey
+	r.bytes.Range(func(k, v interface{}) bool {
+		c := v.(*bytesCounter)
+		counters[k.(bytesKey)] = bytesCounter{atomic.LoadUint64(&c.bytes), atomic.LoadUint64(&c.packets)}
+		keys = append(keys, k.(bytesKey))
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].dir != keys[j].dir {
+			return keys[i].dir < keys[j].dir
+		}
+		return keys[i].pkgType < keys[j].pkgType
+	})
+	writeHeader(w, "pprpc_bytes_total", "Bytes sent and received, by packet type.", "counter")
+	for _, k := range keys {
+		writeSample(w, "pprpc_bytes_total", labels("dir", k.dir, "type", k.pkgType), float64(counters[k].bytes))
+	}
+	writeHeader(w, "pprpc_packets_total", "Packets sent and received, by packet type.", "counter")
+	for _, k := range keys {
+		writeSample(w, "pprpc_packets_total", labels("dir", k.dir, "type", k.pkgType), float64(counters[k].packets))
+	}
+}
+
+func (r *Registry) writeConns(w *bufio.Writer) {
+	writeHeader(w, "pprpc_connections", "Active connections.", "gauge")
+	keys := make([]connsKey, 0, len(r.conns))
+	for k := range r.conns {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].proto != keys[j].proto {
+			return keys[i].proto < keys[j].proto
+		}
+		return keys[i].side < keys[j].side
+	})
+	for _, k := range keys {
+		writeSample(w, "pprpc_connections", labels("proto", k.proto, "side", k.side), float64(r.conns[k]))
+	}
+}
+
+func (r *Registry) writeCounterMap(w *bufio.Writer, name, help, label string, m map[string]uint64) {
+	writeHeader(w, name, help, "counter")
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeSample(w, name, labels(label, k), float64(m[k]))
+	}
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(v))
+}
+
+// labels 由 k1, v1, k2, v2... 生成标签.
+func labels(kv ...string) string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(kv[i])
+		sb.WriteString(`="`)
+		sb.WriteString(labelReplacer.Replace(kv[i+1]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistryText(t *testing.T) {
+	r := NewRegistry(0.1, 0.01)
+	r.ObserveCall(SideServer, 10, "Echo", 0, 5*time.Millisecond)
+	r.ObserveCall(SideServer, 10, "Echo", 5, 50*time.Millisecond)
+	r.ObserveCall(SideClient, 11, `a"b\c`, 0, time.Second)
+	r.AddBytes(DirOut, "cmd", 100)
+	r.AddBytes(DirOut, "cmd", 20)
+	r.AddBytes(DirIn, "hb", 3)
+	r.AddConns("tcp", SideServer, 2)
+	r.AddConns("tcp", SideServer, -1)
+	r.IncReconnect("tcp")
+	r.IncDrop(DropFragMem)
+
+	want := `# HELP pprpc_calls_total RPC calls completed, by status code.
+# TYPE pprpc_calls_total counter
+pprpc_calls_total{side="client",cmdid="11",cmd="a\"b\\c",code="0"} 1
+pprpc_calls_total{side="server",cmdid="10",cmd="Echo",code="0"} 1
+pprpc_calls_total{side="server",cmdid="10",cmd="Echo",code="5"} 1
+# HELP pprpc_call_duration_seconds RPC call latency.
+# TYPE pprpc_call_duration_seconds histogram
+pprpc_call_duration_seconds_bucket{side="client",cmdid="11",cmd="a\"b\\c",le="0.01"} 0
+pprpc_call_duration_seconds_bucket{side="client",cmdid="11",cmd="a\"b\\c",le="0.1"} 0
+pprpc_call_duration_seconds_bucket{side="client",cmdid="11",cmd="a\"b\\c",le="+Inf"} 1
+pprpc_call_duration_seconds_sum{side="client",cmdid="11",cmd="a\"b\\c"} 1
+pprpc_call_duration_seconds_count{side="client",cmdid="11",cmd="a\"b\\c"} 1
+pprpc_call_duration_seconds_bucket{side="server",cmdid="10",cmd="Echo",le="0.01"} 1
+pprpc_call_duration_seconds_bucket{side="server",cmdid="10",cmd="Echo",le="0.1"} 2
+pprpc_call_duration_seconds_bucket{side="server",cmdid="10",cmd="Echo",le="+Inf"} 2
+pprpc_call_duration_seconds_sum{side="server",cmdid="10",cmd="Echo"} 0.055
+pprpc_call_duration_seconds_count{side="server",cmdid="10",cmd="Echo"} 2
+# HELP pprpc_bytes_total Bytes sent and received, by packet type.
+# TYPE pprpc_bytes_total counter
+pprpc_bytes_total{dir="in",type="hb"} 3
+pprpc_bytes_total{dir="out",type="cmd"} 120
+# HELP pprpc_packets_total Packets sent and received, by packet type.
+# TYPE pprpc_packets_total counter
+pprpc_packets_total{dir="in",type="hb"} 1
+pprpc_packets_total{dir="out",type="cmd"} 2
+# HELP pprpc_connections Active connections.
+# TYPE pprpc_connections gauge
+pprpc_connections{proto="tcp",side="server"} 1
+# HELP pprpc_client_reconnects_total Client reconnect attempts.
+# TYPE pprpc_client_reconnects_total counter
+pprpc_client_reconnects_total{proto="tcp"} 1
+# HELP pprpc_udp_drops_total UDP packets dropped.
+# TYPE pprpc_udp_drops_total counter
+pprpc_udp_drops_total{reason="frag_mem"} 1
+`
+	var b bytes.Buffer
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type: %s", ct)
+	}
+	if rec.Body.String() != want {
+		t.Fatalf("handler body:\n%s", rec.Body.String())
+	}
+}
+
+func TestRegistryAddBytes(t *testing.T) {
+	r := NewRegistry()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			dir := DirIn
+			if g%2 == 1 {
+				dir = DirOut
+			}
+			for i := 0; i < 1000; i++ {
+				r.AddBytes(dir, "av", 10)
+			}
+		}(g)
+	}
+	wg.Wait()
+	var b bytes.Buffer
+	r.WriteText(&b)
+	for _, line := range []string{
+		`pprpc_bytes_total{dir="in",type="av"} 40000`,
+		`pprpc_bytes_total{dir="out",type="av"} 40000`,
+		`pprpc_packets_total{dir="in",type="av"} 4000`,
+		`pprpc_packets_total{dir="out",type="av"} 4000`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestSetCollector(t *testing.T) {
+	r := NewRegistry()
+	SetCollector(r)
+	defer SetCollector(nil)
+	if Get() != Collector(r) {
+		t.Fatal("collector not set")
+	}
+	SetCollector(nil)
+	// 停止采集后不再记录
+	Get().AddBytes(DirIn, "cmd", 1)
+	var b bytes.Buffer
+	r.WriteText(&b)
+	if strings.Contains(b.String(), `type="cmd"`) {
+		t.Fatalf("counted after SetCollector(nil):\n%s", b.String())
+	}
+}
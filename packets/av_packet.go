@@ -19,9 +19,11 @@ type AVPacket struct {
 	AVChannel  uint64 // 通道编号
 	AVSeq      uint64 // 流媒体序列号报文
 	Timestamp  uint64 // 媒体包时间戳
-	EncLength  uint64 // 加密数据的长度
+	EncLength  uint64 // 加密数据的长度, 0 表示全部加密; 其余数据不加密, AEAD 时参与认证
 	VarHeader  []byte
 	Key        []byte // 加密用Key,固定部分
+	SessKey    []byte // 会话密钥(密钥协商产生), 不为空时代替 Key
+	SessDir    uint8  // 发送方向(FRAMEC2S, FRAMES2C), 使用会话密钥时参与加密Key和nonce的生成; 读写时由连接设置, 不传输
 	Md5Byte    []byte
 	EnKey      []byte
 	Payload    []byte // 存放协议载荷
@@ -40,16 +42,24 @@ func NewAVPacket() (av *AVPacket) {
 }
 
 func (av *AVPacket) Write(w io.Writer) (int64, error) {
+	key, dir := sessionOf(w)
+	if len(av.SessKey) == 0 {
+		av.SessKey = key
+	}
+	if len(key) > 0 {
+		// 复用读取的报文(如应答)时, 发送方向由写入的连接决定
+		av.SessDir = dir
+	}
 	packet, err := av.Pack()
 	if err != nil {
 		return 0, err
 	}
 	n, err := packet.WriteTo(w)
+	countOut(av.MessageType, n)
 	return n, err
 }
 
-// Pack 编码数据包，根据 AutoCrypt 自动处理加密.
-// FIXME: 处理指定长度的加密
+// Pack 编码数据包，根据 AutoCrypt 自动处理加密, EncLength 不为0时只加密 Payload 的前 EncLength 字节.
 func (av *AVPacket) Pack() (packet bytes.Buffer, err error) {
 	if av.AVIFrame != 0 && av.AVIFrame != 1 {
 		err = fmt.Errorf("AvIFrame not support: %d", av.AVIFrame)
@@ -77,7 +87,10 @@ func (av *AVPacket) Pack() (packet bytes.Buffer, err error) {
 
 	av.GetCryptoKey()
 	if len(av.Payload) > 0 && av.AutoCrypt {
-		av.Payload, err = Encrypt(av.EncType, av.EnKey, av.EnKey, av.Payload)
+		if err = checkSessionKey(av.EncType, av.SessKey); err != nil {
+			return
+		}
+		av.Payload, err = encryptPrefix(av.EncType, av.EnKey, av.nonce(), av.VarHeader, av.Payload, av.EncLength)
 		if err != nil {
 			return
 		}
@@ -94,8 +107,7 @@ func (av *AVPacket) Pack() (packet bytes.Buffer, err error) {
 	return
 }
 
-// Unpack 解码数据包，根据 AutoCrypt 自动处理解密.
-// FIXME: 处理指定长度的解密
+// Unpack 解码数据包，根据 AutoCrypt 自动处理解密, EncLength 不为0时只解密前 EncLength 字节对应的密文.
 func (av *AVPacket) Unpack(r io.Reader) error {
 	var varHeader []byte
 	var payloadLength = av.FixHeader.Length
@@ -134,12 +146,34 @@ func (av *AVPacket) Unpack(r io.Reader) error {
 	}
 
 	if len(av.RAWPayload) > 0 && av.AutoCrypt {
+		if err = checkSessionKey(av.EncType, av.SessKey); err != nil {
+			return err
+		}
 		av.GetCryptoKey()
-		av.Payload, err = Decrypt(av.EncType, av.EnKey, av.EnKey, av.RAWPayload)
+		av.Payload, err = decryptPrefix(av.EncType, av.EnKey, av.nonce(), av.VarHeader, av.RAWPayload, av.EncLength)
+		if err != nil {
+			err = fmt.Errorf("AVChannel: %d, AVSeq: %d, decrypt: %w", av.AVChannel, av.AVSeq, err)
+		}
 	} else if len(av.RAWPayload) > 0 && av.AutoCrypt == false {
 		av.Payload = av.RAWPayload
 	}
-	return nil
+	return err
+}
+
+// Decrypt 解密读取时没有自动解密的 RAWPayload(连接的 AutoCrypt 为 false), 已经解密或者 EncType 为 AESNONE 时不处理.
+func (av *AVPacket) Decrypt() (err error) {
+	if av.AutoCrypt || av.EncType == AESNONE || len(av.RAWPayload) == 0 {
+		return nil
+	}
+	if err = checkSessionKey(av.EncType, av.SessKey); err != nil {
+		return
+	}
+	av.GetCryptoKey()
+	av.Payload, err = decryptPrefix(av.EncType, av.EnKey, av.nonce(), av.VarHeader, av.RAWPayload, av.EncLength)
+	if err != nil {
+		err = fmt.Errorf("AVChannel: %d, AVSeq: %d, decrypt: %w", av.AVChannel, av.AVSeq, err)
+	}
+	return
 }
 
 func (av *AVPacket) String() string {
@@ -155,8 +189,17 @@ func (av *AVPacket) Debug() string {
 func (av *AVPacket) GetCryptoKey() {
 	// MD5(fmt.Sprintf("%s,AVSeq:%d-TT:%d-AVChannel:%d", "P2p0r1p8c0622",av.AVSeq, av.Timestamp, av.AVChannel))
 	_t := fmt.Sprintf(",AVSeq:%d-TT:%d-AVChannel:%d", av.AVSeq, av.Timestamp, av.AVChannel)
+	if len(av.SessKey) > 0 {
+		av.EnKey = sessionCryptoKey(av.SessKey, _t+fmt.Sprintf("-SND:%d", av.SessDir))
+		return
+	}
 	av.Md5Byte = []byte{}
 	av.Md5Byte = append(av.Md5Byte, av.Key...)
 	av.Md5Byte = append(av.Md5Byte, []byte(_t)...)
 	av.EnKey = []byte(ppcrypto.MD5(av.Md5Byte))
 }
+
+// nonce AEAD nonce: SessDir(最高位) + AVSeq + Timestamp.
+func (av *AVPacket) nonce() []byte {
+	return seqNonce(uint32(av.AVSeq)&0x7fffffff|uint32(av.SessDir)<<31, av.Timestamp)
+}
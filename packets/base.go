@@ -24,6 +24,8 @@ const (
 	TYPECUSTOMER uint8 = 7
 	// TYPEFILE 传输文件报文
 	TYPEFILE uint8 = 8
+	// TYPEKX 密钥协商报文
+	TYPEKX uint8 = 9
 )
 
 // 音视频变量
@@ -80,9 +82,14 @@ const (
 	AES192CTR uint8 = 14
 	AES256CTR uint8 = 15
 
+	// AEAD 加密后数据长度增加16字节(认证标签), VarHeader 作为附加认证数据; 需要会话密钥(密钥协商)
+	AES128GCM        uint8 = 16
+	AES256GCM        uint8 = 17
+	CHACHA20POLY1305 uint8 = 18
+
 /*
 	系统目前支持的加密类型：
-	0，1，2，3, 4, 5, 6
+	0，1，2，3, 4, 5, 6, 16, 17, 18
 */
 )
 
@@ -90,6 +97,34 @@ const (
 const (
 	RPCREQ  uint8 = 0
 	RPCRESP uint8 = 1
+	// RPCSTREAM 流数据(双向), 通过 CmdSeq 关联到流.
+	RPCSTREAM uint8 = 2
+	// RPCCTRL 流控制, Code 为 CTRLOPEN/CTRLEND/CTRLCANCEL/CTRLCREDIT/CTRLGOAWAY.
+	RPCCTRL uint8 = 3
+)
+
+// 流控制类型(RPCCTRL 的 Code)
+const (
+	// CTRLOPEN 客户端打开流
+	CTRLOPEN uint64 = 1
+	// CTRLEND 客户端发送结束(服务端以 RPCRESP 结束流)
+	CTRLEND uint64 = 2
+	// CTRLCANCEL 取消流(双向)
+	CTRLCANCEL uint64 = 3
+	// CTRLCREDIT 增加对端的发送额度, Payload 为 Varint 编码的报文数
+	CTRLCREDIT uint64 = 4
+	// CTRLGOAWAY 服务端关闭前通知客户端(CmdID, CmdSeq 为 0, 无载荷), 客户端收到后不再发起新的调用, 等待中的调用(包括流)结束后断开连接
+	CTRLGOAWAY uint64 = 5
+)
+
+// 流报文方向(CmdPacket.FrameDir)
+const (
+	// FRAMEC2S 客户端发送
+	FRAMEC2S uint8 = 0
+	// FRAMES2C 服务端发送
+	FRAMES2C uint8 = 1
+	// MAXFRAME 每个方向流报文计数的最大值, 不允许回绕
+	MAXFRAME uint64 = 1<<32 - 1
 )
 
 // 协议标志位
@@ -100,6 +135,7 @@ const (
 	FLAGAV       uint8 = 8
 	FLAGCUSTOMER uint8 = 8
 	FLAGFILE     uint8 = 8
+	FLAGKX       uint8 = 8
 )
 
 // 错误代码
@@ -118,6 +154,8 @@ const (
 
 	// MAXUDPBUFSIZE UDP最大报大小
 	MAXUDPBUFSIZE int = 1500
+	// maxUDPReadSize 未实现 MessageReader 时的读缓存(UDP报文的最大长度)
+	maxUDPReadSize int = 65535
 )
 
 //AESKEYPREFIX 加密Key前导
@@ -130,6 +168,9 @@ func init() {
 
 //aesValidityCheck AES值合法性检查()
 func aesValidityCheck(v uint8) bool {
+	if IsAEAD(v) {
+		return true
+	}
 	if v < AESNONE || v > AES256CFB {
 		return false
 	}
@@ -138,7 +179,7 @@ func aesValidityCheck(v uint8) bool {
 
 //rpcTypeValidityCheck 检查控制报文类型
 func rpcTypeValidityCheck(v uint8) bool {
-	if v != RPCREQ && v != RPCRESP {
+	if v > RPCCTRL {
 		return false
 	}
 	return true
@@ -205,7 +246,7 @@ func decodeVarint(r io.Reader, bytelen int) (l uint64, decLength []byte) {
 // checkFirstByte 检查协议的第一位
 func checkFirstByte(in byte) (types, flag uint8, err error) {
 	types = uint8(in & 0xf0 >> 4)
-	if types < 3 || types > 8 {
+	if types < TYPEHB || types > TYPEKX {
 		err = fmt.Errorf("types: %d, not support", types)
 		return
 	}
@@ -224,7 +265,7 @@ func decodeCMDFlag(r io.Reader) (encType, rpcType uint8, rb byte, err error) {
 		return
 	}
 	rb = b[0]
-	encType = uint8(b[0] >> 2)
+	encType = uint8(b[0] >> 2 & 0x1f)
 	rpcType = uint8(b[0] & 0x03)
 	return
 }
@@ -0,0 +1,169 @@
+package packets
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+CmdPacket 扩展段
+
+标志字节(EncType<<2 | RPCType)的最高位为 1 时, VarHeader 在 Code 之后包含扩展段:
+
+	Length|Varint|扩展段长度
+	TLV...|      |Type(Varint) + Length(Varint) + Value
+
+没有扩展数据时不设置该标志, 编码与旧版本一致. 未知的 Type 忽略.
+
+扩展段作为附加认证数据不加密. 加密(EncType 不为 AESNONE)的报文, 元数据随载荷一起加密:
+扩展段只包含 EXTSEALED, 加密前的载荷为 Length(Varint) + 元数据 TLV... + 原载荷.
+*/
+
+// cmdFlagExt 标志字节最高位: VarHeader 包含扩展段
+const cmdFlagExt byte = 0x80
+
+// 扩展段类型
+const (
+	// EXTTIMEOUT 请求剩余超时时间(毫秒), Varint
+	EXTTIMEOUT uint64 = 1
+	// EXTMETADATA 元数据, 每个键值对一项: KeyLength(Varint) + Key + Value
+	EXTMETADATA uint64 = 2
+	// EXTFRAME 流报文计数和方向, Varint(Frame<<1 | FrameDir)
+	EXTFRAME uint64 = 3
+	// EXTSEALED 元数据在加密的载荷中, 无 Value
+	EXTSEALED uint64 = 4
+)
+
+// maxExtLength 扩展段最大长度
+const maxExtLength uint64 = 65535
+
+// hasExt 是否需要编码扩展段
+func (cmd *CmdPacket) hasExt() bool {
+	return cmd.timeout() > 0 || len(cmd.Metadata) > 0 || cmd.Frame > 0
+}
+
+// timeout 只有请求携带超时时间(应答复用请求报文时不编码)
+func (cmd *CmdPacket) timeout() uint64 {
+	if cmd.RPCType != RPCREQ {
+		return 0
+	}
+	return cmd.Timeout
+}
+
+// packExt 编码扩展段(不含长度).
+func (cmd *CmdPacket) packExt() []byte {
+	var ext []byte
+	if cmd.timeout() > 0 {
+		ext = appendTLV(ext, EXTTIMEOUT, proto.EncodeVarint(cmd.timeout()))
+	}
+	if cmd.Frame > 0 {
+		ext = appendTLV(ext, EXTFRAME, proto.EncodeVarint(cmd.Frame<<1|uint64(cmd.FrameDir&1)))
+	}
+	if cmd.sealMetadata() {
+		return appendTLV(ext, EXTSEALED, nil)
+	}
+	return cmd.packMetadata(ext)
+}
+
+// sealMetadata 元数据是否随载荷加密.
+func (cmd *CmdPacket) sealMetadata() bool {
+	return len(cmd.Metadata) > 0 && cmd.AutoCrypt && cmd.EncType != AESNONE
+}
+
+// packMetadata 编码元数据 TLV, 追加到 ext.
+func (cmd *CmdPacket) packMetadata(ext []byte) []byte {
+	keys := make([]string, 0, len(cmd.Metadata))
+	for k := range cmd.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := proto.EncodeVarint(uint64(len(k)))
+		v = append(v, k...)
+		v = append(v, cmd.Metadata[k]...)
+		ext = appendTLV(ext, EXTMETADATA, v)
+	}
+	return ext
+}
+
+// unpackExt 解码扩展段(不含长度).
+func (cmd *CmdPacket) unpackExt(ext []byte) error {
+	for len(ext) > 0 {
+		t, n := proto.DecodeVarint(ext)
+		if n == 0 {
+			return fmt.Errorf("ext type invalid")
+		}
+		ext = ext[n:]
+		l, n := proto.DecodeVarint(ext)
+		if n == 0 || l > uint64(len(ext)-n) {
+			return fmt.Errorf("ext type: %d, length invalid", t)
+		}
+		v := ext[n : n+int(l)]
+		ext = ext[n+int(l):]
+
+		switch t {
+		case EXTTIMEOUT:
+			cmd.Timeout, _ = proto.DecodeVarint(v)
+		case EXTSEALED:
+			cmd.sealed = true
+		case EXTFRAME:
+			f, _ := proto.DecodeVarint(v)
+			cmd.Frame, cmd.FrameDir = f>>1, uint8(f&1)
+		case EXTMETADATA:
+			kl, n := proto.DecodeVarint(v)
+			if n == 0 || kl > uint64(len(v)-n) {
+				return fmt.Errorf("ext metadata invalid")
+			}
+			if cmd.Metadata == nil {
+				cmd.Metadata = make(map[string]string)
+			}
+			cmd.Metadata[string(v[n:n+int(kl)])] = string(v[n+int(kl):])
+		}
+	}
+	return nil
+}
+
+// sealedBody 加密前的载荷: 元数据 + 原载荷.
+func (cmd *CmdPacket) sealedBody() ([]byte, error) {
+	md := cmd.packMetadata(nil)
+	if uint64(len(md)) > maxExtLength {
+		return nil, fmt.Errorf("metadata length: %d, overflow(%d)", len(md), maxExtLength)
+	}
+	body := proto.EncodeVarint(uint64(len(md)))
+	body = append(body, md...)
+	return append(body, cmd.Payload...), nil
+}
+
+// unseal 从解密后的载荷中取出元数据.
+func (cmd *CmdPacket) unseal() error {
+	l, n := proto.DecodeVarint(cmd.Payload)
+	if n == 0 || l > uint64(len(cmd.Payload)-n) {
+		return fmt.Errorf("sealed metadata length invalid")
+	}
+	md := cmd.Payload[n : n+int(l)]
+	cmd.Payload = cmd.Payload[n+int(l):]
+	return cmd.unpackExt(md)
+}
+
+func appendTLV(b []byte, t uint64, v []byte) []byte {
+	b = append(b, proto.EncodeVarint(t)...)
+	b = append(b, proto.EncodeVarint(uint64(len(v)))...)
+	return append(b, v...)
+}
+
+// readExt 读取扩展段(含长度), max 为剩余报文长度.
+func readExt(r io.Reader, max uint64) (ext []byte, varHeader []byte, err error) {
+	var l uint64
+	l, varHeader = decodeVarintDef(r)
+	if l+uint64(len(varHeader)) > max || l > maxExtLength {
+		err = fmt.Errorf("ext length: %d, overflow", l)
+		return
+	}
+	ext = make([]byte, l)
+	_, err = io.ReadFull(r, ext)
+	varHeader = append(varHeader, ext...)
+	return
+}
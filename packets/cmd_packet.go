@@ -12,21 +12,29 @@ import (
 // CmdPacket 控制报文
 type CmdPacket struct {
 	FixHeader
-	AutoCrypt bool   // false: 不自动处理加解密; true: 自动处理加解密
-	CmdSeq    uint64 // 命令序列号
-	CmdID     uint64 // 命令ID
-	CmdName   string // 命令ID对应的名称
-	EncType   uint8  // 加密类型
-	RPCType   uint8  // 控制报文类型: 0 请求; 1 应答
-	Code      uint64 // RPCType == 1 存在该值
+	AutoCrypt bool              // false: 不自动处理加解密; true: 自动处理加解密
+	CmdSeq    uint64            // 命令序列号
+	CmdID     uint64            // 命令ID
+	CmdName   string            // 命令ID对应的名称
+	EncType   uint8             // 加密类型
+	RPCType   uint8             // 控制报文类型: 0 请求; 1 应答; 2 流数据; 3 流控制
+	Code      uint64            // RPCType == 1 或 3 存在该值
+	Timeout   uint64            // 请求剩余超时时间(毫秒), 0 不限制; 通过扩展段传输
+	Metadata  map[string]string // 元数据(请求和应答), 通过扩展段传输; 加密的报文随载荷加密, AutoCrypt 为 false 时不解码
+	Frame     uint64            // 流报文计数(每个方向从 1 递增, 不超过 MAXFRAME), 0 表示非流报文; 通过扩展段传输
+	FrameDir  uint8             // 流报文方向: FRAMEC2S, FRAMES2C
 	VarHeader []byte
 	Key       []byte // 加密用Key,固定部分
+	SessKey   []byte // 会话密钥(密钥协商产生), 不为空时代替 Key
+	SessDir   uint8  // 发送方向(FRAMEC2S, FRAMES2C), 使用会话密钥时参与加密Key和nonce的生成; 读写时由连接设置, 不传输
 	Md5Byte   []byte // 加密使用的key
 	EnKey     []byte // 加密最后使用的Key
 	//CipherPyaload []byte // 加密协议载荷
 	//TextPayload   []byte // 明文协议载荷
 	Payload    []byte // 存放协议载荷
 	RAWPayload []byte // 存放原始数据
+
+	sealed bool // 元数据在加密的载荷中(EXTSEALED)
 }
 
 // NewCmdPacket  creates a new CmdPacket.
@@ -46,11 +54,20 @@ func NewCmdPacket(t uint8) (cmd *CmdPacket) {
 }
 
 func (cmd *CmdPacket) Write(w io.Writer) (int64, error) {
+	key, dir := sessionOf(w)
+	if len(cmd.SessKey) == 0 {
+		cmd.SessKey = key
+	}
+	if len(key) > 0 {
+		// 复用读取的报文(如应答)时, 发送方向由写入的连接决定
+		cmd.SessDir = dir
+	}
 	packet, err := cmd.Pack()
 	if err != nil {
 		return 0, err
 	}
 	n, err := packet.WriteTo(w)
+	countOut(cmd.MessageType, n)
 	return n, err
 }
 
@@ -68,19 +85,45 @@ func (cmd *CmdPacket) Pack() (packet bytes.Buffer, err error) {
 		err = fmt.Errorf("CmdID(%d),CmdSeq(%d),Code(%d); Overflow(%d)", cmd.CmdID, cmd.CmdSeq, cmd.Code, defmaxValue)
 		return
 	}
+	if cmd.Frame > MAXFRAME || cmd.FrameDir > FRAMES2C {
+		err = fmt.Errorf("CmdID: %d, CmdSeq: %d, Frame(%d),FrameDir(%d) invalid", cmd.CmdID, cmd.CmdSeq, cmd.Frame, cmd.FrameDir)
+		return
+	}
 
 	// VarHeader
 	cmd.VarHeader = []byte{}
 	cmd.VarHeader = append(cmd.VarHeader, proto.EncodeVarint(cmd.CmdSeq)...)
 	cmd.VarHeader = append(cmd.VarHeader, proto.EncodeVarint(cmd.CmdID)...)
-	cmd.VarHeader = append(cmd.VarHeader, uint8(cmd.EncType<<2|cmd.RPCType))
-	if cmd.RPCType == RPCRESP {
+	flag := uint8(cmd.EncType<<2 | cmd.RPCType)
+	if cmd.hasExt() {
+		flag |= cmdFlagExt
+	}
+	cmd.VarHeader = append(cmd.VarHeader, flag)
+	if cmd.hasCode() {
 		cmd.VarHeader = append(cmd.VarHeader, proto.EncodeVarint(cmd.Code)...)
 	}
+	if cmd.hasExt() {
+		ext := cmd.packExt()
+		if uint64(len(ext)) > maxExtLength {
+			err = fmt.Errorf("ext length: %d, overflow(%d)", len(ext), maxExtLength)
+			return
+		}
+		cmd.VarHeader = append(cmd.VarHeader, proto.EncodeVarint(uint64(len(ext)))...)
+		cmd.VarHeader = append(cmd.VarHeader, ext...)
+	}
 
-	if len(cmd.Payload) > 0 && cmd.AutoCrypt {
+	body := cmd.Payload
+	if cmd.sealMetadata() {
+		if body, err = cmd.sealedBody(); err != nil {
+			return
+		}
+	}
+	if len(body) > 0 && cmd.AutoCrypt {
+		if err = checkSessionKey(cmd.EncType, cmd.SessKey); err != nil {
+			return
+		}
 		cmd.GetCryptoKey()
-		cmd.Payload, err = Encrypt(cmd.EncType, cmd.EnKey, cmd.EnKey, cmd.Payload)
+		cmd.Payload, err = encryptPayload(cmd.EncType, cmd.EnKey, cmd.nonce(), cmd.VarHeader, body)
 		if err != nil {
 			return
 		}
@@ -118,10 +161,24 @@ func (cmd *CmdPacket) Unpack(r io.Reader) error {
 	if rpcTypeValidityCheck(cmd.RPCType) == false {
 		return fmt.Errorf("RPCType not support: %d", cmd.RPCType)
 	}
-	if cmd.RPCType == RPCRESP {
+	if cmd.hasCode() {
 		cmd.Code, varHeader = decodeVarintDef(r)
 		cmd.VarHeader = append(cmd.VarHeader, varHeader...)
 	}
+	if uint64(len(cmd.VarHeader)) > payloadLength {
+		return fmt.Errorf("CmdID: %d, CmdSeq: %d, VarHeader length overflow", cmd.CmdID, cmd.CmdSeq)
+	}
+	if b&cmdFlagExt != 0 {
+		var ext []byte
+		ext, varHeader, err = readExt(r, payloadLength-uint64(len(cmd.VarHeader)))
+		if err != nil {
+			return err
+		}
+		cmd.VarHeader = append(cmd.VarHeader, varHeader...)
+		if err = cmd.unpackExt(ext); err != nil {
+			return fmt.Errorf("CmdID: %d, CmdSeq: %d, %s", cmd.CmdID, cmd.CmdSeq, err)
+		}
+	}
 	payloadLength = payloadLength - uint64(len(cmd.VarHeader))
 	cmd.RAWPayload = make([]byte, payloadLength)
 	_, err = r.Read(cmd.RAWPayload)
@@ -130,8 +187,18 @@ func (cmd *CmdPacket) Unpack(r io.Reader) error {
 	}
 
 	if len(cmd.RAWPayload) > 0 && cmd.AutoCrypt {
+		if err = checkSessionKey(cmd.EncType, cmd.SessKey); err != nil {
+			return fmt.Errorf("CmdID: %d, CmdSeq: %d, %w", cmd.CmdID, cmd.CmdSeq, err)
+		}
 		cmd.GetCryptoKey()
-		cmd.Payload, err = Decrypt(cmd.EncType, cmd.EnKey, cmd.EnKey, cmd.RAWPayload)
+		cmd.Payload, err = decryptPayload(cmd.EncType, cmd.EnKey, cmd.nonce(), cmd.VarHeader, cmd.RAWPayload)
+		if err != nil {
+			err = fmt.Errorf("CmdID: %d, CmdSeq: %d, decrypt: %w", cmd.CmdID, cmd.CmdSeq, err)
+		} else if cmd.sealed {
+			if err = cmd.unseal(); err != nil {
+				err = fmt.Errorf("CmdID: %d, CmdSeq: %d, %s", cmd.CmdID, cmd.CmdSeq, err)
+			}
+		}
 	} else if len(cmd.RAWPayload) > 0 && cmd.AutoCrypt == false {
 		cmd.Payload = cmd.RAWPayload
 	}
@@ -151,8 +218,30 @@ func (cmd *CmdPacket) Debug() string {
 func (cmd *CmdPacket) GetCryptoKey() {
 	//md5(fmt.Sprintf("%s,ID:%d-SEQ:%d-RPC:%d", "P2p0r1p8c0622",cmd.CmdID, cmd.CmdSeq, cmd.RPCType))
 	_t := fmt.Sprintf(",ID:%d-SEQ:%d-RPC:%d", cmd.CmdID, cmd.CmdSeq, cmd.RPCType)
+	if cmd.Frame > 0 {
+		// 流报文: 同一个流的每个报文(两个方向)使用不同的Key
+		_t += fmt.Sprintf("-DIR:%d-FRM:%d", cmd.FrameDir, cmd.Frame)
+	}
+	if len(cmd.SessKey) > 0 {
+		// 会话密钥: 两个方向使用不同的Key
+		cmd.EnKey = sessionCryptoKey(cmd.SessKey, _t+fmt.Sprintf("-SND:%d", cmd.SessDir))
+		return
+	}
 	cmd.Md5Byte = []byte{}
 	cmd.Md5Byte = append(cmd.Md5Byte, cmd.Key...)
 	cmd.Md5Byte = append(cmd.Md5Byte, []byte(_t)...)
 	cmd.EnKey = []byte(ppcrypto.MD5(cmd.Md5Byte))
 }
+
+// nonce AEAD nonce: RPCType + SessDir + CmdSeq; 流报文: RPCType + FrameDir + CmdSeq + Frame.
+func (cmd *CmdPacket) nonce() []byte {
+	if cmd.Frame > 0 {
+		return seqNonce(uint32(cmd.RPCType)|uint32(cmd.FrameDir)<<8, cmd.CmdSeq<<32|cmd.Frame)
+	}
+	return seqNonce(uint32(cmd.RPCType)|uint32(cmd.SessDir)<<8, cmd.CmdSeq)
+}
+
+// hasCode VarHeader 是否包含 Code(应答和流控制).
+func (cmd *CmdPacket) hasCode() bool {
+	return cmd.RPCType == RPCRESP || cmd.RPCType == RPCCTRL
+}
@@ -1,11 +1,35 @@
 package packets
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
 
 	ppcrypto "github.com/pprpc/util/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
+// ErrAuthFailed AEAD 解密认证失败(数据被篡改或密钥不一致).
+var ErrAuthFailed = errors.New("packets: message authentication failed")
+
+// ErrSessionKeyRequired AEAD 加密类型需要会话密钥(密钥协商):
+// 固定前导密钥(AESKEYPREFIX)派生的Key和nonce在不同连接之间重复.
+var ErrSessionKeyRequired = errors.New("packets: AEAD requires session key")
+
+// AEADNONCESIZE AEAD nonce 长度
+const AEADNONCESIZE = 12
+
+// sessionCryptoKey 由会话密钥派生单个报文的加密Key(32字节).
+func sessionCryptoKey(sessKey []byte, info string) []byte {
+	mac := hmac.New(sha256.New, sessKey)
+	mac.Write([]byte(info))
+	return mac.Sum(nil)
+}
+
 // Encrypt 加密数据.
 func Encrypt(encType uint8, key, iv []byte, payload []byte) (out []byte, err error) {
 	if len(key) != 32 || len(iv) != 32 {
@@ -79,3 +103,139 @@ func Decrypt(encType uint8, key, iv []byte, payload []byte) (out []byte, err err
 	}
 	return
 }
+
+// IsAEAD 是否为 AEAD 加密类型.
+func IsAEAD(encType uint8) bool {
+	return encType == AES128GCM || encType == AES256GCM || encType == CHACHA20POLY1305
+}
+
+func newAEAD(encType uint8, key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key length not match")
+	}
+	switch encType {
+	case AES128GCM:
+		block, err := aes.NewCipher(key[:16])
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case AES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CHACHA20POLY1305:
+		return chacha20poly1305.New(key)
+	}
+	return nil, fmt.Errorf("not support AEAD EncType: %d", encType)
+}
+
+// Seal AEAD 加密数据, nonce 长度为 AEADNONCESIZE, ad 为附加认证数据.
+func Seal(encType uint8, key, nonce, ad, payload []byte) (out []byte, err error) {
+	aead, err := newAEAD(encType, key)
+	if err != nil {
+		return
+	}
+	if len(nonce) != aead.NonceSize() {
+		err = fmt.Errorf("nonce length not match")
+		return
+	}
+	out = aead.Seal(nil, nonce, payload, ad)
+	return
+}
+
+// Open AEAD 解密数据, 认证失败返回 ErrAuthFailed.
+func Open(encType uint8, key, nonce, ad, payload []byte) (out []byte, err error) {
+	aead, err := newAEAD(encType, key)
+	if err != nil {
+		return
+	}
+	if len(nonce) != aead.NonceSize() {
+		err = fmt.Errorf("nonce length not match")
+		return
+	}
+	out, err = aead.Open(nil, nonce, payload, ad)
+	if err != nil {
+		err = ErrAuthFailed
+	}
+	return
+}
+
+// checkSessionKey AEAD 加密类型没有会话密钥时返回 ErrSessionKeyRequired.
+func checkSessionKey(encType uint8, sessKey []byte) error {
+	if IsAEAD(encType) && len(sessKey) == 0 {
+		return ErrSessionKeyRequired
+	}
+	return nil
+}
+
+// seqNonce 由报文序列号生成 AEAD nonce: v(4字节) + seq(8字节).
+func seqNonce(v uint32, seq uint64) []byte {
+	nonce := make([]byte, AEADNONCESIZE)
+	binary.BigEndian.PutUint32(nonce, v)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+// encryptPayload 按加密类型加密载荷, AEAD 使用 nonce 和 ad.
+func encryptPayload(encType uint8, key, nonce, ad, payload []byte) ([]byte, error) {
+	if IsAEAD(encType) {
+		return Seal(encType, key, nonce, ad, payload)
+	}
+	return Encrypt(encType, key, key, payload)
+}
+
+// decryptPayload 按加密类型解密载荷, AEAD 使用 nonce 和 ad.
+func decryptPayload(encType uint8, key, nonce, ad, payload []byte) ([]byte, error) {
+	if IsAEAD(encType) {
+		return Open(encType, key, nonce, ad, payload)
+	}
+	return Decrypt(encType, key, key, payload)
+}
+
+// CipherLength 明文长度为 n 时加密后的数据长度.
+func CipherLength(encType uint8, n int) int {
+	switch {
+	case encType >= AES128CBC && encType <= AES256CBC:
+		return n + aes.BlockSize - n%aes.BlockSize
+	case IsAEAD(encType):
+		return n + 16
+	}
+	return n
+}
+
+// suffixAD AEAD 加密部分数据时, 明文部分追加到附加认证数据, 篡改明文部分同样认证失败.
+func suffixAD(encType uint8, ad, suffix []byte) []byte {
+	if !IsAEAD(encType) || len(suffix) == 0 {
+		return ad
+	}
+	return append(ad[:len(ad):len(ad)], suffix...)
+}
+
+// encryptPrefix 加密载荷的前 encLength 字节(0 表示全部), 其余部分保持明文(AEAD 时参与认证).
+func encryptPrefix(encType uint8, key, nonce, ad, payload []byte, encLength uint64) ([]byte, error) {
+	n := len(payload)
+	if encLength > 0 && encLength < uint64(n) {
+		n = int(encLength)
+	}
+	out, err := encryptPayload(encType, key, nonce, suffixAD(encType, ad, payload[n:]), payload[:n])
+	if err != nil {
+		return nil, err
+	}
+	return append(out, payload[n:]...), nil
+}
+
+// decryptPrefix 解密 encryptPrefix 加密的数据, encLength 为加密部分的明文长度.
+func decryptPrefix(encType uint8, key, nonce, ad, raw []byte, encLength uint64) ([]byte, error) {
+	n := len(raw)
+	if encLength > 0 && uint64(CipherLength(encType, int(encLength))) < uint64(n) {
+		n = CipherLength(encType, int(encLength))
+	}
+	out, err := decryptPayload(encType, key, nonce, suffixAD(encType, ad, raw[n:]), raw[:n])
+	if err != nil {
+		return nil, err
+	}
+	return append(out, raw[n:]...), nil
+}
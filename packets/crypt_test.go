@@ -0,0 +1,244 @@
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// keyedBuffer 模拟完成密钥协商的连接, dir 为本端发送方向.
+type keyedBuffer struct {
+	bytes.Buffer
+	key []byte
+	dir uint8
+}
+
+func (b *keyedBuffer) SessionKey() []byte {
+	return b.key
+}
+
+func (b *keyedBuffer) SessionDir() uint8 {
+	return b.dir
+}
+
+var aeadTypes = []uint8{AES128GCM, AES256GCM, CHACHA20POLY1305}
+
+func testCmdPacket(encType uint8) *CmdPacket {
+	cmd := NewCmdPacket(TYPEPBBIN)
+	cmd.CmdSeq = 7
+	cmd.CmdID = 42
+	cmd.EncType = encType
+	cmd.RPCType = RPCREQ
+	cmd.Metadata = map[string]string{"authorization": "secret-token"}
+	cmd.Payload = []byte("hello aead")
+	return cmd
+}
+
+// packCmd 编码报文, 返回报文和 VarHeader 在报文中的位置.
+func packCmd(t *testing.T, cmd *CmdPacket, key []byte) ([]byte, int) {
+	t.Helper()
+	w := &keyedBuffer{key: key}
+	if _, err := cmd.Write(w); err != nil {
+		t.Fatal(err)
+	}
+	raw := w.Bytes()
+	return raw, len(raw) - len(cmd.Payload) - len(cmd.VarHeader)
+}
+
+// keyedReader 对端(服务端)读取 keyedBuffer(客户端)写入的报文.
+func keyedReader(raw, key []byte) *keyedBuffer {
+	b := &keyedBuffer{key: key, dir: FRAMES2C}
+	b.Write(raw)
+	return b
+}
+
+func readCmd(raw, key []byte) (*CmdPacket, error) {
+	pp, err := ReadTCPPacket(keyedReader(raw, key))
+	cmd, _ := pp.(*CmdPacket)
+	return cmd, err
+}
+
+func TestCmdPacketAEAD(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	for _, encType := range aeadTypes {
+		raw, _ := packCmd(t, testCmdPacket(encType), key)
+		if bytes.Contains(raw, []byte("hello aead")) || bytes.Contains(raw, []byte("secret-token")) {
+			t.Fatalf("encType: %d, plaintext in packet", encType)
+		}
+		cmd, err := readCmd(raw, key)
+		if err != nil {
+			t.Fatalf("encType: %d, %s", encType, err)
+		}
+		if string(cmd.Payload) != "hello aead" || cmd.Metadata["authorization"] != "secret-token" {
+			t.Fatalf("encType: %d, payload: %q, metadata: %v", encType, cmd.Payload, cmd.Metadata)
+		}
+	}
+}
+
+func TestCmdPacketAuthFailed(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	for _, encType := range aeadTypes {
+		raw, vh := packCmd(t, testCmdPacket(encType), key)
+		for name, tamper := range map[string]func([]byte) ([]byte, []byte){
+			"payload": func(b []byte) ([]byte, []byte) { b[len(b)-1] ^= 1; return b, key },
+			"header":  func(b []byte) ([]byte, []byte) { b[vh] ^= 1; return b, key },
+			"key":     func(b []byte) ([]byte, []byte) { return b, []byte("another session key") },
+		} {
+			b, k := tamper(append([]byte(nil), raw...))
+			if _, err := readCmd(b, k); !errors.Is(err, ErrAuthFailed) {
+				t.Fatalf("encType: %d, %s: %v", encType, name, err)
+			}
+		}
+	}
+}
+
+func TestCmdPacketSessionKeyRequired(t *testing.T) {
+	for _, encType := range aeadTypes {
+		var b bytes.Buffer
+		if _, err := testCmdPacket(encType).Write(&b); !errors.Is(err, ErrSessionKeyRequired) {
+			t.Fatalf("encType: %d, write without session key: %v", encType, err)
+		}
+		raw, _ := packCmd(t, testCmdPacket(encType), []byte("0123456789abcdef0123456789abcdef"))
+		if _, err := ReadTCPPacket(bytes.NewBuffer(raw)); !errors.Is(err, ErrSessionKeyRequired) {
+			t.Fatalf("encType: %d, read without session key: %v", encType, err)
+		}
+	}
+	// 非 AEAD 加密类型兼容旧版本
+	var b bytes.Buffer
+	if _, err := testCmdPacket(AES256CFB).Write(&b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCmdPacketSessionDir(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	for _, encType := range aeadTypes {
+		// 客户端和服务端发起的调用使用相同的 CmdSeq
+		c2s, _ := packCmd(t, testCmdPacket(encType), key)
+		s := &keyedBuffer{key: key, dir: FRAMES2C}
+		if _, err := testCmdPacket(encType).Write(s); err != nil {
+			t.Fatal(err)
+		}
+		s2c := s.Bytes()
+		if bytes.Equal(c2s, s2c) {
+			t.Fatalf("encType: %d, same ciphertext in both directions", encType)
+		}
+		// 客户端读取服务端发送的报文
+		r := &keyedBuffer{key: key, dir: FRAMEC2S}
+		r.Write(s2c)
+		if _, err := ReadTCPPacket(r); err != nil {
+			t.Fatalf("encType: %d, %s", encType, err)
+		}
+		// 报文被反射给发送方
+		r = &keyedBuffer{key: key, dir: FRAMEC2S}
+		r.Write(c2s)
+		if _, err := ReadTCPPacket(r); !errors.Is(err, ErrAuthFailed) {
+			t.Fatalf("encType: %d, reflected: %v", encType, err)
+		}
+	}
+}
+
+// encLengths EncLength 的测试用例: 0(全部), 小于, 等于, 大于载荷长度.
+var encLengths = []uint64{0, 16, 64, 100}
+
+func testPayload() []byte {
+	b := make([]byte, 64)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+// clearLength 明文部分的长度
+func clearLength(n int, encLength uint64) int {
+	if encLength > 0 && encLength < uint64(n) {
+		return n - int(encLength)
+	}
+	return 0
+}
+
+func TestEncryptPrefix(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	nonce := seqNonce(1, 2)
+	ad := []byte("header")
+	for _, encType := range append([]uint8{AES256CBC, AES256CFB}, aeadTypes...) {
+		for _, el := range encLengths {
+			payload := testPayload()
+			raw, err := encryptPrefix(encType, key, nonce, ad, payload, el)
+			if err != nil {
+				t.Fatalf("EncType: %d, EncLength: %d, %s", encType, el, err)
+			}
+			clear := clearLength(len(payload), el)
+			if want := CipherLength(encType, len(payload)-clear) + clear; len(raw) != want {
+				t.Fatalf("EncType: %d, EncLength: %d, length: %d, want: %d", encType, el, len(raw), want)
+			}
+			if !bytes.Equal(raw[len(raw)-clear:], payload[len(payload)-clear:]) {
+				t.Fatalf("EncType: %d, EncLength: %d, clear part changed", encType, el)
+			}
+			out, err := decryptPrefix(encType, key, nonce, ad, raw, el)
+			if err != nil || !bytes.Equal(out, payload) {
+				t.Fatalf("EncType: %d, EncLength: %d, decrypt: %x, %v", encType, el, out, err)
+			}
+			if !IsAEAD(encType) || clear == 0 {
+				continue
+			}
+			// 明文部分参与认证
+			raw[len(raw)-1] ^= 1
+			if _, err = decryptPrefix(encType, key, nonce, ad, raw, el); !errors.Is(err, ErrAuthFailed) {
+				t.Fatalf("EncType: %d, EncLength: %d, tampered clear part: %v", encType, el, err)
+			}
+		}
+	}
+}
+
+func TestPacketEncLength(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	newAV := func(encType uint8, el uint64) PPPacket {
+		av := NewAVPacket()
+		av.AutoCrypt = true
+		av.AVChannel, av.AVSeq, av.Timestamp = 1, 2, 3
+		av.EncType, av.EncLength = encType, el
+		av.Payload = testPayload()
+		return av
+	}
+	newFile := func(encType uint8, el uint64) PPPacket {
+		fp := NewFilePacket()
+		fp.AutoCrypt = true
+		fp.FileID, fp.Offset = 1, 1024
+		fp.EncryptType, fp.EncryptLength = encType, el
+		fp.Payload = testPayload()
+		return fp
+	}
+	payloadOf := func(pp PPPacket) []byte {
+		switch p := pp.(type) {
+		case *AVPacket:
+			return p.Payload
+		case *FilePacket:
+			return p.Payload
+		}
+		return nil
+	}
+
+	for name, newPkg := range map[string]func(uint8, uint64) PPPacket{"AV": newAV, "File": newFile} {
+		for _, encType := range []uint8{AES256CFB, AES256GCM} {
+			for _, el := range encLengths {
+				w := &keyedBuffer{key: key}
+				if _, err := newPkg(encType, el).Write(w); err != nil {
+					t.Fatalf("%s, EncType: %d, EncLength: %d, %s", name, encType, el, err)
+				}
+				raw := w.Bytes()
+				pp, err := ReadTCPPacketAdv(keyedReader(raw, key), true)
+				if err != nil || !bytes.Equal(payloadOf(pp), testPayload()) {
+					t.Fatalf("%s, EncType: %d, EncLength: %d, payload: %x, %v", name, encType, el, payloadOf(pp), err)
+				}
+				if !IsAEAD(encType) || clearLength(len(testPayload()), el) == 0 {
+					continue
+				}
+				raw[len(raw)-1] ^= 1
+				if _, err = ReadTCPPacketAdv(keyedReader(raw, key), true); !errors.Is(err, ErrAuthFailed) {
+					t.Fatalf("%s, EncType: %d, EncLength: %d, tampered clear part: %v", name, encType, el, err)
+				}
+			}
+		}
+	}
+}
@@ -29,6 +29,7 @@ func (cus *CustomerPacket) Write(w io.Writer) (int64, error) {
 		return 0, err
 	}
 	n, err := packet.WriteTo(w)
+	countOut(cus.MessageType, n)
 	return n, err
 }
 
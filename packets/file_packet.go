@@ -7,24 +7,43 @@ import (
 
 	"github.com/golang/protobuf/proto"
 	"github.com/pprpc/util/common"
+	ppcrypto "github.com/pprpc/util/crypto"
 )
 
 /*
 fileid|Varint|文件标识ID，用于唯一标识一个文件.
 offset|Varint| 后续内容相对于文件开始的偏移量.
-EncryptType|uint8|文件流数据加密类型,具体参见[9. 加密类型](#9-加密类型)详细定义
-EncryptLength|Varint|加密数据的长度,对于文件流加密的数据长度;0,表示Payload数据全部加密;最大值: 268435455
+EncryptType|uint8|文件流数据加密类型,具体参见[9. 加密类型](#9-加密类型)详细定义; 最高位为 1 表示服务端发送(Dir 为 FILES2C)
+EncryptLength|Varint|加密数据的长度,对于文件流加密的数据长度;0,表示Payload数据全部加密;其余数据不加密, AEAD 时作为附加认证数据;最大值: 268435455
 */
 
+// FilePacket 传输方向(Dir), 参与加密Key和nonce的生成: 同一个 FileID + Offset 两个方向使用不同的Key.
+const (
+	// FILEC2S 客户端发送(上传)
+	FILEC2S uint8 = 0
+	// FILES2C 服务端发送(下载)
+	FILES2C uint8 = 1
+)
+
+// fileFlagS2C EncryptType 字节最高位: Dir 为 FILES2C
+const fileFlagS2C byte = 0x80
+
 // FilePacket 自定义报文
 type FilePacket struct {
 	FixHeader
+	AutoCrypt     bool // false: 不自动处理加解密; true: 自动处理加解密
 	FileID        uint64
 	Offset        uint64
 	EncryptType   uint8
-	EncryptLength uint64
+	EncryptLength uint64 // 加密数据的长度, 0 表示全部加密; 其余数据不加密, AEAD 时参与认证
+	Dir           uint8  // 传输方向: FILEC2S, FILES2C
 	VarHeader     []byte
-	Payload       []byte
+	Key           []byte // 加密用Key,固定部分
+	SessKey       []byte // 会话密钥(密钥协商产生), 不为空时代替 Key
+	Md5Byte       []byte
+	EnKey         []byte
+	Payload       []byte // 存放协议载荷
+	RAWPayload    []byte // 存放原始数据
 }
 
 // NewFilePacket  creates a new FilePacket.
@@ -32,27 +51,61 @@ func NewFilePacket() (fp *FilePacket) {
 	fp = new(FilePacket)
 	fp.MessageType = TYPEFILE
 	fp.Flag = FLAGFILE
+	fp.AutoCrypt = false
+	fp.Key = AESKEYPREFIX
 
 	return
 }
 
 func (fp *FilePacket) Write(w io.Writer) (int64, error) {
+	if len(fp.SessKey) == 0 {
+		fp.SessKey, _ = sessionOf(w)
+	}
 	packet, err := fp.Pack()
 	if err != nil {
 		return 0, err
 	}
 	n, err := packet.WriteTo(w)
+	countOut(fp.MessageType, n)
 	return n, err
 }
 
-// Pack encode packet.
+// Pack 编码数据包，根据 AutoCrypt 自动处理加密, EncryptLength 不为0时只加密 Payload 的前 EncryptLength 字节.
 func (fp *FilePacket) Pack() (packet bytes.Buffer, err error) {
+	if aesValidityCheck(fp.EncryptType) == false {
+		err = fmt.Errorf("Crypt type not support: %d", fp.EncryptType)
+		return
+	}
+	if fp.EncryptLength > defmaxValue {
+		err = fmt.Errorf("EncryptLength(%d); Overflow(%d)", fp.EncryptLength, defmaxValue)
+		return
+	}
+	if fp.Dir > FILES2C {
+		err = fmt.Errorf("Dir: %d, not support", fp.Dir)
+		return
+	}
+
 	fp.VarHeader = []byte{}
 	fp.VarHeader = append(fp.VarHeader, proto.EncodeVarint(fp.FileID)...)
 	fp.VarHeader = append(fp.VarHeader, proto.EncodeVarint(fp.Offset)...)
-	fp.VarHeader = append(fp.VarHeader, fp.EncryptType)
+	flag := fp.EncryptType
+	if fp.Dir == FILES2C {
+		flag |= fileFlagS2C
+	}
+	fp.VarHeader = append(fp.VarHeader, flag)
 	fp.VarHeader = append(fp.VarHeader, proto.EncodeVarint(fp.EncryptLength)...)
 
+	if len(fp.Payload) > 0 && fp.AutoCrypt {
+		if err = checkSessionKey(fp.EncryptType, fp.SessKey); err != nil {
+			return
+		}
+		fp.GetCryptoKey()
+		fp.Payload, err = encryptPrefix(fp.EncryptType, fp.EnKey, fp.nonce(), fp.VarHeader, fp.Payload, fp.EncryptLength)
+		if err != nil {
+			return
+		}
+	}
+
 	fp.Length = uint64(len(fp.Payload) + len(fp.VarHeader))
 	packet, err = fp.FixHeader.Pack() // FixHeader
 	if err != nil {
@@ -64,7 +117,7 @@ func (fp *FilePacket) Pack() (packet bytes.Buffer, err error) {
 	return
 }
 
-// Unpack decode packet.
+// Unpack 解码数据包，根据 AutoCrypt 自动处理解密, EncryptLength 不为0时只解密前 EncryptLength 字节对应的密文.
 func (fp *FilePacket) Unpack(r io.Reader) error {
 	var varHeader []byte
 	var payloadLength = fp.FixHeader.Length
@@ -79,21 +132,53 @@ func (fp *FilePacket) Unpack(r io.Reader) error {
 	fp.Offset, varHeader = decodeUint64(r)
 	fp.VarHeader = append(fp.VarHeader, varHeader...)
 	// encrypt type
-	fp.EncryptType = decodeUint8(r)
-	fp.VarHeader = append(fp.VarHeader, fp.EncryptType)
+	flag := decodeUint8(r)
+	fp.VarHeader = append(fp.VarHeader, flag)
+	fp.EncryptType = flag &^ fileFlagS2C
+	if flag&fileFlagS2C != 0 {
+		fp.Dir = FILES2C
+	}
 	// encrypt length
 	varHeader = []byte{}
 	fp.EncryptLength, varHeader = decodeUint64(r)
 	fp.VarHeader = append(fp.VarHeader, varHeader...)
 	// Payload
 	payloadLength = payloadLength - uint64(len(fp.VarHeader))
-	fp.Payload = make([]byte, payloadLength)
-	_, err = r.Read(fp.Payload)
+	fp.RAWPayload = make([]byte, payloadLength)
+	_, err = r.Read(fp.RAWPayload)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if len(fp.RAWPayload) > 0 && fp.AutoCrypt {
+		if err = checkSessionKey(fp.EncryptType, fp.SessKey); err != nil {
+			return err
+		}
+		fp.GetCryptoKey()
+		fp.Payload, err = decryptPrefix(fp.EncryptType, fp.EnKey, fp.nonce(), fp.VarHeader, fp.RAWPayload, fp.EncryptLength)
+		if err != nil {
+			err = fmt.Errorf("FileID: %d, Offset: %d, decrypt: %w", fp.FileID, fp.Offset, err)
+		}
+	} else if len(fp.RAWPayload) > 0 && fp.AutoCrypt == false {
+		fp.Payload = fp.RAWPayload
+	}
+	return err
+}
+
+// Decrypt 解密读取时没有自动解密的 RAWPayload(连接的 AutoCrypt 为 false), 已经解密或者 EncryptType 为 AESNONE 时不处理.
+func (fp *FilePacket) Decrypt() (err error) {
+	if fp.AutoCrypt || fp.EncryptType == AESNONE || len(fp.RAWPayload) == 0 {
+		return nil
+	}
+	if err = checkSessionKey(fp.EncryptType, fp.SessKey); err != nil {
+		return
+	}
+	fp.GetCryptoKey()
+	fp.Payload, err = decryptPrefix(fp.EncryptType, fp.EnKey, fp.nonce(), fp.VarHeader, fp.RAWPayload, fp.EncryptLength)
+	if err != nil {
+		err = fmt.Errorf("FileID: %d, Offset: %d, decrypt: %w", fp.FileID, fp.Offset, err)
+	}
+	return
 }
 
 func (fp *FilePacket) String() string {
@@ -105,3 +190,25 @@ func (fp *FilePacket) Debug() string {
 	return fmt.Sprintf("types: %d, flag: %d, length: %d,RawHeader: %s, Payload: [%s].",
 		fp.MessageType, fp.Flag, fp.Length, common.ByteConvertString(fp.RawHeader), fp.Payload)
 }
+
+// GetCryptoKey get cryptoKey.
+func (fp *FilePacket) GetCryptoKey() {
+	// MD5(fmt.Sprintf("%s,FileID:%d-Offset:%d", "P2p0r1p8c0622", fp.FileID, fp.Offset))
+	_t := fmt.Sprintf(",FileID:%d-Offset:%d", fp.FileID, fp.Offset)
+	if fp.Dir == FILES2C {
+		_t += "-S2C"
+	}
+	if len(fp.SessKey) > 0 {
+		fp.EnKey = sessionCryptoKey(fp.SessKey, _t)
+		return
+	}
+	fp.Md5Byte = []byte{}
+	fp.Md5Byte = append(fp.Md5Byte, fp.Key...)
+	fp.Md5Byte = append(fp.Md5Byte, []byte(_t)...)
+	fp.EnKey = []byte(ppcrypto.MD5(fp.Md5Byte))
+}
+
+// nonce AEAD nonce: Dir(最高位) + FileID + Offset.
+func (fp *FilePacket) nonce() []byte {
+	return seqNonce(uint32(fp.FileID)&0x7fffffff|uint32(fp.Dir)<<31, fp.Offset)
+}
@@ -0,0 +1,39 @@
+package packets
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFilePacketDir(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	for _, dir := range []uint8{FILEC2S, FILES2C} {
+		fp := NewFilePacket()
+		fp.AutoCrypt = true
+		fp.FileID = 3
+		fp.Offset = 1024
+		fp.EncryptType = AES256GCM
+		fp.Dir = dir
+		fp.Payload = []byte("file chunk")
+		w := &keyedBuffer{key: key}
+		if _, err := fp.Write(w); err != nil {
+			t.Fatal(err)
+		}
+		raw := w.Bytes()
+
+		pp, err := ReadTCPPacketAdv(keyedReader(raw, key), true)
+		if err != nil {
+			t.Fatalf("dir: %d, %s", dir, err)
+		}
+		if got := pp.(*FilePacket); got.Dir != dir || string(got.Payload) != "file chunk" {
+			t.Fatalf("dir: %d, got dir: %d, payload: %q", dir, got.Dir, got.Payload)
+		}
+
+		// 另一个方向的同一个分块不能通过认证
+		// VarHeader 最后是 EncryptType(1字节) 和 EncryptLength(0, 1字节)
+		raw[len(raw)-len(fp.Payload)-2] ^= fileFlagS2C
+		if _, err = ReadTCPPacketAdv(keyedReader(raw, key), true); !errors.Is(err, ErrAuthFailed) {
+			t.Fatalf("dir: %d, flipped: %v", dir, err)
+		}
+	}
+}
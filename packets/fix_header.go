@@ -25,7 +25,7 @@ func (fh *FixHeader) String() string {
 
 // Pack 编码
 func (fh *FixHeader) Pack() (header bytes.Buffer, err error) {
-	if fh.MessageType < 3 || fh.MessageType > 8 {
+	if fh.MessageType < TYPEHB || fh.MessageType > TYPEKX {
 		err = fmt.Errorf("types: %d, not support", fh.MessageType)
 		return
 	}
@@ -29,6 +29,7 @@ func (hb *HBPacket) Write(w io.Writer) (int64, error) {
 		return 0, err
 	}
 	n, err := packet.WriteTo(w)
+	countOut(hb.MessageType, n)
 	return n, err
 }
 
@@ -0,0 +1,148 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/pprpc/util/common"
+)
+
+/*
+Version|uint8|协议版本, 当前为 1
+Flags|uint8|bit0: 存在MAC; bit1: 存在Signature
+PublicKey|32 bytes|X25519 临时公钥
+Nonce|16 bytes|随机数
+MAC|32 bytes|HMAC-SHA256(PSK, ...), Flags bit0 为1时存在
+Signature|64 bytes|Ed25519 签名(服务端), Flags bit1 为1时存在
+*/
+
+// 密钥协商
+const (
+	// KXVERSION 密钥协商协议版本
+	KXVERSION uint8 = 1
+
+	KXPUBLICKEYSIZE = 32
+	KXNONCESIZE     = 16
+	KXMACSIZE       = 32
+	KXSIGSIZE       = 64
+
+	kxFlagMAC uint8 = 1
+	kxFlagSig uint8 = 2
+)
+
+// KXPacket 密钥协商报文, 连接建立后客户端和服务端各发送一次.
+type KXPacket struct {
+	FixHeader
+	Version   uint8
+	PublicKey []byte
+	Nonce     []byte
+	MAC       []byte
+	Signature []byte
+}
+
+// NewKXPacket  creates a new KXPacket.
+func NewKXPacket() (kx *KXPacket) {
+	kx = new(KXPacket)
+	kx.MessageType = TYPEKX
+	kx.Flag = FLAGKX
+	kx.Version = KXVERSION
+
+	return
+}
+
+func (kx *KXPacket) Write(w io.Writer) (int64, error) {
+	packet, err := kx.Pack()
+	if err != nil {
+		return 0, err
+	}
+	n, err := packet.WriteTo(w)
+	countOut(kx.MessageType, n)
+	return n, err
+}
+
+// Pack encode packet.
+func (kx *KXPacket) Pack() (packet bytes.Buffer, err error) {
+	if len(kx.PublicKey) != KXPUBLICKEYSIZE || len(kx.Nonce) != KXNONCESIZE {
+		err = fmt.Errorf("PublicKey(%d), Nonce(%d) length not match", len(kx.PublicKey), len(kx.Nonce))
+		return
+	}
+	var flags uint8
+	if len(kx.MAC) > 0 {
+		if len(kx.MAC) != KXMACSIZE {
+			err = fmt.Errorf("MAC(%d) length not match", len(kx.MAC))
+			return
+		}
+		flags |= kxFlagMAC
+	}
+	if len(kx.Signature) > 0 {
+		if len(kx.Signature) != KXSIGSIZE {
+			err = fmt.Errorf("Signature(%d) length not match", len(kx.Signature))
+			return
+		}
+		flags |= kxFlagSig
+	}
+
+	var body bytes.Buffer
+	body.WriteByte(kx.Version)
+	body.WriteByte(flags)
+	body.Write(kx.PublicKey)
+	body.Write(kx.Nonce)
+	body.Write(kx.MAC)
+	body.Write(kx.Signature)
+
+	kx.Length = uint64(body.Len())
+	packet, err = kx.FixHeader.Pack()
+	if err != nil {
+		return
+	}
+	packet.Write(body.Bytes())
+	return
+}
+
+// Unpack decode packet.
+func (kx *KXPacket) Unpack(r io.Reader) error {
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	kx.Version = b[0]
+	if kx.Version != KXVERSION {
+		return fmt.Errorf("KX Version not support: %d", kx.Version)
+	}
+	flags := b[1]
+
+	kx.PublicKey = make([]byte, KXPUBLICKEYSIZE)
+	if _, err := io.ReadFull(r, kx.PublicKey); err != nil {
+		return err
+	}
+	kx.Nonce = make([]byte, KXNONCESIZE)
+	if _, err := io.ReadFull(r, kx.Nonce); err != nil {
+		return err
+	}
+	kx.MAC = nil
+	if flags&kxFlagMAC != 0 {
+		kx.MAC = make([]byte, KXMACSIZE)
+		if _, err := io.ReadFull(r, kx.MAC); err != nil {
+			return err
+		}
+	}
+	kx.Signature = nil
+	if flags&kxFlagSig != 0 {
+		kx.Signature = make([]byte, KXSIGSIZE)
+		if _, err := io.ReadFull(r, kx.Signature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (kx *KXPacket) String() string {
+	return fmt.Sprintf("types: %d, flag: %d, length: %d", kx.MessageType, kx.Flag, kx.Length)
+}
+
+// Debug debug packet.
+func (kx *KXPacket) Debug() string {
+	return fmt.Sprintf("types: %d, flag: %d, length: %d, Version: %d, PublicKey: [%s].",
+		kx.MessageType, kx.Flag, kx.Length, kx.Version, common.ByteConvertString(kx.PublicKey))
+}
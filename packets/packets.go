@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/pprpc/util/common"
 )
@@ -19,6 +20,51 @@ type PPPacket interface {
 	Debug() string
 }
 
+// SessionKeyer 完成密钥协商的连接实现该接口, 读写报文时自动使用会话密钥.
+// SessionDir 为本端发送报文的方向(FRAMEC2S: 客户端; FRAMES2C: 服务端), 参与加密Key和nonce的生成,
+// 同一个会话中两个方向的报文不会使用相同的Key和nonce.
+type SessionKeyer interface {
+	SessionKey() []byte
+	SessionDir() uint8
+}
+
+// MessageReader 按完整报文读取(UDP分片重组), ReadUDPPacket 优先使用该接口.
+type MessageReader interface {
+	ReadMessage() ([]byte, error)
+}
+
+// sessionOf 获取连接的会话密钥和本端发送方向, 未协商返回nil(使用 AESKEYPREFIX).
+func sessionOf(v interface{}) ([]byte, uint8) {
+	if sk, ok := v.(SessionKeyer); ok {
+		return sk.SessionKey(), sk.SessionDir()
+	}
+	return nil, FRAMEC2S
+}
+
+// setSessionKey 设置读取的报文的会话密钥, 报文的发送方向与本端相反.
+func setSessionKey(pp PPPacket, r io.Reader) {
+	key, dir := sessionOf(r)
+	if len(key) == 0 {
+		return
+	}
+	dir ^= 1
+	switch v := pp.(type) {
+	case *CmdPacket:
+		v.SessKey, v.SessDir = key, dir
+	case *AVPacket:
+		v.SessKey, v.SessDir = key, dir
+	case *FilePacket:
+		v.SessKey = key
+	}
+}
+
+var udpBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, maxUDPReadSize)
+		return &b
+	},
+}
+
 // ReadTCPPacket 读取一个完整的Packet(TCP).
 func ReadTCPPacket(r io.Reader) (pp PPPacket, err error) {
 	var fh FixHeader
@@ -38,19 +84,21 @@ func ReadTCPPacket(r io.Reader) (pp PPPacket, err error) {
 	if pp == nil {
 		return nil, errors.New("Bad data from client")
 	}
+	setSessionKey(pp, r)
 	packetBytes := make([]byte, fh.Length)
 	_, err = io.ReadFull(r, packetBytes)
 	if err != nil {
 		return nil, err
 	}
+	countIn(&fh)
 	err = pp.Unpack(bytes.NewBuffer(packetBytes))
 	if err != nil {
-		err = fmt.Errorf("%s, Data: %s", err, common.ByteConvertString(append(fh.RawHeader, packetBytes...)))
+		err = fmt.Errorf("%w, Data: %s", err, common.ByteConvertString(append(fh.RawHeader, packetBytes...)))
 	}
 	return pp, err
 }
 
-// ReadTCPPacketAdv 读取一个完整的Packet(TCP).
+// ReadTCPPacketAdv 读取一个完整的Packet(TCP), ac 为 CmdPacket/AVPacket/FilePacket 的 AutoCrypt.
 func ReadTCPPacketAdv(r io.Reader, ac bool) (pp PPPacket, err error) {
 	var fh FixHeader
 	fh.protoType = PROTOTCP
@@ -69,27 +117,48 @@ func ReadTCPPacketAdv(r io.Reader, ac bool) (pp PPPacket, err error) {
 	if pp == nil {
 		return nil, errors.New("Bad data from client")
 	}
+	setSessionKey(pp, r)
 	packetBytes := make([]byte, fh.Length)
 	_, err = io.ReadFull(r, packetBytes)
 	if err != nil {
 		return nil, err
 	}
+	countIn(&fh)
 	err = pp.Unpack(bytes.NewBuffer(packetBytes))
 	if err != nil {
-		err = fmt.Errorf("%s, Data: %s", err, common.ByteConvertString(append(fh.RawHeader, packetBytes...)))
+		err = fmt.Errorf("%w, Data: %s", err, common.ByteConvertString(append(fh.RawHeader, packetBytes...)))
 	}
 	return pp, err
 }
 
 // ReadUDPPacket 读取一个完整的Packet(UDP).
 func ReadUDPPacket(r io.Reader) (pp PPPacket, err error) {
+	return readUDPPacket(r, NewPPPacketWithHeader)
+}
+
+// ReadUDPPacketAdv 读取一个完整的Packet(UDP), ac 为 CmdPacket/AVPacket/FilePacket 的 AutoCrypt.
+func ReadUDPPacketAdv(r io.Reader, ac bool) (pp PPPacket, err error) {
+	return readUDPPacket(r, func(fh FixHeader) PPPacket {
+		return NewPPPacketWithHeaderV2(fh, ac)
+	})
+}
+
+func readUDPPacket(r io.Reader, newPacket func(FixHeader) PPPacket) (pp PPPacket, err error) {
 	// UDP 必须先读取整个报文
-	fb := make([]byte, MAXUDPBUFSIZE)
-	n, err := r.Read(fb)
+	var fb []byte
+	if mr, ok := r.(MessageReader); ok {
+		fb, err = mr.ReadMessage()
+	} else {
+		bp := udpBufPool.Get().(*[]byte)
+		var n int
+		n, err = r.Read(*bp)
+		fb = append([]byte(nil), (*bp)[:n]...)
+		udpBufPool.Put(bp)
+	}
 	if err != nil {
 		return
 	}
-	rb := bytes.NewBuffer(fb[:n])
+	rb := bytes.NewBuffer(fb)
 
 	var fh FixHeader
 	fh.protoType = PROTOUDP
@@ -110,18 +179,20 @@ func ReadUDPPacket(r io.Reader) (pp PPPacket, err error) {
 	fh.RawHeader = append(udpPreHeader, fh.RawHeader...)
 
 	fh.SetProtocol(PROTOUDP) // 必须设定该值(header自动添加UDP固定报头)
-	pp = NewPPPacketWithHeader(fh)
+	pp = newPacket(fh)
 	if pp == nil {
 		return nil, errors.New("Bad data from client")
 	}
+	setSessionKey(pp, r)
 	packetBytes := make([]byte, fh.Length)
 	_, err = io.ReadFull(rb, packetBytes)
 	if err != nil {
 		return nil, err
 	}
+	countIn(&fh)
 	err = pp.Unpack(bytes.NewBuffer(packetBytes))
 	if err != nil {
-		err = fmt.Errorf("%s.\nData: \n%s", err, common.ByteConvertString(append(fh.RawHeader, packetBytes...)))
+		err = fmt.Errorf("%w.\nData: \n%s", err, common.ByteConvertString(append(fh.RawHeader, packetBytes...)))
 	}
 	return pp, err
 }
@@ -151,13 +222,17 @@ func NewPPPacketWithHeader(fh FixHeader) (pp PPPacket) {
 		_t := NewFilePacket()
 		_t.FixHeader = fh
 		pp = _t
+	case TYPEKX:
+		_t := NewKXPacket()
+		_t.FixHeader = fh
+		pp = _t
 	default:
 		return nil
 	}
 	return pp
 }
 
-// NewPPPacketWithHeaderV2  创建PPPacket
+// NewPPPacketWithHeaderV2  创建PPPacket, b 为 CmdPacket/AVPacket/FilePacket 的 AutoCrypt(连接的 AutoCrypt).
 func NewPPPacketWithHeaderV2(fh FixHeader, b bool) (pp PPPacket) {
 	switch fh.MessageType {
 	case TYPEHB:
@@ -174,7 +249,7 @@ func NewPPPacketWithHeaderV2(fh FixHeader, b bool) (pp PPPacket) {
 		pp = _t
 	case TYPEAV:
 		_t := NewAVPacket()
-		//_t.AutoCrypt = b
+		_t.AutoCrypt = b
 		_t.FixHeader = fh
 		pp = _t
 	case TYPECUSTOMER:
@@ -183,6 +258,11 @@ func NewPPPacketWithHeaderV2(fh FixHeader, b bool) (pp PPPacket) {
 		pp = _t
 	case TYPEFILE:
 		_t := NewFilePacket()
+		_t.AutoCrypt = b
+		_t.FixHeader = fh
+		pp = _t
+	case TYPEKX:
+		_t := NewKXPacket()
 		_t.FixHeader = fh
 		pp = _t
 	default:
@@ -0,0 +1,55 @@
+package packets
+
+import "sync/atomic"
+
+// TypeName 报文类型名称(指标统计使用)
+func TypeName(t uint8) string {
+	switch t {
+	case TYPEHB:
+		return "hb"
+	case TYPEPBBIN, TYPEPBJSON:
+		return "cmd"
+	case TYPEAV:
+		return "av"
+	case TYPECUSTOMER:
+		return "customer"
+	case TYPEFILE:
+		return "file"
+	case TYPEKX:
+		return "kx"
+	}
+	return "unknown"
+}
+
+// StatsHook 报文收发的统计回调: out 为 true 表示发送, t 为报文类型(MessageType), n 为字节数;
+// 在读写报文的协程中调用, 需要支持并发调用且不能阻塞.
+type StatsHook func(out bool, t uint8, n int64)
+
+type statsHolder struct {
+	fn StatsHook
+}
+
+var statsHook atomic.Value
+
+// SetStatsHook 设置报文收发的统计回调, nil 停止统计.
+func SetStatsHook(fn StatsHook) {
+	statsHook.Store(statsHolder{fn})
+}
+
+func countPacket(out bool, t uint8, n int64) {
+	if h, _ := statsHook.Load().(statsHolder); h.fn != nil {
+		h.fn(out, t, n)
+	}
+}
+
+// countOut 统计发送的报文
+func countOut(t uint8, n int64) {
+	if n > 0 {
+		countPacket(true, t, n)
+	}
+}
+
+// countIn 统计接收的报文(固定报头 + 后续数据)
+func countIn(fh *FixHeader) {
+	countPacket(false, fh.MessageType, int64(len(fh.RawHeader))+int64(fh.Length))
+}
@@ -0,0 +1,59 @@
+package packets
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestStatsHook(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		count = make(map[[2]string]int64)
+	)
+	SetStatsHook(func(out bool, typ uint8, n int64) {
+		dir := "in"
+		if out {
+			dir = "out"
+		}
+		mu.Lock()
+		count[[2]string{dir, TypeName(typ)}] += n
+		mu.Unlock()
+	})
+	defer SetStatsHook(nil)
+
+	var b bytes.Buffer
+	cmd := testCmdPacket(AESNONE)
+	n, err := cmd.Write(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb := NewHBPacket()
+	m, err := hb.Write(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = ReadTCPPacket(&b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[[2]string]int64{
+		{"out", "cmd"}: n, {"in", "cmd"}: n,
+		{"out", "hb"}: m, {"in", "hb"}: m,
+	}
+	for k, v := range want {
+		if count[k] != v {
+			t.Fatalf("%v: %d, want: %d (%v)", k, count[k], v, count)
+		}
+	}
+
+	// 停止统计
+	SetStatsHook(nil)
+	if _, err = hb.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if count[[2]string{"out", "hb"}] != m {
+		t.Fatalf("counted after SetStatsHook(nil): %v", count)
+	}
+}
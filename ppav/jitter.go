@@ -0,0 +1,319 @@
+package ppav
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	pprpc "github.com/pprpc/core"
+	"github.com/pprpc/packets"
+	"github.com/pprpc/util/logs"
+)
+
+const (
+	// DefJitterDelay 默认缓冲时间
+	DefJitterDelay = 100 * time.Millisecond
+	// DefJitterPackets 默认每路流缓冲的报文数上限
+	DefJitterPackets = 512
+	// DefClockRate 默认 Timestamp 的单位(每秒的数量), 即毫秒
+	DefClockRate = 1000
+
+	// seqMod AVSeq 的取值范围, 超过后回绕到 0
+	seqMod = 1 << 28
+	// maxDrift 播放时间与到达时间的偏差超过该值时(时间戳跳变, 时钟漂移)重新同步
+	maxDrift = time.Second
+	// resyncGap 迟到报文的 Timestamp 与最后交付的报文相差超过该值时认为发布者重新开始(AVSeq 重置)
+	resyncGap = 3 * time.Second
+)
+
+// JitterStats 抖动缓冲的统计
+type JitterStats struct {
+	Received  uint64        // 收到的报文数
+	Delivered uint64        // 已经交付的报文数
+	Lost      uint64        // 等待到后续报文的播放时间仍没有收到而跳过的报文数
+	Late      uint64        // 跳过(或者已经交付)后才收到而丢弃的报文数
+	Duplicate uint64        // 重复的报文数
+	Reordered uint64        // 乱序(比已经收到的最大 AVSeq 小)的报文数
+	Overflow  uint64        // 缓冲满(handler 交付不及时)丢弃的最早的报文数, 同时计入 Lost
+	Resync    uint64        // AVSeq 大幅回退(发布者重新开始)后重新同步的次数
+	Buffered  int           // 缓冲中的报文数
+	Jitter    time.Duration // 到达间隔的抖动(RFC 3550)
+}
+
+// JitterBuffer 接收端的抖动缓冲.
+//
+// RPCUDPServer 每个报文在独立的协程中处理, AVPacket 到达 AVCB 的顺序与 AVSeq 不一致并且有抖动;
+// JitterBuffer 对每个 (连接, AVChannel) 缓冲 Delay 时间, 按 AVSeq 排序并按 Timestamp 的间隔交付给 handler,
+// 后续报文的播放时间到达时仍没有收到的报文认为丢失.
+// AVSeq 回退超过 MaxPackets 或者 Timestamp 回退超过 resyncGap 时认为发布者重新开始, 丢弃缓冲的报文并重新同步.
+//
+//	jb := ppav.NewJitterBuffer(r.HandleAV)
+//	ts.AVCB = jb.HandleAV
+type JitterBuffer struct {
+	Delay      time.Duration // 缓冲时间, 0 使用 DefJitterDelay
+	MaxPackets int           // 每路流缓冲的报文数上限, 达到时立即交付, 仍然满时丢弃最早的报文; 0 使用 DefJitterPackets
+	ClockRate  uint64        // Timestamp 每秒的单位数, 0 使用 DefClockRate
+	// GapCB 发现丢包时调用: 从 seq 开始的 n 个报文丢失(例如请求 I 帧), 不能长时间阻塞.
+	GapCB func(conn pprpc.RPCConn, channel, seq, n uint64)
+
+	handler func(*packets.AVPacket, pprpc.RPCConn) error
+
+	mu   sync.Mutex
+	bufs map[pubKey]*jitter
+}
+
+// jitter 一路流的缓冲
+type jitter struct {
+	key pubKey
+
+	mu      sync.Mutex
+	pending []*jitterPkt // 按 AVSeq 排序, 都不小于 next
+	started bool
+	synced  bool   // next 有效: (重新同步后)已经交付报文
+	next    uint64 // 下一个交付的 AVSeq
+	nextTS  uint64 // 最后交付的报文的 Timestamp
+	highest uint64 // 收到的最大 AVSeq
+	baseAt  time.Time
+	baseTS  uint64
+	lastAt  time.Time
+	lastTS  uint64
+	jitter  float64 // 秒
+	stats   JitterStats
+	wake    chan struct{}
+}
+
+type jitterPkt struct {
+	pkg *packets.AVPacket
+	due time.Time // 播放时间
+}
+
+// NewJitterBuffer 创建抖动缓冲, 排序后的报文交付给 handler.
+func NewJitterBuffer(handler func(*packets.AVPacket, pprpc.RPCConn) error) *JitterBuffer {
+	return &JitterBuffer{
+		handler: handler,
+		bufs:    make(map[pubKey]*jitter),
+	}
+}
+
+// HandleAV 缓冲 AVPacket, 设置为 RPCUDPServer 的 AVCB. conn 断开时丢弃没有交付的报文.
+func (jb *JitterBuffer) HandleAV(pkg *packets.AVPacket, conn pprpc.RPCConn) error {
+	k := pubKey{conn, pkg.AVChannel}
+	jb.mu.Lock()
+	j, ok := jb.bufs[k]
+	if !ok {
+		j = &jitter{key: k, wake: make(chan struct{}, 1)}
+		jb.bufs[k] = j
+		go jb.run(j)
+	}
+	jb.mu.Unlock()
+
+	j.push(pkg, time.Now(), jb.delay(), jb.clockRate(), jb.maxPackets())
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Stats 连接 conn 上 AVChannel 为 channel 的统计, 不存在时 ok 为 false.
+func (jb *JitterBuffer) Stats(conn pprpc.RPCConn, channel uint64) (st JitterStats, ok bool) {
+	jb.mu.Lock()
+	j, ok := jb.bufs[pubKey{conn, channel}]
+	jb.mu.Unlock()
+	if !ok {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st = j.stats
+	st.Buffered = len(j.pending)
+	st.Jitter = time.Duration(j.jitter * float64(time.Second))
+	return st, true
+}
+
+func (jb *JitterBuffer) delay() time.Duration {
+	if jb.Delay <= 0 {
+		return DefJitterDelay
+	}
+	return jb.Delay
+}
+
+func (jb *JitterBuffer) maxPackets() int {
+	if jb.MaxPackets <= 0 {
+		return DefJitterPackets
+	}
+	return jb.MaxPackets
+}
+
+func (jb *JitterBuffer) clockRate() uint64 {
+	if jb.ClockRate == 0 {
+		return DefClockRate
+	}
+	return jb.ClockRate
+}
+
+// run 一路流的交付协程, 连接断开时退出.
+func (jb *JitterBuffer) run(j *jitter) {
+	conn := j.key.conn
+	t := time.NewTimer(time.Hour)
+	defer t.Stop()
+	for {
+		for {
+			pkg, seq, lost, wait := j.pop(time.Now(), jb.maxPackets())
+			if lost > 0 {
+				logs.Logger.Debugf("%s, AVChannel: %d, AVSeq: %d, lost: %d.", conn, j.key.channel, seq, lost)
+				if jb.GapCB != nil {
+					jb.GapCB(conn, j.key.channel, seq, lost)
+				}
+			}
+			if pkg == nil {
+				if wait <= 0 {
+					wait = time.Hour
+				}
+				t.Reset(wait)
+				break
+			}
+			if err := jb.handler(pkg, conn); err != nil {
+				logs.Logger.Errorf("%s, AVChannel: %d, jitter buffer, error: %s.", conn, j.key.channel, err)
+			}
+		}
+
+		select {
+		case <-conn.HandleClose().Done():
+			jb.mu.Lock()
+			delete(jb.bufs, j.key)
+			jb.mu.Unlock()
+			return
+		case <-j.wake:
+		case <-t.C:
+		}
+		if !t.Stop() {
+			select {
+			case <-t.C:
+			default:
+			}
+		}
+	}
+}
+
+// push 放入缓冲, 计算播放时间; 缓冲超过 max 时丢弃最早的报文.
+func (j *jitter) push(pkg *packets.AVPacket, now time.Time, delay time.Duration, rate uint64, max int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stats.Received++
+	if !j.lastAt.IsZero() {
+		// RFC 3550: J += (|D| - J) / 16
+		d := now.Sub(j.lastAt).Seconds() - tsDiff(pkg.Timestamp, j.lastTS, rate)
+		if d < 0 {
+			d = -d
+		}
+		j.jitter += (d - j.jitter) / 16
+	}
+	j.lastAt, j.lastTS = now, pkg.Timestamp
+
+	if !j.started {
+		j.started = true
+		j.highest = pkg.AVSeq
+		j.baseAt, j.baseTS = now.Add(delay), pkg.Timestamp
+	}
+	if j.synced && seqDiff(pkg.AVSeq, j.next) < 0 {
+		if -seqDiff(pkg.AVSeq, j.next) <= int64(max) && !j.tsJump(pkg.Timestamp, rate) {
+			j.stats.Late++
+			return
+		}
+		j.resync(pkg, now, delay)
+	}
+	if len(j.pending) >= max {
+		// handler 交付不及时
+		j.pending[0] = nil
+		j.pending = j.pending[1:]
+		j.stats.Overflow++
+		if !j.synced {
+			// 交付后的丢包由 pop 统计
+			j.stats.Lost++
+		}
+	}
+	i := sort.Search(len(j.pending), func(i int) bool {
+		return seqDiff(j.pending[i].pkg.AVSeq, pkg.AVSeq) >= 0
+	})
+	if i < len(j.pending) && j.pending[i].pkg.AVSeq == pkg.AVSeq {
+		j.stats.Duplicate++
+		return
+	}
+	if seqDiff(pkg.AVSeq, j.highest) < 0 {
+		j.stats.Reordered++
+	} else {
+		j.highest = pkg.AVSeq
+	}
+
+	due := j.baseAt.Add(time.Duration(tsDiff(pkg.Timestamp, j.baseTS, rate) * float64(time.Second)))
+	if due.Before(now.Add(-maxDrift)) || due.After(now.Add(delay+maxDrift)) {
+		// 时间戳跳变或者两端的时钟漂移, 重新同步
+		j.baseAt, j.baseTS = now.Add(delay), pkg.Timestamp
+		due = j.baseAt
+	}
+	j.pending = append(j.pending, nil)
+	copy(j.pending[i+1:], j.pending[i:])
+	j.pending[i] = &jitterPkt{pkg: pkg, due: due}
+}
+
+// tsJump 迟到报文的 Timestamp 与最后交付的报文相差超过 resyncGap.
+func (j *jitter) tsJump(ts, rate uint64) bool {
+	d := tsDiff(ts, j.nextTS, rate)
+	if d < 0 {
+		d = -d
+	}
+	return d > resyncGap.Seconds()
+}
+
+// resync 发布者重新开始(AVSeq 重置): 丢弃缓冲的报文(计入 Lost), 从 pkg 重新开始交付.
+func (j *jitter) resync(pkg *packets.AVPacket, now time.Time, delay time.Duration) {
+	j.stats.Resync++
+	j.stats.Lost += uint64(len(j.pending))
+	for i := range j.pending {
+		j.pending[i] = nil
+	}
+	j.pending = j.pending[:0]
+	j.synced = false
+	j.highest = pkg.AVSeq
+	j.baseAt, j.baseTS = now.Add(delay), pkg.Timestamp
+}
+
+// pop 取出播放时间已经到达(或者缓冲达到 max)的第一个报文, 与 next 之间的报文认为丢失;
+// 没有可以交付的报文时返回距离下一个播放时间的等待时间.
+func (j *jitter) pop(now time.Time, max int) (pkg *packets.AVPacket, seq, lost uint64, wait time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.pending) == 0 {
+		return
+	}
+	p := j.pending[0]
+	if p.due.After(now) && len(j.pending) < max {
+		return nil, 0, 0, p.due.Sub(now)
+	}
+	if j.synced && p.pkg.AVSeq != j.next {
+		seq, lost = j.next, uint64(seqDiff(p.pkg.AVSeq, j.next))
+		j.stats.Lost += lost
+	}
+	j.pending[0] = nil
+	j.pending = j.pending[1:]
+	j.next = (p.pkg.AVSeq + 1) % seqMod
+	j.nextTS = p.pkg.Timestamp
+	j.synced = true
+	j.stats.Delivered++
+	return p.pkg, seq, lost, 0
+}
+
+// seqDiff AVSeq a 与 b 的差, 考虑回绕.
+func seqDiff(a, b uint64) int64 {
+	d := int64((a - b) % seqMod)
+	if d >= seqMod/2 {
+		d -= seqMod
+	}
+	return d
+}
+
+// tsDiff Timestamp a 与 b 的差(秒).
+func tsDiff(a, b, rate uint64) float64 {
+	return (float64(a) - float64(b)) / float64(rate)
+}
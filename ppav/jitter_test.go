@@ -0,0 +1,114 @@
+package ppav
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pprpc/packets"
+)
+
+const (
+	testDelay = 100 * time.Millisecond
+	testRate  = 1000
+)
+
+// jitterTest 使用合成的到达时间驱动 jitter, 结果与调度无关.
+type jitterTest struct {
+	t  *testing.T
+	j  *jitter
+	t0 time.Time
+}
+
+func (jt *jitterTest) at(ms int) time.Time {
+	return jt.t0.Add(time.Duration(ms) * time.Millisecond)
+}
+
+func (jt *jitterTest) push(ms int, seq, ts uint64, max int) {
+	pkg := packets.NewAVPacket()
+	pkg.AVSeq, pkg.Timestamp = seq, ts
+	jt.j.push(pkg, jt.at(ms), testDelay, testRate, max)
+}
+
+// pop 在 ms 时取出所有可以交付的报文, 返回交付的 AVSeq 和丢失的 [起始 AVSeq, 数量].
+func (jt *jitterTest) pop(ms int, max int) (seqs []uint64, lost [][2]uint64) {
+	for {
+		pkg, seq, n, _ := jt.j.pop(jt.at(ms), max)
+		if n > 0 {
+			lost = append(lost, [2]uint64{seq, n})
+		}
+		if pkg == nil {
+			return
+		}
+		seqs = append(seqs, pkg.AVSeq)
+	}
+}
+
+func (jt *jitterTest) expect(ms int, max int, want []uint64, wantLost ...[2]uint64) {
+	jt.t.Helper()
+	seqs, lost := jt.pop(ms, max)
+	if !reflect.DeepEqual(seqs, want) || !reflect.DeepEqual(lost, wantLost) {
+		jt.t.Fatalf("at %dms, delivered: %v, lost: %v; want %v, %v", ms, seqs, lost, want, wantLost)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	jt := &jitterTest{t: t, j: &jitter{}, t0: time.Now()}
+	// Timestamp 为 AVSeq*40ms
+
+	// 乱序和重复
+	jt.push(0, 1, 40, 8)
+	jt.push(80, 3, 120, 8)
+	jt.push(85, 2, 80, 8)
+	jt.push(86, 2, 80, 8)
+	if _, _, _, wait := jt.j.pop(jt.at(90), 8); wait != 10*time.Millisecond {
+		t.Fatalf("wait: %s", wait)
+	}
+	jt.expect(200, 8, []uint64{1, 2, 3})
+
+	// 丢包, 之后收到的报文迟到
+	jt.push(240, 6, 240, 8)
+	jt.expect(300, 8, []uint64{6}, [2]uint64{4, 2})
+	jt.push(310, 4, 160, 8)
+	jt.expect(310, 8, nil)
+
+	// 发布者重新开始: AVSeq 回退不多但是 Timestamp 跳变
+	jt.push(400, 1, 100000, 8)
+	jt.expect(499, 8, nil)
+	jt.expect(500, 8, []uint64{1})
+
+	// 缓冲满: 丢弃最早的报文, 达到上限时立即交付
+	for seq := uint64(2); seq <= 6; seq++ {
+		jt.push(510, seq, 100000+seq*40, 4)
+	}
+	jt.expect(510, 4, []uint64{3}, [2]uint64{2, 1})
+
+	want := JitterStats{
+		Received:  12,
+		Delivered: 6,
+		Lost:      3,
+		Late:      1,
+		Duplicate: 1,
+		Reordered: 1,
+		Overflow:  1,
+		Resync:    1,
+	}
+	if st := jt.j.stats; st != want {
+		t.Fatalf("stats: %+v, want: %+v", st, want)
+	}
+	if len(jt.j.pending) != 3 {
+		t.Fatalf("buffered: %d", len(jt.j.pending))
+	}
+}
+
+func TestJitterWrap(t *testing.T) {
+	jt := &jitterTest{t: t, j: &jitter{}, t0: time.Now()}
+	jt.push(0, seqMod-2, 0, 8)
+	jt.push(1, 0, 80, 8)
+	jt.push(2, seqMod-1, 40, 8)
+	jt.push(3, 2, 160, 8)
+	jt.expect(300, 8, []uint64{seqMod - 2, seqMod - 1, 0, 2}, [2]uint64{1, 1})
+	if st := jt.j.stats; st.Reordered != 1 || st.Lost != 1 || st.Resync != 0 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
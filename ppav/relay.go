@@ -0,0 +1,382 @@
+// Package ppav 音视频流(AVPacket)的转发.
+//
+// 发布者连接发布 (设备, AVChannel) 流, 任意多个订阅者连接订阅/取消订阅;
+// 每个订阅者有独立的发送队列和发送协程, 慢的订阅者不会阻塞发布者和其他订阅者,
+// 队列满时丢弃该订阅者的报文直到下一个 I 帧.
+// 每路流缓存最近的 GOP(最后一个 I 帧开始的报文), 新的订阅者先收到缓存的 GOP 再收到实时报文,
+// 不需要等待下一个 I 帧.
+// JitterBuffer 按 AVSeq 排序并按 Timestamp 平滑交付 UDP 接收的 AVPacket, 可以放在 Relay 之前.
+//
+//	r := ppav.NewRelay()
+//	ts.AVCB = r.HandleAV
+//	r.Publish("dev1", 1, devConn)   // 一般在设备的命令处理中调用
+//	r.Subscribe("dev1", 1, viewConn) // 一般在观看者的命令处理中调用
+//
+// 订阅者收到的 AVPacket 用 AVChannel 区分流, 一个连接订阅多路 AVChannel 相同的流(dev1/1, dev2/1)时
+// 使用 SubscribeAs 指定不同的 AVChannel.
+package ppav
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	pprpc "github.com/pprpc/core"
+	"github.com/pprpc/packets"
+	"github.com/pprpc/status"
+	"github.com/pprpc/util/logs"
+)
+
+const (
+	// DefQueueLen 默认每个订阅者的发送队列长度(报文数)
+	DefQueueLen = 256
+	// DefGOPCacheBytes 默认每路流缓存的 GOP 大小上限(字节)
+	DefGOPCacheBytes = 4 << 20
+)
+
+// StreamKey 流标识
+type StreamKey struct {
+	Device  string
+	Channel uint64 // AVChannel
+}
+
+func (k StreamKey) String() string {
+	return fmt.Sprintf("%s/%d", k.Device, k.Channel)
+}
+
+// SubscriberStats 订阅者统计
+type SubscriberStats struct {
+	Conn    pprpc.RPCConn
+	Sent    uint64 // 已经发送的报文数
+	Dropped uint64 // 队列满丢弃的报文数
+	Queued  int    // 队列中的报文数
+}
+
+// Relay 音视频流转发.
+type Relay struct {
+	QueueLen int // 每个订阅者的发送队列长度, 0 使用 DefQueueLen; 需在订阅前设置
+	// GOPCacheBytes 每路流缓存的 GOP 大小上限, 0 使用 DefGOPCacheBytes, 小于 0 不缓存;
+	// GOP 超过上限时不缓存(到下一个 I 帧), 新的订阅者等待下一个 I 帧.
+	GOPCacheBytes int
+
+	mu      sync.Mutex
+	streams map[StreamKey]*stream
+	pubs    map[pubKey]*stream
+	subs    map[pubKey]*stream // 订阅者连接 + 发送的 AVChannel
+}
+
+// pubKey 连接 + AVChannel
+type pubKey struct {
+	conn    pprpc.RPCConn
+	channel uint64
+}
+
+// stream 一路流, 有发布者或者订阅者时存在.
+type stream struct {
+	key  StreamKey
+	mu   sync.RWMutex
+	pub  pprpc.RPCConn
+	subs map[pprpc.RPCConn]*subscriber
+
+	gop      []*packets.AVPacket // 最后一个 I 帧开始的报文(已经解密)
+	gopBytes int
+}
+
+// subscriber 订阅者
+type subscriber struct {
+	sent    uint64
+	dropped uint64
+
+	conn    pprpc.RPCConn
+	channel uint64 // 发送给订阅者的 AVChannel
+	queue   chan *packets.AVPacket
+	done    chan struct{}
+
+	mu         sync.Mutex
+	waitIFrame bool // 队列满后(或者没有缓存的 GOP)丢弃到下一个 I 帧
+}
+
+// NewRelay 创建 Relay
+func NewRelay() *Relay {
+	return &Relay{
+		streams: make(map[StreamKey]*stream),
+		pubs:    make(map[pubKey]*stream),
+		subs:    make(map[pubKey]*stream),
+	}
+}
+
+// getStream 获取流, 不存在时创建; 需持有 r.mu.
+func (r *Relay) getStream(k StreamKey) *stream {
+	s, ok := r.streams[k]
+	if !ok {
+		s = &stream{key: k, subs: make(map[pprpc.RPCConn]*subscriber)}
+		r.streams[k] = s
+	}
+	return s
+}
+
+// release 没有发布者和订阅者时删除流; 需持有 r.mu.
+func (r *Relay) release(s *stream) {
+	s.mu.RLock()
+	empty := s.pub == nil && len(s.subs) == 0
+	s.mu.RUnlock()
+	if empty && r.streams[s.key] == s {
+		delete(r.streams, s.key)
+	}
+}
+
+// Publish 由 conn 发布流, conn 上 AVChannel 为 channel 的 AVPacket 转发给订阅者;
+// 已经由其他连接发布时返回 status.AlreadyExists. conn 断开时自动取消发布.
+func (r *Relay) Publish(device string, channel uint64, conn pprpc.RPCConn) error {
+	k := StreamKey{device, channel}
+	r.mu.Lock()
+	s := r.getStream(k)
+	s.mu.Lock()
+	if s.pub != nil && s.pub != conn {
+		s.mu.Unlock()
+		r.mu.Unlock()
+		return status.Errorf(status.AlreadyExists, "stream: %s, already published by %s", k, s.pub)
+	}
+	added := s.pub == nil
+	s.pub = conn
+	s.mu.Unlock()
+	r.pubs[pubKey{conn, channel}] = s
+	r.mu.Unlock()
+
+	if added {
+		go func() {
+			<-conn.HandleClose().Done()
+			r.Unpublish(device, channel, conn)
+		}()
+		logs.Logger.Debugf("%s, publish stream: %s.", conn, k)
+	}
+	return nil
+}
+
+// Unpublish 取消发布, 订阅者保持订阅(发布者重新发布后继续接收).
+func (r *Relay) Unpublish(device string, channel uint64, conn pprpc.RPCConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pk := pubKey{conn, channel}
+	s, ok := r.pubs[pk]
+	if !ok || s.key.Device != device {
+		return
+	}
+	delete(r.pubs, pk)
+	s.mu.Lock()
+	if s.pub == conn {
+		s.pub = nil
+		s.gop, s.gopBytes = nil, 0
+	}
+	s.mu.Unlock()
+	r.release(s)
+	logs.Logger.Debugf("%s, unpublish stream: %s.", conn, s.key)
+}
+
+// Subscribe conn 订阅流, 发送的 AVChannel 与流相同, 见 SubscribeAs.
+func (r *Relay) Subscribe(device string, channel uint64, conn pprpc.RPCConn) error {
+	return r.SubscribeAs(device, channel, conn, channel)
+}
+
+// SubscribeAs conn 订阅流, 发送给 conn 的 AVPacket 的 AVChannel 为 outChannel; 流还没有发布时等待发布.
+// conn 上 outChannel 已经用于其他流时返回 status.AlreadyExists. conn 断开或者发送失败时自动取消订阅.
+func (r *Relay) SubscribeAs(device string, channel uint64, conn pprpc.RPCConn, outChannel uint64) error {
+	n := r.QueueLen
+	if n <= 0 {
+		n = DefQueueLen
+	}
+	k := StreamKey{device, channel}
+	sk := pubKey{conn, outChannel}
+	r.mu.Lock()
+	if old, ok := r.subs[sk]; ok {
+		r.mu.Unlock()
+		if old.key == k {
+			return nil
+		}
+		return status.Errorf(status.AlreadyExists, "AVChannel: %d, already used by stream: %s", outChannel, old.key)
+	}
+	s := r.getStream(k)
+	s.mu.Lock()
+	if sub, ok := s.subs[conn]; ok {
+		s.mu.Unlock()
+		r.mu.Unlock()
+		return status.Errorf(status.AlreadyExists, "stream: %s, already subscribed as AVChannel: %d", k, sub.channel)
+	}
+	sub := &subscriber{
+		conn:       conn,
+		channel:    outChannel,
+		queue:      make(chan *packets.AVPacket, n+len(s.gop)),
+		done:       make(chan struct{}),
+		waitIFrame: true,
+	}
+	sub.replay(s.gop)
+	s.subs[conn] = sub
+	s.mu.Unlock()
+	r.subs[sk] = s
+	r.mu.Unlock()
+
+	go r.send(s, sub)
+	logs.Logger.Debugf("%s, subscribe stream: %s.", conn, s.key)
+	return nil
+}
+
+// Unsubscribe 取消订阅, 丢弃队列中没有发送的报文.
+func (r *Relay) Unsubscribe(device string, channel uint64, conn pprpc.RPCConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.streams[StreamKey{device, channel}]
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	sub, ok := s.subs[conn]
+	if ok {
+		delete(s.subs, conn)
+		delete(r.subs, pubKey{conn, sub.channel})
+		close(sub.done)
+	}
+	s.mu.Unlock()
+	r.release(s)
+	if ok {
+		logs.Logger.Debugf("%s, unsubscribe stream: %s.", conn, s.key)
+	}
+}
+
+// Stats 流的所有订阅者的统计, 流不存在时返回 nil.
+func (r *Relay) Stats(device string, channel uint64) []SubscriberStats {
+	r.mu.Lock()
+	s, ok := r.streams[StreamKey{device, channel}]
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st := make([]SubscriberStats, 0, len(s.subs))
+	for _, sub := range s.subs {
+		st = append(st, SubscriberStats{
+			Conn:    sub.conn,
+			Sent:    atomic.LoadUint64(&sub.sent),
+			Dropped: atomic.LoadUint64(&sub.dropped),
+			Queued:  len(sub.queue),
+		})
+	}
+	return st
+}
+
+// HandleAV 转发发布者的 AVPacket, 设置为 RPCTCPServer/RPCUDPServer 的 AVCB.
+func (r *Relay) HandleAV(pkg *packets.AVPacket, conn pprpc.RPCConn) error {
+	r.mu.Lock()
+	s, ok := r.pubs[pubKey{conn, pkg.AVChannel}]
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("AVChannel: %d, not published", pkg.AVChannel)
+	}
+	// 连接的 AutoCrypt 为 false 时读取的报文没有解密
+	if err := pkg.Decrypt(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache(pkg, r.gopLimit())
+	for _, sub := range s.subs {
+		sub.offer(pkg)
+	}
+	return nil
+}
+
+func (r *Relay) gopLimit() int {
+	if r.GOPCacheBytes == 0 {
+		return DefGOPCacheBytes
+	}
+	return r.GOPCacheBytes
+}
+
+// cache 缓存最近的 GOP, 超过 limit 时清空到下一个 I 帧; 需持有 s.mu.
+func (s *stream) cache(pkg *packets.AVPacket, limit int) {
+	if pkg.AVIFrame == packets.FRAMEI {
+		s.gop, s.gopBytes = s.gop[:0], 0
+	} else if len(s.gop) == 0 {
+		return
+	}
+	s.gopBytes += len(pkg.Payload)
+	if limit < 0 || s.gopBytes > limit {
+		s.gop, s.gopBytes = nil, 0
+		return
+	}
+	s.gop = append(s.gop, pkg)
+}
+
+// replay 发送缓存的 GOP, AVSeq 重新编号为连续并且与之后的实时报文连续(按 seqMod 回绕).
+func (sub *subscriber) replay(gop []*packets.AVPacket) {
+	n := uint64(len(gop))
+	if n == 0 {
+		return
+	}
+	next := (gop[n-1].AVSeq + 1) % seqMod // 下一个实时报文的 AVSeq
+	base := (next + seqMod - n%seqMod) % seqMod
+	for i, pkg := range gop {
+		sub.queue <- forward(pkg, sub.conn, sub.channel, (base+uint64(i))%seqMod)
+	}
+	sub.waitIFrame = false
+}
+
+// offer 放入发送队列, 队列满时丢弃到下一个 I 帧.
+func (sub *subscriber) offer(pkg *packets.AVPacket) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.waitIFrame && pkg.AVIFrame != packets.FRAMEI {
+		atomic.AddUint64(&sub.dropped, 1)
+		return
+	}
+	select {
+	case sub.queue <- forward(pkg, sub.conn, sub.channel, pkg.AVSeq%seqMod):
+		sub.waitIFrame = false
+	default:
+		sub.waitIFrame = true
+		atomic.AddUint64(&sub.dropped, 1)
+	}
+}
+
+// send 订阅者的发送协程.
+func (r *Relay) send(s *stream, sub *subscriber) {
+	for {
+		select {
+		case <-sub.done:
+			return
+		case <-sub.conn.HandleClose().Done():
+			r.Unsubscribe(s.key.Device, s.key.Channel, sub.conn)
+			return
+		case av := <-sub.queue:
+			if _, err := av.Write(sub.conn); err != nil {
+				logs.Logger.Debugf("%s, stream: %s, write: %s.", sub.conn, s.key, err)
+				r.Unsubscribe(s.key.Device, s.key.Channel, sub.conn)
+				return
+			}
+			atomic.AddUint64(&sub.sent, 1)
+		}
+	}
+}
+
+// forward 复制发布者的报文(AVChannel 为 channel, AVSeq 为 seq), 由订阅者连接的会话密钥重新加密.
+func forward(src *packets.AVPacket, conn pprpc.RPCConn, channel, seq uint64) *packets.AVPacket {
+	av := packets.NewAVPacket()
+	if conn.Type() == "U" {
+		av.FixHeader.SetProtocol(packets.PROTOUDP)
+	}
+	av.AVIFrame = src.AVIFrame
+	av.AVFormat = src.AVFormat
+	av.EncType = src.EncType
+	av.AVChannel = channel
+	av.AVSeq = seq
+	av.Timestamp = src.Timestamp
+	av.EncLength = src.EncLength
+	av.Payload = src.Payload
+	if av.EncType != packets.AESNONE {
+		// 加密会修改 Payload, 每个订阅者使用独立的副本
+		av.AutoCrypt = true
+		av.Payload = append([]byte(nil), src.Payload...)
+	}
+	return av
+}
@@ -0,0 +1,226 @@
+package ppav_test
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	pprpc "github.com/pprpc/core"
+	"github.com/pprpc/packets"
+	"github.com/pprpc/ppav"
+	"github.com/pprpc/pptcp"
+	"github.com/pprpc/status"
+)
+
+const seqMod = 1 << 28
+
+// relayEnv 一个 Relay 服务端, 客户端通过 mem:// 连接.
+type relayEnv struct {
+	t       *testing.T
+	url     *url.URL
+	r       *ppav.Relay
+	conns   chan *pptcp.Connection
+	handled chan error // 每个 HandleAV 的结果
+}
+
+func newRelayEnv(t *testing.T) *relayEnv {
+	t.Helper()
+	e := &relayEnv{
+		t:       t,
+		r:       ppav.NewRelay(),
+		conns:   make(chan *pptcp.Connection, 8),
+		handled: make(chan error, 64),
+	}
+	e.url, _ = url.Parse(fmt.Sprintf("mem://%s", t.Name()))
+	srv, err := pprpc.NewRPCTCPServer(e.url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Service = pprpc.NewService()
+	srv.ConnectCB = func(c *pptcp.Connection) { e.conns <- c }
+	srv.AVCB = func(pkg *packets.AVPacket, conn pprpc.RPCConn) error {
+		err := e.r.HandleAV(pkg, conn)
+		e.handled <- err
+		return err
+	}
+	go srv.Serve()
+	t.Cleanup(srv.Stop)
+	return e
+}
+
+// dial 返回客户端, 服务端的连接和客户端收到的 AVPacket.
+func (e *relayEnv) dial() (*pprpc.TCPCliConn, pprpc.RPCConn, chan *packets.AVPacket) {
+	e.t.Helper()
+	cli, err := pprpc.Dail(e.url, nil, pprpc.NewService(), time.Second, nil)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.t.Cleanup(func() { cli.Close() })
+	recv := make(chan *packets.AVPacket, 64)
+	cli.AVCB = func(pkg *packets.AVPacket, conn pprpc.RPCConn) error {
+		if err := pkg.Decrypt(); err != nil {
+			return err
+		}
+		recv <- pkg
+		return nil
+	}
+	return cli, <-e.conns, recv
+}
+
+// publish 由 cli 发送 AVPacket 并等待服务端处理成功.
+func (e *relayEnv) publish(cli *pprpc.TCPCliConn, channel, seq uint64, iframe uint8, payload string) {
+	e.t.Helper()
+	if err := e.send(cli, channel, seq, iframe, payload); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// send 由 cli 发送 AVPacket, 返回服务端 HandleAV 的结果.
+func (e *relayEnv) send(cli *pprpc.TCPCliConn, channel, seq uint64, iframe uint8, payload string) error {
+	e.t.Helper()
+	av := packets.NewAVPacket()
+	av.AutoCrypt = true
+	av.AVIFrame = iframe
+	av.AVFormat = packets.AVH264
+	av.EncType = packets.AES256GCM
+	av.AVChannel = channel
+	av.AVSeq = seq
+	av.Timestamp = seq * 40
+	av.Payload = []byte(payload)
+	if _, err := av.Write(cli.ClientConn); err != nil {
+		return err
+	}
+	select {
+	case err := <-e.handled:
+		return err
+	case <-time.After(time.Second):
+		e.t.Fatal("AVPacket not handled")
+	}
+	return nil
+}
+
+// expect 等待收到 AVChannel 为 channel 的报文, want 为 AVSeq 对应的 Payload;
+// 客户端在独立的协程中调用 AVCB, 收到的顺序与发送的顺序可能不同.
+func expect(t *testing.T, recv chan *packets.AVPacket, channel uint64, want map[uint64]string) {
+	t.Helper()
+	got := make(map[uint64]string, len(want))
+	for len(got) < len(want) {
+		select {
+		case av := <-recv:
+			if av.AVChannel != channel {
+				t.Fatalf("AVSeq: %d, AVChannel: %d, want: %d", av.AVSeq, av.AVChannel, channel)
+			}
+			got[av.AVSeq] = string(av.Payload)
+		case <-time.After(time.Second):
+			t.Fatalf("received: %v, want: %v", got, want)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("received: %v, want: %v", got, want)
+	}
+}
+
+func TestRelayForward(t *testing.T) {
+	e := newRelayEnv(t)
+	pub, pubConn, _ := e.dial()
+	_, subConn, recv := e.dial()
+	if err := e.r.Publish("dev1", 1, pubConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.r.Subscribe("dev1", 1, subConn); err != nil {
+		t.Fatal(err)
+	}
+	// 订阅者从 I 帧开始接收
+	e.publish(pub, 1, 9, packets.FRAMENONE, "p9")
+	e.publish(pub, 1, 10, packets.FRAMEI, "i10")
+	e.publish(pub, 1, 11, packets.FRAMENONE, "p11")
+	expect(t, recv, 1, map[uint64]string{10: "i10", 11: "p11"})
+
+	// 另一个连接不能发布同一路流
+	if err := e.r.Publish("dev1", 1, subConn); status.CodeOf(err) != status.AlreadyExists {
+		t.Fatalf("Publish: %v", err)
+	}
+	// 未发布的 AVChannel
+	if err := e.send(pub, 2, 1, packets.FRAMEI, "x"); err == nil {
+		t.Fatal("unpublished AVChannel forwarded")
+	}
+}
+
+func TestRelaySubscribeAs(t *testing.T) {
+	e := newRelayEnv(t)
+	pub, pubConn, _ := e.dial()
+	_, subConn, recv := e.dial()
+	if err := e.r.Publish("dev1", 1, pubConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.r.SubscribeAs("dev1", 1, subConn, 5); err != nil {
+		t.Fatal(err)
+	}
+	// 重复订阅同一路流
+	if err := e.r.SubscribeAs("dev1", 1, subConn, 5); err != nil {
+		t.Fatal(err)
+	}
+	// AVChannel 已经用于其他流
+	if err := e.r.SubscribeAs("dev2", 1, subConn, 5); status.CodeOf(err) != status.AlreadyExists {
+		t.Fatalf("SubscribeAs: %v", err)
+	}
+	// 已经订阅的流不能使用其他 AVChannel
+	if err := e.r.SubscribeAs("dev1", 1, subConn, 6); status.CodeOf(err) != status.AlreadyExists {
+		t.Fatalf("SubscribeAs: %v", err)
+	}
+
+	e.publish(pub, 1, 1, packets.FRAMEI, "i1")
+	expect(t, recv, 5, map[uint64]string{1: "i1"})
+	if st := e.r.Stats("dev1", 1); len(st) != 1 || st[0].Conn != subConn {
+		t.Fatalf("Stats: %+v", st)
+	}
+
+	e.r.Unsubscribe("dev1", 1, subConn)
+	if err := e.r.SubscribeAs("dev2", 1, subConn, 5); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelayGOPCache(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		seqs []uint64 // 第一个为 I 帧
+		want []uint64 // 订阅者收到的 AVSeq, 最后一个为实时报文
+	}{
+		{"contiguous", []uint64{10, 11, 12}, []uint64{10, 11, 12, 13}},
+		{"gap", []uint64{10, 12, 13}, []uint64{11, 12, 13, 14}},
+		{"wrap", []uint64{seqMod - 2, 0}, []uint64{seqMod - 1, 0, 1}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			e := newRelayEnv(t)
+			pub, pubConn, _ := e.dial()
+			_, subConn, recv := e.dial()
+			if err := e.r.Publish("dev1", 1, pubConn); err != nil {
+				t.Fatal(err)
+			}
+			// 旧的 GOP 不缓存
+			e.publish(pub, 1, 1000, packets.FRAMEI, "old")
+			for i, seq := range c.seqs {
+				iframe := packets.FRAMENONE
+				if i == 0 {
+					iframe = packets.FRAMEI
+				}
+				e.publish(pub, 1, seq, iframe, fmt.Sprint(i))
+			}
+			// 新的订阅者先收到缓存的 GOP, 再收到实时报文
+			if err := e.r.Subscribe("dev1", 1, subConn); err != nil {
+				t.Fatal(err)
+			}
+			live := (c.seqs[len(c.seqs)-1] + 1) % seqMod
+			e.publish(pub, 1, live, packets.FRAMENONE, "live")
+			want := make(map[uint64]string)
+			for i, seq := range c.want {
+				want[seq] = fmt.Sprint(i)
+			}
+			want[c.want[len(c.want)-1]] = "live"
+			expect(t, recv, 1, want)
+		})
+	}
+}
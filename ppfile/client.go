@@ -0,0 +1,356 @@
+package ppfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pprpc "github.com/pprpc/core"
+	"github.com/pprpc/packets"
+	"github.com/pprpc/status"
+	"github.com/pprpc/util/logs"
+)
+
+// Client 文件传输客户端.
+type Client struct {
+	cc            pprpc.RPCCliConn
+	st            Storage
+	ChunkSize     uint32        // 期望的分片大小, 0 使用 DefChunkSize
+	EncryptType   uint8         // 上传数据的加密类型, 默认 AESNONE
+	Retry         int           // 中断后续传的次数, 默认 3
+	RetryWait     time.Duration // 续传前等待的时间(重连), 默认 1s
+	IdleTimeout   time.Duration // 下载时没有收到数据的超时时间, 默认 30s
+	MaxWindow     int           // 上传的最大发送窗口(分片数), 0 使用 DefMaxWindow
+	RateLimit     int64         // 每个上传的速率上限(字节/秒), 0 不限制
+	ConnRateLimit int64         // 连接所有上传的速率上限(字节/秒), 0 不限制
+	Progress      ProgressFunc  // 上传(确认)和下载(接收)的进度
+
+	file      *FileClient
+	chunk     *ChunkClient
+	fileID    uint64
+	mu        sync.Mutex
+	connLimit *Limiter
+	uploads   map[uint64]*sender   // FileID(服务端分配)
+	downloads map[uint64]*recvFile // FileID(客户端分配)
+}
+
+// recvFile 下载中的文件
+type recvFile struct {
+	id   uint64
+	name string
+	mu   sync.Mutex
+	pf   PartialFile
+	size uint64     // 文件长度, 打开会话后有效
+	done chan error // 结束分片或者错误
+	end  bool
+}
+
+// finish 结束接收, 之后的分片丢弃.
+func (r *recvFile) finish(err error) {
+	if !r.end {
+		r.end = true
+		r.done <- err
+	}
+}
+
+func (r *recvFile) saved() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pf.Size()
+}
+
+// NewClient 创建客户端并在 s(cc 使用的 Service) 注册文件传输命令, 本地文件保存在 st;
+// 需要设置 cc 的 FileCB 为 HandleFile.
+func NewClient(s *pprpc.Service, cc pprpc.RPCCliConn, st Storage) *Client {
+	c := &Client{
+		cc:          cc,
+		file:        NewFileClient(cc),
+		chunk:       NewChunkClient(cc),
+		st:          st,
+		Retry:       3,
+		RetryWait:   time.Second,
+		IdleTimeout: 30 * time.Second,
+		uploads:     make(map[uint64]*sender),
+		downloads:   make(map[uint64]*recvFile),
+	}
+	RegisterFile(s, nil)
+	RegisterChunk(s, chunkClient{c})
+	return c
+}
+
+// retry 等待后续传, 超过次数或者 ctx 结束时返回 err.
+func (c *Client) retry(ctx context.Context, n int, err error) error {
+	if n >= c.Retry {
+		return err
+	}
+	logs.Logger.Debugf("%s, file transfer retry(%d): %s.", c.cc, n+1, err)
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err())
+	case <-time.After(c.RetryWait):
+	}
+	return nil
+}
+
+// closeSession 通知对端取消会话(忽略错误).
+func (c *Client) closeSession(id uint64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	c.file.Close(ctx, &CloseReq{FileId: id})
+}
+
+// Upload 上传本地文件 local, 服务端保存为 remote; 中断后从服务端已经保存的长度续传.
+func (c *Client) Upload(ctx context.Context, local, remote string) error {
+	f, err := c.st.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sum, err := fileSHA256(f, f.Size())
+	if err != nil {
+		return err
+	}
+	req := &UploadOpenReq{Name: remote, Size: uint64(f.Size()), Sha256: sum, ChunkSize: c.ChunkSize}
+
+	for n := 0; ; n++ {
+		err = c.upload(ctx, f, req)
+		if err == nil || status.CodeOf(err) == status.DataLoss {
+			return err
+		}
+		if err = c.retry(ctx, n, err); err != nil {
+			return err
+		}
+	}
+}
+
+// upload 打开上传会话并按窗口发送数据, 直到服务端保存完整的文件.
+func (c *Client) upload(ctx context.Context, f File, req *UploadOpenReq) error {
+	resp, err := c.file.UploadOpen(ctx, req)
+	if err != nil {
+		return err
+	}
+	s := newSender(c.cc, c.cc.Type(), packets.FILEC2S, resp.FileId, f, chunkSize(resp.ChunkSize), c.MaxWindow)
+	s.encType = c.EncryptType
+	s.limiters = []*Limiter{NewLimiter(c.RateLimit), c.connLimiter()}
+	s.progress = c.Progress
+	s.info = Progress{Name: req.Name, Upload: true}
+	c.mu.Lock()
+	c.uploads[resp.FileId] = s
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.uploads, resp.FileId)
+		c.mu.Unlock()
+	}()
+
+	offset := resp.Offset
+	for {
+		if err = s.run(ctx, offset); err != nil {
+			c.closeSession(resp.FileId)
+			return err
+		}
+		commit, err := c.file.UploadCommit(ctx, &CommitReq{FileId: resp.FileId})
+		if err != nil {
+			if status.CodeOf(err) != status.DataLoss {
+				c.closeSession(resp.FileId)
+			}
+			return err
+		}
+		next := commit.Offset
+		if next == req.Size {
+			return nil
+		}
+		if next <= offset {
+			// 没有进展
+			c.closeSession(resp.FileId)
+			return status.Errorf(status.Aborted, "file: %s, offset: %d, no progress", req.Name, next)
+		}
+		offset = next
+	}
+}
+
+// connLimiter 连接的速率限制.
+func (c *Client) connLimiter() *Limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.connLimit == nil {
+		c.connLimit = NewLimiter(c.ConnRateLimit)
+	} else {
+		c.connLimit.SetRate(c.ConnRateLimit)
+	}
+	return c.connLimit
+}
+
+// onAck 服务端确认上传的数据.
+func (c *Client) onAck(conn pprpc.RPCConn, req *AckReq) error {
+	c.mu.Lock()
+	s, ok := c.uploads[req.FileId]
+	c.mu.Unlock()
+	if ok {
+		s.ack(req.Offset)
+	}
+	return nil
+}
+
+// chunkClient 实现生成的 ChunkServer, Client 不导出 Ack.
+type chunkClient struct {
+	c *Client
+}
+
+func (x chunkClient) Ack(conn pprpc.RPCConn, pkg *packets.CmdPacket, req *AckReq) error {
+	return x.c.onAck(conn, req)
+}
+
+// Download 下载服务端的文件 remote, 本地保存为 local; 中断后从本地已经保存的长度续传.
+func (c *Client) Download(ctx context.Context, remote, local string) error {
+	pf, err := c.st.OpenPartial(local)
+	if err != nil {
+		return err
+	}
+	for n := 0; ; n++ {
+		var commit bool
+		commit, err = c.download(ctx, pf, remote)
+		if commit {
+			return err
+		}
+		if err == nil {
+			continue
+		}
+		if status.CodeOf(err) == status.NotFound {
+			break
+		}
+		if err = c.retry(ctx, n, err); err != nil {
+			break
+		}
+	}
+	pf.Close()
+	return err
+}
+
+// download 打开下载会话并接收数据, 接收完整并校验通过后完成文件(commit 为 true).
+func (c *Client) download(ctx context.Context, pf PartialFile, remote string) (commit bool, err error) {
+	id := atomic.AddUint64(&c.fileID, 1)
+	r := &recvFile{id: id, name: remote, pf: pf, done: make(chan error, 1)}
+	c.mu.Lock()
+	c.downloads[id] = r
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.downloads, id)
+		c.mu.Unlock()
+		// HandleFile 不再使用 pf
+		r.mu.Lock()
+		r.end = true
+		r.mu.Unlock()
+	}()
+
+	offset := uint64(r.saved())
+	req := &DownloadOpenReq{Name: remote, FileId: id, Offset: offset, ChunkSize: c.ChunkSize}
+	resp, err := c.file.DownloadOpen(ctx, req)
+	if status.CodeOf(err) == status.OutOfRange {
+		// 本地数据比文件长, 重新下载
+		return false, pf.Truncate(0)
+	}
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	r.size = resp.Size
+	r.mu.Unlock()
+
+	if err = c.wait(ctx, r); err != nil {
+		c.closeSession(id)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	size := uint64(pf.Size())
+	if size != resp.Size {
+		return false, status.Errorf(status.DataLoss, "file: %s, size: %d, expect: %d", remote, size, resp.Size)
+	}
+	sum, err := fileSHA256(pf, pf.Size())
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(sum, resp.Sha256) {
+		// 文件已经改变, 重新下载
+		if err = pf.Truncate(0); err != nil {
+			return
+		}
+		return false, status.Errorf(status.DataLoss, "file: %s, sha256 mismatch", remote)
+	}
+	return true, pf.Commit()
+}
+
+// wait 等待接收结束, 超过 IdleTimeout 没有收到数据时返回错误.
+func (c *Client) wait(ctx context.Context, r *recvFile) error {
+	t := time.NewTicker(c.IdleTimeout)
+	defer t.Stop()
+	last := r.saved()
+	for {
+		select {
+		case err := <-r.done:
+			return err
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err())
+		case <-t.C:
+			size := r.saved()
+			if size == last {
+				return status.Errorf(status.DeadlineExceeded, "offset: %d, receive timeout", size)
+			}
+			last = size
+		}
+	}
+}
+
+// HandleFile 处理下载的分片并确认已经保存的长度, 设置为 TCPCliConn/UDPCliConn 的 FileCB.
+func (c *Client) HandleFile(fp *packets.FilePacket, conn pprpc.RPCConn) error {
+	c.mu.Lock()
+	r := c.downloads[fp.FileID]
+	c.mu.Unlock()
+	if r == nil {
+		return fmt.Errorf("FileID: %d, download session not found", fp.FileID)
+	}
+
+	r.mu.Lock()
+	if r.end {
+		r.mu.Unlock()
+		return nil
+	}
+	wrote, err := r.write(fp)
+	saved, p := uint64(r.pf.Size()), Progress{FileID: r.id, Name: r.name, Size: r.size}
+	r.mu.Unlock()
+
+	if e := c.chunk.Ack(context.Background(), &AckReq{FileId: fp.FileID, Offset: saved}); e != nil && err == nil {
+		err = e
+	}
+	if wrote && c.Progress != nil {
+		p.Offset = saved
+		c.Progress(p)
+	}
+	return err
+}
+
+// write 保存与已保存长度连续的分片, 其他分片(重传, 丢包后的分片)丢弃; 收到结束分片时结束接收.
+func (r *recvFile) write(fp *packets.FilePacket) (bool, error) {
+	if fp.Offset != uint64(r.pf.Size()) {
+		return false, nil
+	}
+	data, err := chunkData(fp, packets.FILES2C)
+	if err != nil {
+		return false, err
+	}
+	if len(data) == 0 {
+		r.finish(nil)
+		return false, nil
+	}
+	if _, err = r.pf.Write(data); err != nil {
+		r.finish(err)
+		return false, err
+	}
+	return true, nil
+}
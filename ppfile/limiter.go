@@ -0,0 +1,78 @@
+package ppfile
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pprpc/status"
+)
+
+// Limiter 字节速率限制(令牌桶), 可以在多个传输之间共享; nil 不限制.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // 字节/秒
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter 创建速率限制, bytesPerSec <= 0 时返回 nil(不限制).
+func NewLimiter(bytesPerSec int64) *Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	l := &Limiter{last: time.Now()}
+	l.setRate(bytesPerSec)
+	l.tokens = l.burst
+	return l
+}
+
+// SetRate 修改速率.
+func (l *Limiter) SetRate(bytesPerSec int64) {
+	if l == nil || bytesPerSec <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.setRate(bytesPerSec)
+}
+
+func (l *Limiter) setRate(bytesPerSec int64) {
+	l.rate = float64(bytesPerSec)
+	// 允许 100ms 的突发
+	l.burst = l.rate / 10
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// Wait 等待发送 n 字节.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// 预留 n 字节, 令牌不足时等待补足
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err())
+	}
+}
@@ -0,0 +1,85 @@
+// Package ppfile 基于 FilePacket 的文件传输(上传/下载), 支持断点续传.
+//
+// 会话通过 CmdPacket 协商(ppfile.proto 的 File 服务: UploadOpen/UploadCommit/DownloadOpen/Close), 文件数据通过 FilePacket 发送:
+// FileID 为会话ID(上传由服务端分配, 下载由客户端分配, 通过 Dir 区分), Offset 为数据在文件中的偏移,
+// Payload 为分片数据 + CRC32(IEEE, 大端 4 字节);
+// 数据长度为 0 的分片表示文件结束. 接收方只接受与已保存长度连续的分片, 每收到一个分片
+// 通过 Chunk.Ack(没有应答) 确认已保存的长度; 发送方按窗口发送, 超时或者重复确认时从确认的位置重新发送.
+// 中断(断线)后重新打开会话, 从已保存的长度继续传输; 传输完成后校验整个文件的 SHA-256.
+//
+// 服务端:
+//
+//	fs := ppfile.NewServer(st)
+//	fs.Register(service)
+//	ts.FileCB = fs.HandleFile
+//
+// 客户端:
+//
+//	fc := ppfile.NewClient(service, tcc, st)
+//	tcc.FileCB = fc.HandleFile
+//	err := fc.Upload(ctx, "local.bin", "remote.bin")
+package ppfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/pprpc/packets"
+)
+
+const (
+	// DefChunkSize 默认分片大小
+	DefChunkSize = 32 * 1024
+	// MaxChunkSize 最大分片大小
+	MaxChunkSize = 1024 * 1024
+	// crcLen 分片校验长度
+	crcLen = 4
+)
+
+// chunkSize 协商分片大小: 0 使用默认值, 不超过 MaxChunkSize.
+func chunkSize(n uint32) uint32 {
+	if n == 0 {
+		return DefChunkSize
+	}
+	if n > MaxChunkSize {
+		return MaxChunkSize
+	}
+	return n
+}
+
+// newChunk 创建文件分片报文, dir 为发送方向.
+func newChunk(connType string, dir uint8, fileID, offset uint64, data []byte, encType uint8) *packets.FilePacket {
+	fp := packets.NewFilePacket()
+	if connType == "U" {
+		fp.FixHeader.SetProtocol(packets.PROTOUDP)
+	}
+	fp.Dir = dir
+	fp.FileID = fileID
+	fp.Offset = offset
+	fp.EncryptType = encType
+	fp.AutoCrypt = encType != packets.AESNONE
+	fp.Payload = make([]byte, len(data)+crcLen)
+	copy(fp.Payload, data)
+	binary.BigEndian.PutUint32(fp.Payload[len(data):], crc32.ChecksumIEEE(data))
+	return fp
+}
+
+// chunkData 校验分片(连接的 AutoCrypt 为 false 时先解密), 返回分片数据; dir 为对端的发送方向.
+func chunkData(fp *packets.FilePacket, dir uint8) ([]byte, error) {
+	if fp.Dir != dir {
+		return nil, fmt.Errorf("FileID: %d, Offset: %d, Dir: %d, unexpected direction", fp.FileID, fp.Offset, fp.Dir)
+	}
+	if err := fp.Decrypt(); err != nil {
+		return nil, err
+	}
+	if len(fp.Payload) < crcLen {
+		return nil, fmt.Errorf("FileID: %d, Offset: %d, chunk too short: %d", fp.FileID, fp.Offset, len(fp.Payload))
+	}
+	n := len(fp.Payload) - crcLen
+	data := fp.Payload[:n]
+	if binary.BigEndian.Uint32(fp.Payload[n:]) != crc32.ChecksumIEEE(data) {
+		return nil, fmt.Errorf("FileID: %d, Offset: %d, chunk crc32 mismatch", fp.FileID, fp.Offset)
+	}
+	return data, nil
+}
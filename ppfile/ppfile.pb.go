@@ -0,0 +1,624 @@
+// ppfile 文件传输的控制消息和命令, 生成 ppfile.pb.go 和 ppfile.pprpc.go:
+//
+//	protoc -I . -I ../protoc-gen-pprpc --go_out=. --go_opt=module=github.com/pprpc/ppfile \
+//		--pprpc_out=. --pprpc_opt=module=github.com/pprpc/ppfile ppfile.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: ppfile.proto
+
+package ppfile
+
+import (
+	_ "github.com/pprpc/protoc-gen-pprpc/pprpcopt"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// UploadOpenReq 打开上传会话(CmdIDFileUploadOpen)
+type UploadOpenReq struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`                             // 服务端保存的文件名
+	Size          uint64                 `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`                            // 文件长度
+	Sha256        []byte                 `protobuf:"bytes,3,opt,name=sha256,proto3" json:"sha256,omitempty"`                         // 整个文件的 SHA-256
+	ChunkSize     uint32                 `protobuf:"varint,4,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"` // 客户端期望的分片大小
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadOpenReq) Reset() {
+	*x = UploadOpenReq{}
+	mi := &file_ppfile_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadOpenReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadOpenReq) ProtoMessage() {}
+
+func (x *UploadOpenReq) ProtoReflect() protoreflect.Message {
+	mi := &file_ppfile_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadOpenReq.ProtoReflect.Descriptor instead.
+func (*UploadOpenReq) Descriptor() ([]byte, []int) {
+	return file_ppfile_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *UploadOpenReq) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *UploadOpenReq) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *UploadOpenReq) GetSha256() []byte {
+	if x != nil {
+		return x.Sha256
+	}
+	return nil
+}
+
+func (x *UploadOpenReq) GetChunkSize() uint32 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+type UploadOpenResp struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FileId        uint64                 `protobuf:"varint,1,opt,name=file_id,json=fileId,proto3" json:"file_id,omitempty"`          // 服务端分配的 FileID
+	Offset        uint64                 `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`                        // 已经保存的长度, 从该位置继续发送
+	ChunkSize     uint32                 `protobuf:"varint,3,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"` // 分片大小
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadOpenResp) Reset() {
+	*x = UploadOpenResp{}
+	mi := &file_ppfile_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadOpenResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadOpenResp) ProtoMessage() {}
+
+func (x *UploadOpenResp) ProtoReflect() protoreflect.Message {
+	mi := &file_ppfile_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadOpenResp.ProtoReflect.Descriptor instead.
+func (*UploadOpenResp) Descriptor() ([]byte, []int) {
+	return file_ppfile_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *UploadOpenResp) GetFileId() uint64 {
+	if x != nil {
+		return x.FileId
+	}
+	return 0
+}
+
+func (x *UploadOpenResp) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *UploadOpenResp) GetChunkSize() uint32 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+// CommitReq 上传的数据发送完成(CmdIDFileUploadCommit)
+type CommitReq struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FileId        uint64                 `protobuf:"varint,1,opt,name=file_id,json=fileId,proto3" json:"file_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommitReq) Reset() {
+	*x = CommitReq{}
+	mi := &file_ppfile_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommitReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommitReq) ProtoMessage() {}
+
+func (x *CommitReq) ProtoReflect() protoreflect.Message {
+	mi := &file_ppfile_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommitReq.ProtoReflect.Descriptor instead.
+func (*CommitReq) Descriptor() ([]byte, []int) {
+	return file_ppfile_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CommitReq) GetFileId() uint64 {
+	if x != nil {
+		return x.FileId
+	}
+	return 0
+}
+
+type CommitResp struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Offset        uint64                 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"` // 已经保存的长度, 小于文件长度时从该位置继续发送
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommitResp) Reset() {
+	*x = CommitResp{}
+	mi := &file_ppfile_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommitResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommitResp) ProtoMessage() {}
+
+func (x *CommitResp) ProtoReflect() protoreflect.Message {
+	mi := &file_ppfile_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommitResp.ProtoReflect.Descriptor instead.
+func (*CommitResp) Descriptor() ([]byte, []int) {
+	return file_ppfile_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CommitResp) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+// DownloadOpenReq 打开下载会话(CmdIDFileDownloadOpen)
+type DownloadOpenReq struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	FileId        uint64                 `protobuf:"varint,2,opt,name=file_id,json=fileId,proto3" json:"file_id,omitempty"` // 客户端分配的 FileID
+	Offset        uint64                 `protobuf:"varint,3,opt,name=offset,proto3" json:"offset,omitempty"`               // 本地已经保存的长度
+	ChunkSize     uint32                 `protobuf:"varint,4,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DownloadOpenReq) Reset() {
+	*x = DownloadOpenReq{}
+	mi := &file_ppfile_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DownloadOpenReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DownloadOpenReq) ProtoMessage() {}
+
+func (x *DownloadOpenReq) ProtoReflect() protoreflect.Message {
+	mi := &file_ppfile_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DownloadOpenReq.ProtoReflect.Descriptor instead.
+func (*DownloadOpenReq) Descriptor() ([]byte, []int) {
+	return file_ppfile_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *DownloadOpenReq) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *DownloadOpenReq) GetFileId() uint64 {
+	if x != nil {
+		return x.FileId
+	}
+	return 0
+}
+
+func (x *DownloadOpenReq) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *DownloadOpenReq) GetChunkSize() uint32 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+type DownloadOpenResp struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Size          uint64                 `protobuf:"varint,1,opt,name=size,proto3" json:"size,omitempty"`
+	Sha256        []byte                 `protobuf:"bytes,2,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	ChunkSize     uint32                 `protobuf:"varint,3,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DownloadOpenResp) Reset() {
+	*x = DownloadOpenResp{}
+	mi := &file_ppfile_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DownloadOpenResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DownloadOpenResp) ProtoMessage() {}
+
+func (x *DownloadOpenResp) ProtoReflect() protoreflect.Message {
+	mi := &file_ppfile_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DownloadOpenResp.ProtoReflect.Descriptor instead.
+func (*DownloadOpenResp) Descriptor() ([]byte, []int) {
+	return file_ppfile_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DownloadOpenResp) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *DownloadOpenResp) GetSha256() []byte {
+	if x != nil {
+		return x.Sha256
+	}
+	return nil
+}
+
+func (x *DownloadOpenResp) GetChunkSize() uint32 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+// CloseReq 取消会话(CmdIDFileClose)
+type CloseReq struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FileId        uint64                 `protobuf:"varint,1,opt,name=file_id,json=fileId,proto3" json:"file_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CloseReq) Reset() {
+	*x = CloseReq{}
+	mi := &file_ppfile_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CloseReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CloseReq) ProtoMessage() {}
+
+func (x *CloseReq) ProtoReflect() protoreflect.Message {
+	mi := &file_ppfile_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CloseReq.ProtoReflect.Descriptor instead.
+func (*CloseReq) Descriptor() ([]byte, []int) {
+	return file_ppfile_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CloseReq) GetFileId() uint64 {
+	if x != nil {
+		return x.FileId
+	}
+	return 0
+}
+
+type CloseResp struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CloseResp) Reset() {
+	*x = CloseResp{}
+	mi := &file_ppfile_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CloseResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CloseResp) ProtoMessage() {}
+
+func (x *CloseResp) ProtoReflect() protoreflect.Message {
+	mi := &file_ppfile_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CloseResp.ProtoReflect.Descriptor instead.
+func (*CloseResp) Descriptor() ([]byte, []int) {
+	return file_ppfile_proto_rawDescGZIP(), []int{7}
+}
+
+// AckReq 接收方确认已经保存的长度(CmdIDChunkAck, 没有应答)
+type AckReq struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FileId        uint64                 `protobuf:"varint,1,opt,name=file_id,json=fileId,proto3" json:"file_id,omitempty"`
+	Offset        uint64                 `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"` // 连续保存的长度
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AckReq) Reset() {
+	*x = AckReq{}
+	mi := &file_ppfile_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AckReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AckReq) ProtoMessage() {}
+
+func (x *AckReq) ProtoReflect() protoreflect.Message {
+	mi := &file_ppfile_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AckReq.ProtoReflect.Descriptor instead.
+func (*AckReq) Descriptor() ([]byte, []int) {
+	return file_ppfile_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *AckReq) GetFileId() uint64 {
+	if x != nil {
+		return x.FileId
+	}
+	return 0
+}
+
+func (x *AckReq) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+var File_ppfile_proto protoreflect.FileDescriptor
+
+const file_ppfile_proto_rawDesc = "" +
+	"\n" +
+	"\fppfile.proto\x12\x06ppfile\x1a\vpprpc.proto\x1a\x1bgoogle/protobuf/empty.proto\"n\n" +
+	"\rUploadOpenReq\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x04R\x04size\x12\x16\n" +
+	"\x06sha256\x18\x03 \x01(\fR\x06sha256\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\x04 \x01(\rR\tchunkSize\"`\n" +
+	"\x0eUploadOpenResp\x12\x17\n" +
+	"\afile_id\x18\x01 \x01(\x04R\x06fileId\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x04R\x06offset\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\x03 \x01(\rR\tchunkSize\"$\n" +
+	"\tCommitReq\x12\x17\n" +
+	"\afile_id\x18\x01 \x01(\x04R\x06fileId\"$\n" +
+	"\n" +
+	"CommitResp\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x04R\x06offset\"u\n" +
+	"\x0fDownloadOpenReq\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x17\n" +
+	"\afile_id\x18\x02 \x01(\x04R\x06fileId\x12\x16\n" +
+	"\x06offset\x18\x03 \x01(\x04R\x06offset\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\x04 \x01(\rR\tchunkSize\"]\n" +
+	"\x10DownloadOpenResp\x12\x12\n" +
+	"\x04size\x18\x01 \x01(\x04R\x04size\x12\x16\n" +
+	"\x06sha256\x18\x02 \x01(\fR\x06sha256\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\x03 \x01(\rR\tchunkSize\"#\n" +
+	"\bCloseReq\x12\x17\n" +
+	"\afile_id\x18\x01 \x01(\x04R\x06fileId\"\v\n" +
+	"\tCloseResp\"9\n" +
+	"\x06AckReq\x12\x17\n" +
+	"\afile_id\x18\x01 \x01(\x04R\x06fileId\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x04R\x06offset2\x8f\x02\n" +
+	"\x04File\x12D\n" +
+	"\n" +
+	"UploadOpen\x12\x15.ppfile.UploadOpenReq\x1a\x16.ppfile.UploadOpenResp\"\a\x90\xb3\x19\x80\xfe\xff\x7f\x12>\n" +
+	"\fUploadCommit\x12\x11.ppfile.CommitReq\x1a\x12.ppfile.CommitResp\"\a\x90\xb3\x19\x81\xfe\xff\x7f\x12J\n" +
+	"\fDownloadOpen\x12\x17.ppfile.DownloadOpenReq\x1a\x18.ppfile.DownloadOpenResp\"\a\x90\xb3\x19\x82\xfe\xff\x7f\x125\n" +
+	"\x05Close\x12\x10.ppfile.CloseReq\x1a\x11.ppfile.CloseResp\"\a\x90\xb3\x19\x83\xfe\xff\x7f2C\n" +
+	"\x05Chunk\x12:\n" +
+	"\x03Ack\x12\x0e.ppfile.AckReq\x1a\x16.google.protobuf.Empty\"\v\x90\xb3\x19\x84\xfe\xff\x7f\x98\xb3\x19\x01B Z\x1egithub.com/pprpc/ppfile;ppfileb\x06proto3"
+
+var (
+	file_ppfile_proto_rawDescOnce sync.Once
+	file_ppfile_proto_rawDescData []byte
+)
+
+func file_ppfile_proto_rawDescGZIP() []byte {
+	file_ppfile_proto_rawDescOnce.Do(func() {
+		file_ppfile_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_ppfile_proto_rawDesc), len(file_ppfile_proto_rawDesc)))
+	})
+	return file_ppfile_proto_rawDescData
+}
+
+var file_ppfile_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_ppfile_proto_goTypes = []any{
+	(*UploadOpenReq)(nil),    // 0: ppfile.UploadOpenReq
+	(*UploadOpenResp)(nil),   // 1: ppfile.UploadOpenResp
+	(*CommitReq)(nil),        // 2: ppfile.CommitReq
+	(*CommitResp)(nil),       // 3: ppfile.CommitResp
+	(*DownloadOpenReq)(nil),  // 4: ppfile.DownloadOpenReq
+	(*DownloadOpenResp)(nil), // 5: ppfile.DownloadOpenResp
+	(*CloseReq)(nil),         // 6: ppfile.CloseReq
+	(*CloseResp)(nil),        // 7: ppfile.CloseResp
+	(*AckReq)(nil),           // 8: ppfile.AckReq
+	(*emptypb.Empty)(nil),    // 9: google.protobuf.Empty
+}
+var file_ppfile_proto_depIdxs = []int32{
+	0, // 0: ppfile.File.UploadOpen:input_type -> ppfile.UploadOpenReq
+	2, // 1: ppfile.File.UploadCommit:input_type -> ppfile.CommitReq
+	4, // 2: ppfile.File.DownloadOpen:input_type -> ppfile.DownloadOpenReq
+	6, // 3: ppfile.File.Close:input_type -> ppfile.CloseReq
+	8, // 4: ppfile.Chunk.Ack:input_type -> ppfile.AckReq
+	1, // 5: ppfile.File.UploadOpen:output_type -> ppfile.UploadOpenResp
+	3, // 6: ppfile.File.UploadCommit:output_type -> ppfile.CommitResp
+	5, // 7: ppfile.File.DownloadOpen:output_type -> ppfile.DownloadOpenResp
+	7, // 8: ppfile.File.Close:output_type -> ppfile.CloseResp
+	9, // 9: ppfile.Chunk.Ack:output_type -> google.protobuf.Empty
+	5, // [5:10] is the sub-list for method output_type
+	0, // [0:5] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_ppfile_proto_init() }
+func file_ppfile_proto_init() {
+	if File_ppfile_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_ppfile_proto_rawDesc), len(file_ppfile_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   2,
+		},
+		GoTypes:           file_ppfile_proto_goTypes,
+		DependencyIndexes: file_ppfile_proto_depIdxs,
+		MessageInfos:      file_ppfile_proto_msgTypes,
+	}.Build()
+	File_ppfile_proto = out.File
+	file_ppfile_proto_goTypes = nil
+	file_ppfile_proto_depIdxs = nil
+}
@@ -0,0 +1,278 @@
+// Code generated by protoc-gen-pprpc. DO NOT EDIT.
+// source: ppfile.proto
+
+package ppfile
+
+import (
+	context "context"
+	fmt "fmt"
+	core "github.com/pprpc/core"
+	packets "github.com/pprpc/packets"
+)
+
+// File CmdID 定义
+const (
+	CmdIDFileUploadOpen   uint64 = 268435200
+	CmdIDFileUploadCommit uint64 = 268435201
+	CmdIDFileDownloadOpen uint64 = 268435202
+	CmdIDFileClose        uint64 = 268435203
+)
+
+// FileServer File 服务端接口, 返回的应答由生成代码写回.
+type FileServer interface {
+	UploadOpen(conn core.RPCConn, pkg *packets.CmdPacket, req *UploadOpenReq) (*UploadOpenResp, error)
+	UploadCommit(conn core.RPCConn, pkg *packets.CmdPacket, req *CommitReq) (*CommitResp, error)
+	DownloadOpen(conn core.RPCConn, pkg *packets.CmdPacket, req *DownloadOpenReq) (*DownloadOpenResp, error)
+	Close(conn core.RPCConn, pkg *packets.CmdPacket, req *CloseReq) (*CloseResp, error)
+}
+
+func _File_UploadOpen_ReqHandler(srv interface{}, conn core.RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+	in := new(UploadOpenReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if !isCall {
+		return in, nil
+	}
+	impl, ok := srv.(FileServer)
+	if !ok {
+		return in, fmt.Errorf("CmdID: %d, FileServer not implement", CmdIDFileUploadOpen)
+	}
+	out, err := impl.UploadOpen(conn, pkg, in)
+	if err != nil {
+		return out, err
+	}
+	_, err = core.WriteResp(conn, pkg, out)
+	return out, err
+}
+
+func _File_UploadOpen_RespHandler(srv interface{}, conn core.RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+	out := new(UploadOpenResp)
+	if err := dec(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _File_UploadCommit_ReqHandler(srv interface{}, conn core.RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+	in := new(CommitReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if !isCall {
+		return in, nil
+	}
+	impl, ok := srv.(FileServer)
+	if !ok {
+		return in, fmt.Errorf("CmdID: %d, FileServer not implement", CmdIDFileUploadCommit)
+	}
+	out, err := impl.UploadCommit(conn, pkg, in)
+	if err != nil {
+		return out, err
+	}
+	_, err = core.WriteResp(conn, pkg, out)
+	return out, err
+}
+
+func _File_UploadCommit_RespHandler(srv interface{}, conn core.RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+	out := new(CommitResp)
+	if err := dec(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _File_DownloadOpen_ReqHandler(srv interface{}, conn core.RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+	in := new(DownloadOpenReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if !isCall {
+		return in, nil
+	}
+	impl, ok := srv.(FileServer)
+	if !ok {
+		return in, fmt.Errorf("CmdID: %d, FileServer not implement", CmdIDFileDownloadOpen)
+	}
+	out, err := impl.DownloadOpen(conn, pkg, in)
+	if err != nil {
+		return out, err
+	}
+	_, err = core.WriteResp(conn, pkg, out)
+	return out, err
+}
+
+func _File_DownloadOpen_RespHandler(srv interface{}, conn core.RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+	out := new(DownloadOpenResp)
+	if err := dec(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _File_Close_ReqHandler(srv interface{}, conn core.RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+	in := new(CloseReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if !isCall {
+		return in, nil
+	}
+	impl, ok := srv.(FileServer)
+	if !ok {
+		return in, fmt.Errorf("CmdID: %d, FileServer not implement", CmdIDFileClose)
+	}
+	out, err := impl.Close(conn, pkg, in)
+	if err != nil {
+		return out, err
+	}
+	_, err = core.WriteResp(conn, pkg, out)
+	return out, err
+}
+
+func _File_Close_RespHandler(srv interface{}, conn core.RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+	out := new(CloseResp)
+	if err := dec(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RegisterFile 注册 File 的所有命令; 仅作为客户端使用时 impl 可以为 nil.
+func RegisterFile(s *core.Service, impl FileServer) {
+	s.RegisterService(&core.ServiceDesc{
+		CmdID:       CmdIDFileUploadOpen,
+		CmdName:     "File.UploadOpen",
+		ReqHandler:  _File_UploadOpen_ReqHandler,
+		RespHandler: _File_UploadOpen_RespHandler,
+	}, impl)
+	s.RegisterService(&core.ServiceDesc{
+		CmdID:       CmdIDFileUploadCommit,
+		CmdName:     "File.UploadCommit",
+		ReqHandler:  _File_UploadCommit_ReqHandler,
+		RespHandler: _File_UploadCommit_RespHandler,
+	}, impl)
+	s.RegisterService(&core.ServiceDesc{
+		CmdID:       CmdIDFileDownloadOpen,
+		CmdName:     "File.DownloadOpen",
+		ReqHandler:  _File_DownloadOpen_ReqHandler,
+		RespHandler: _File_DownloadOpen_RespHandler,
+	}, impl)
+	s.RegisterService(&core.ServiceDesc{
+		CmdID:       CmdIDFileClose,
+		CmdName:     "File.Close",
+		ReqHandler:  _File_Close_ReqHandler,
+		RespHandler: _File_Close_RespHandler,
+	}, impl)
+}
+
+// FileClient File 客户端, 连接上的 Service 需要先调用 RegisterFile.
+type FileClient struct {
+	cc core.RPCCliConn
+}
+
+// NewFileClient 创建客户端
+func NewFileClient(cc core.RPCCliConn) *FileClient {
+	return &FileClient{cc: cc}
+}
+
+// UploadOpen 同步调用 CmdIDFileUploadOpen, 错误应答返回 *status.Status
+func (c *FileClient) UploadOpen(ctx context.Context, req *UploadOpenReq) (*UploadOpenResp, error) {
+	_, resp, err := c.cc.Invoke(ctx, CmdIDFileUploadOpen, req)
+	if err != nil {
+		return nil, err
+	}
+	out, ok := resp.(*UploadOpenResp)
+	if !ok {
+		return nil, fmt.Errorf("CmdID: %d, response type %T not match", CmdIDFileUploadOpen, resp)
+	}
+	return out, nil
+}
+
+// UploadCommit 同步调用 CmdIDFileUploadCommit, 错误应答返回 *status.Status
+func (c *FileClient) UploadCommit(ctx context.Context, req *CommitReq) (*CommitResp, error) {
+	_, resp, err := c.cc.Invoke(ctx, CmdIDFileUploadCommit, req)
+	if err != nil {
+		return nil, err
+	}
+	out, ok := resp.(*CommitResp)
+	if !ok {
+		return nil, fmt.Errorf("CmdID: %d, response type %T not match", CmdIDFileUploadCommit, resp)
+	}
+	return out, nil
+}
+
+// DownloadOpen 同步调用 CmdIDFileDownloadOpen, 错误应答返回 *status.Status
+func (c *FileClient) DownloadOpen(ctx context.Context, req *DownloadOpenReq) (*DownloadOpenResp, error) {
+	_, resp, err := c.cc.Invoke(ctx, CmdIDFileDownloadOpen, req)
+	if err != nil {
+		return nil, err
+	}
+	out, ok := resp.(*DownloadOpenResp)
+	if !ok {
+		return nil, fmt.Errorf("CmdID: %d, response type %T not match", CmdIDFileDownloadOpen, resp)
+	}
+	return out, nil
+}
+
+// Close 同步调用 CmdIDFileClose, 错误应答返回 *status.Status
+func (c *FileClient) Close(ctx context.Context, req *CloseReq) (*CloseResp, error) {
+	_, resp, err := c.cc.Invoke(ctx, CmdIDFileClose, req)
+	if err != nil {
+		return nil, err
+	}
+	out, ok := resp.(*CloseResp)
+	if !ok {
+		return nil, fmt.Errorf("CmdID: %d, response type %T not match", CmdIDFileClose, resp)
+	}
+	return out, nil
+}
+
+// Chunk CmdID 定义
+const (
+	CmdIDChunkAck uint64 = 268435204
+)
+
+// ChunkServer Chunk 服务端接口, 返回的应答由生成代码写回.
+type ChunkServer interface {
+	Ack(conn core.RPCConn, pkg *packets.CmdPacket, req *AckReq) error
+}
+
+func _Chunk_Ack_ReqHandler(srv interface{}, conn core.RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+	in := new(AckReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if !isCall {
+		return in, nil
+	}
+	impl, ok := srv.(ChunkServer)
+	if !ok {
+		return in, fmt.Errorf("CmdID: %d, ChunkServer not implement", CmdIDChunkAck)
+	}
+	return in, impl.Ack(conn, pkg, in)
+}
+
+// RegisterChunk 注册 Chunk 的所有命令; 仅作为客户端使用时 impl 可以为 nil.
+func RegisterChunk(s *core.Service, impl ChunkServer) {
+	s.RegisterService(&core.ServiceDesc{
+		CmdID:      CmdIDChunkAck,
+		CmdName:    "Chunk.Ack",
+		ReqHandler: _Chunk_Ack_ReqHandler,
+	}, impl)
+}
+
+// ChunkClient Chunk 客户端, 连接上的 Service 需要先调用 RegisterChunk.
+type ChunkClient struct {
+	cc core.RPCCliConn
+}
+
+// NewChunkClient 创建客户端
+func NewChunkClient(cc core.RPCCliConn) *ChunkClient {
+	return &ChunkClient{cc: cc}
+}
+
+// Ack 异步调用 CmdIDChunkAck, 对端不写入应答
+func (c *ChunkClient) Ack(ctx context.Context, req *AckReq) error {
+	return c.cc.InvokeAsync(ctx, CmdIDChunkAck, req)
+}
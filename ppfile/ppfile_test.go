@@ -0,0 +1,234 @@
+package ppfile_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	pprpc "github.com/pprpc/core"
+	"github.com/pprpc/packets"
+	"github.com/pprpc/ppfile"
+	"github.com/pprpc/status"
+)
+
+const testFileSize = 300 * 1024
+
+// env 服务端和客户端使用不同的目录.
+type env struct {
+	srvDir, cliDir string
+	fs             *ppfile.Server
+	fc             *ppfile.Client
+
+	mu       sync.Mutex
+	progress []ppfile.Progress // 客户端的进度
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	e := &env{srvDir: filepath.Join(t.TempDir(), "srv"), cliDir: filepath.Join(t.TempDir(), "cli")}
+	srvSt, err := ppfile.NewLocalStorage(e.srvDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliSt, err := ppfile.NewLocalStorage(e.cliDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(fmt.Sprintf("mem://%s", t.Name()))
+	srv, err := pprpc.NewRPCTCPServer(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Service = pprpc.NewService()
+	srv.RunGO = true
+	e.fs = ppfile.NewServer(srvSt)
+	e.fs.Register(srv.Service)
+	srv.FileCB = e.fs.HandleFile
+	go srv.Serve()
+	t.Cleanup(srv.Stop)
+
+	s := pprpc.NewService()
+	cli, err := pprpc.Dail(u, nil, s, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	cli.SetCrypt(packets.AES256GCM)
+	e.fc = ppfile.NewClient(s, cli, cliSt)
+	e.fc.ChunkSize = 16 * 1024
+	e.fc.EncryptType = packets.AES256GCM
+	e.fc.Retry = 1
+	e.fc.RetryWait = 10 * time.Millisecond
+	e.fc.Progress = func(p ppfile.Progress) {
+		e.mu.Lock()
+		e.progress = append(e.progress, p)
+		e.mu.Unlock()
+	}
+	cli.FileCB = e.fc.HandleFile
+	return e
+}
+
+// firstOffset 第一次进度回调的长度.
+func (e *env) firstOffset(t *testing.T) uint64 {
+	t.Helper()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.progress) == 0 {
+		t.Fatal("no progress")
+	}
+	return e.progress[0].Offset
+}
+
+func testData() []byte {
+	b := make([]byte, testFileSize)
+	rand.New(rand.NewSource(1)).Read(b)
+	return b
+}
+
+func writeFile(t *testing.T, name string, b []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkFile(t *testing.T, name string, want []byte) {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("file: %s, length: %d, content mismatch", name, len(b))
+	}
+	if _, err = os.Stat(name + ".part"); !os.IsNotExist(err) {
+		t.Fatalf("file: %s, partial file left: %v", name, err)
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	e := newEnv(t)
+	data := testData()
+	writeFile(t, filepath.Join(e.cliDir, "a.bin"), data)
+	// 服务端已经保存了一半
+	half := len(data) / 2
+	writeFile(t, filepath.Join(e.srvDir, "up/a.bin.part"), data[:half])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.fc.Upload(ctx, "a.bin", "up/a.bin"); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(e.srvDir, "up/a.bin"), data)
+	if off := e.firstOffset(t); off < uint64(half) {
+		t.Fatalf("upload not resumed, first offset: %d", off)
+	}
+}
+
+func TestUploadCorrupted(t *testing.T) {
+	e := newEnv(t)
+	data := testData()
+	writeFile(t, filepath.Join(e.cliDir, "a.bin"), data)
+	// 服务端保存的数据与本地文件不一致
+	bad := append([]byte(nil), data[:len(data)/2]...)
+	bad[10] ^= 0xff
+	writeFile(t, filepath.Join(e.srvDir, "a.bin.part"), bad)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.fc.Upload(ctx, "a.bin", "a.bin"); status.CodeOf(err) != status.DataLoss {
+		t.Fatalf("upload: %v", err)
+	}
+	// 服务端已经丢弃错误的数据, 重新上传
+	if err := e.fc.Upload(ctx, "a.bin", "a.bin"); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(e.srvDir, "a.bin"), data)
+}
+
+func TestDownloadResume(t *testing.T) {
+	e := newEnv(t)
+	data := testData()
+	writeFile(t, filepath.Join(e.srvDir, "down/b.bin"), data)
+	// 本地已经保存了一半
+	half := len(data) / 2
+	writeFile(t, filepath.Join(e.cliDir, "b.bin.part"), data[:half])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.fc.Download(ctx, "down/b.bin", "b.bin"); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(e.cliDir, "b.bin"), data)
+	if off := e.firstOffset(t); off < uint64(half) {
+		t.Fatalf("download not resumed, first offset: %d", off)
+	}
+
+	// 远端不存在的文件
+	if err := e.fc.Download(ctx, "down/none.bin", "none.bin"); status.CodeOf(err) != status.NotFound {
+		t.Fatalf("download: %v", err)
+	}
+}
+
+func TestUploadRateLimit(t *testing.T) {
+	const rate = 1024 * 1024
+	for _, tc := range []struct {
+		name  string
+		files int
+		set   func(fc *ppfile.Client)
+	}{
+		{"RateLimit", 1, func(fc *ppfile.Client) { fc.RateLimit = rate }},
+		// 同一个连接的所有上传共享速率
+		{"ConnRateLimit", 2, func(fc *ppfile.Client) { fc.ConnRateLimit = rate }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newEnv(t)
+			tc.set(e.fc)
+			data := testData()
+			for i := 0; i < tc.files; i++ {
+				writeFile(t, filepath.Join(e.cliDir, fmt.Sprintf("%d.bin", i)), data)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			start := time.Now()
+			errs := make(chan error, tc.files)
+			for i := 0; i < tc.files; i++ {
+				go func(i int) {
+					errs <- e.fc.Upload(ctx, fmt.Sprintf("%d.bin", i), fmt.Sprintf("%d.bin", i))
+				}(i)
+			}
+			for i := 0; i < tc.files; i++ {
+				if err := <-errs; err != nil {
+					t.Fatal(err)
+				}
+			}
+			elapsed := time.Since(start)
+			for i := 0; i < tc.files; i++ {
+				checkFile(t, filepath.Join(e.srvDir, fmt.Sprintf("%d.bin", i)), data)
+			}
+
+			// 允许 100ms 的突发
+			total := float64(tc.files * len(data))
+			min := time.Duration((total - rate/10) / rate * float64(time.Second))
+			if elapsed < min {
+				t.Fatalf("%.0f bytes in %s, exceeds rate limit %d/s", total, elapsed, rate)
+			}
+			if elapsed > 4*min {
+				t.Fatalf("%.0f bytes in %s, too slow for rate limit %d/s", total, elapsed, rate)
+			}
+			t.Logf("%.0f bytes in %s, %.0f bytes/s", total, elapsed, total/elapsed.Seconds())
+		})
+	}
+}
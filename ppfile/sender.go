@@ -0,0 +1,271 @@
+package ppfile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pprpc/status"
+)
+
+const (
+	// DefMaxWindow 默认最大发送窗口(分片数)
+	DefMaxWindow = 64
+	// initWindow 初始发送窗口
+	initWindow = 4
+	// dupAcks 重复确认的次数, 达到后立即重传
+	dupAcks = 3
+	// rttSlack RTT 的抖动范围, 小于该值的 RTT 增加不认为是排队延迟
+	rttSlack = 5 * time.Millisecond
+
+	initRTO = time.Second
+	minRTO  = 200 * time.Millisecond
+	maxRTO  = 10 * time.Second
+)
+
+// Progress 传输进度
+type Progress struct {
+	FileID uint64
+	Name   string // 服务端的文件名
+	Upload bool   // true: 上传; false: 下载
+	Offset uint64 // 已经确认(接收)的长度
+	Size   uint64 // 文件长度, 接收方还不知道时为 0
+	RTT    time.Duration
+	Window int // 发送窗口(分片数), 接收方为 0
+}
+
+// ProgressFunc 进度回调, 不能长时间阻塞.
+type ProgressFunc func(p Progress)
+
+// inflight 已经发送未确认的分片
+type inflight struct {
+	end     uint64
+	at      time.Time
+	retrans bool // 重传的分片不采样 RTT
+}
+
+// sender 按窗口发送文件分片, 由接收方的确认(AckReq)推进窗口;
+// 窗口大小根据 RTT 调整: 慢启动阶段每个确认加 1, RTT 接近最小 RTT 时每个 RTT 加 1,
+// RTT 明显增加(排队)时每个 RTT 减 1, 超时或者重复确认时减半并从确认的位置重新发送.
+type sender struct {
+	w        io.Writer
+	connType string
+	dir      uint8
+	id       uint64
+	f        File
+	chunk    int
+	encType  uint8
+	limiters []*Limiter
+	closed   <-chan struct{} // 连接断开
+	progress ProgressFunc
+	info     Progress
+
+	maxWindow float64
+	window    float64
+	slowStart bool
+	srtt      time.Duration
+	rttvar    time.Duration
+	minRTT    time.Duration
+	rto       time.Duration
+
+	mu     sync.Mutex
+	acked  uint64
+	dups   int
+	notify chan struct{}
+}
+
+func newSender(w io.Writer, connType string, dir uint8, id uint64, f File, chunk uint32, maxWindow int) *sender {
+	if maxWindow <= 0 {
+		maxWindow = DefMaxWindow
+	}
+	s := &sender{
+		w:         w,
+		connType:  connType,
+		dir:       dir,
+		id:        id,
+		f:         f,
+		chunk:     int(chunk),
+		maxWindow: float64(maxWindow),
+		window:    initWindow,
+		slowStart: true,
+		rto:       initRTO,
+		notify:    make(chan struct{}, 1),
+	}
+	if s.window > s.maxWindow {
+		s.window = s.maxWindow
+	}
+	return s
+}
+
+// ack 接收方的确认.
+func (s *sender) ack(offset uint64) {
+	s.mu.Lock()
+	if offset > s.acked {
+		s.acked = offset
+		s.dups = 0
+	} else if offset == s.acked {
+		s.dups++
+	}
+	s.mu.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *sender) load() (uint64, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acked, s.dups
+}
+
+// run 从 offset 开始发送, 全部确认后返回.
+func (s *sender) run(ctx context.Context, offset uint64) error {
+	size := uint64(s.f.Size())
+	buf := make([]byte, s.chunk)
+	s.mu.Lock()
+	s.acked, s.dups = offset, 0
+	s.mu.Unlock()
+
+	acked, next, highest := offset, offset, offset
+	recovery := offset // 重传结束前不处理重复确认
+	var sent []inflight
+	deadline := time.Now().Add(s.rto)
+	t := time.NewTimer(s.rto)
+	defer t.Stop()
+	for acked < size {
+		for next < size && float64(len(sent)) < s.window {
+			n, err := s.f.ReadAt(buf, int64(next))
+			if n == 0 {
+				return fmt.Errorf("offset: %d, read: %v", next, err)
+			}
+			for _, l := range s.limiters {
+				if err = l.Wait(ctx, n); err != nil {
+					return err
+				}
+			}
+			fp := newChunk(s.connType, s.dir, s.id, next, buf[:n], s.encType)
+			if _, err = fp.Write(s.w); err != nil {
+				return status.Errorf(status.Unavailable, "offset: %d, write: %s", next, err)
+			}
+			if len(sent) == 0 {
+				deadline = time.Now().Add(s.rto)
+			}
+			next += uint64(n)
+			sent = append(sent, inflight{end: next, at: time.Now(), retrans: next <= highest})
+			if next > highest {
+				highest = next
+			}
+		}
+
+		if !t.Stop() {
+			select {
+			case <-t.C:
+			default:
+			}
+		}
+		t.Reset(time.Until(deadline))
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err())
+		case <-s.closed:
+			return status.Error(status.Unavailable, "connection closed")
+		case <-t.C:
+			// 超时, 从确认的位置重新发送
+			s.onLoss(true)
+			next, sent, recovery = acked, nil, highest
+			deadline = time.Now().Add(s.rto)
+		case <-s.notify:
+			a, dups := s.load()
+			if a > acked {
+				i := 0
+				for ; i < len(sent) && sent[i].end <= a; i++ {
+				}
+				if i > 0 && !sent[i-1].retrans {
+					s.onRTT(time.Since(sent[i-1].at))
+				}
+				sent = sent[i:]
+				acked = a
+				if next < acked {
+					next, sent = acked, nil
+				}
+				deadline = time.Now().Add(s.rto)
+				s.report(acked, size)
+			} else if dups >= dupAcks && acked >= recovery && next > acked {
+				// 丢失分片, 立即重传
+				s.onLoss(false)
+				next, sent, recovery = acked, nil, highest
+				s.mu.Lock()
+				s.dups = 0
+				s.mu.Unlock()
+				deadline = time.Now().Add(s.rto)
+			}
+		}
+	}
+	return nil
+}
+
+// onRTT 根据 RTT 采样调整 RTO 和窗口.
+func (s *sender) onRTT(rtt time.Duration) {
+	if s.srtt == 0 {
+		s.srtt, s.rttvar = rtt, rtt/2
+	} else {
+		d := s.srtt - rtt
+		if d < 0 {
+			d = -d
+		}
+		s.rttvar = (3*s.rttvar + d) / 4
+		s.srtt = (7*s.srtt + rtt) / 8
+	}
+	s.rto = s.srtt + 4*s.rttvar
+	if s.rto < minRTO {
+		s.rto = minRTO
+	} else if s.rto > maxRTO {
+		s.rto = maxRTO
+	}
+	if s.minRTT == 0 || rtt < s.minRTT {
+		s.minRTT = rtt
+	}
+
+	switch {
+	case rtt > 2*s.minRTT+rttSlack:
+		s.slowStart = false
+		s.window -= 1 / s.window
+	case s.slowStart:
+		s.window++
+	case rtt <= s.minRTT*3/2+rttSlack:
+		s.window += 1 / s.window
+	}
+	if s.window < 1 {
+		s.window = 1
+	} else if s.window > s.maxWindow {
+		s.window = s.maxWindow
+	}
+}
+
+// onLoss 丢失分片: 窗口减半, 超时时 RTO 加倍.
+func (s *sender) onLoss(timeout bool) {
+	s.slowStart = false
+	s.window /= 2
+	if s.window < 1 {
+		s.window = 1
+	}
+	if timeout {
+		s.rto *= 2
+		if s.rto > maxRTO {
+			s.rto = maxRTO
+		}
+	}
+}
+
+func (s *sender) report(offset, size uint64) {
+	if s.progress == nil {
+		return
+	}
+	p := s.info
+	p.FileID, p.Offset, p.Size = s.id, offset, size
+	p.RTT, p.Window = s.srtt, int(s.window)
+	s.progress(p)
+}
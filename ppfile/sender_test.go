@@ -0,0 +1,183 @@
+package ppfile
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pprpc/packets"
+)
+
+const testChunk = 1024
+
+// memFile 内存中的文件
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error { return nil }
+
+// delivery 延迟送达的分片
+type delivery struct {
+	offset uint64
+	n      int
+	due    time.Time
+}
+
+// testPeer 模拟网络和接收方: 解码发送的分片, 按 drop 丢弃, 按 delay 延迟后按顺序送达;
+// 接收方只保存连续的分片, 每个送达的分片都确认已保存的长度.
+type testPeer struct {
+	s     *sender
+	drop  func(offset uint64, n int) bool // n: 该位置第几次发送(从 1 开始)
+	delay func(offset uint64) time.Duration
+	queue chan delivery
+
+	mu     sync.Mutex
+	writes map[uint64][]time.Time // 每个位置的发送时间
+	saved  uint64
+}
+
+// newTestPeer 创建发送 chunks 个分片的 sender.
+func newTestPeer(chunks, maxWindow int) *testPeer {
+	p := &testPeer{queue: make(chan delivery, 1024), writes: make(map[uint64][]time.Time)}
+	f := memFile{bytes.NewReader(make([]byte, chunks*testChunk))}
+	p.s = newSender(p, "T", packets.FILEC2S, 1, f, testChunk, maxWindow)
+	return p
+}
+
+func (p *testPeer) Write(b []byte) (int, error) {
+	pp, err := packets.ReadTCPPacket(bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	fp := pp.(*packets.FilePacket)
+	data, err := chunkData(fp, packets.FILEC2S)
+	if err != nil {
+		return 0, err
+	}
+	p.mu.Lock()
+	p.writes[fp.Offset] = append(p.writes[fp.Offset], time.Now())
+	n := len(p.writes[fp.Offset])
+	p.mu.Unlock()
+	if p.drop != nil && p.drop(fp.Offset, n) {
+		return len(b), nil
+	}
+	var d time.Duration
+	if p.delay != nil {
+		d = p.delay(fp.Offset)
+	}
+	p.queue <- delivery{offset: fp.Offset, n: len(data), due: time.Now().Add(d)}
+	return len(b), nil
+}
+
+// serve 按顺序送达分片并确认, 直到 ctx 结束.
+func (p *testPeer) serve(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-p.queue:
+			if w := time.Until(d.due); w > 0 {
+				time.Sleep(w)
+			}
+			p.mu.Lock()
+			if d.offset == p.saved {
+				p.saved += uint64(d.n)
+			}
+			saved := p.saved
+			p.mu.Unlock()
+			p.s.ack(saved)
+		}
+	}
+}
+
+func (p *testPeer) sent(offset uint64) []time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.writes[offset]
+}
+
+// run 发送全部分片.
+func (p *testPeer) run(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.serve(ctx)
+	if err := p.s.run(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSenderWindow(t *testing.T) {
+	const chunks = 128
+	p := newTestPeer(chunks, 32)
+	// 前一半 RTT 10ms, 之后 100ms(排队)
+	p.delay = func(offset uint64) time.Duration {
+		if offset < chunks/2*testChunk {
+			return 10 * time.Millisecond
+		}
+		return 100 * time.Millisecond
+	}
+	var windows []int
+	p.s.progress = func(pr Progress) { windows = append(windows, pr.Window) }
+	p.run(t)
+
+	peak := 0
+	for _, w := range windows {
+		if w > peak {
+			peak = w
+		}
+	}
+	// 慢启动: 每个确认加 1
+	if peak <= initWindow*2 {
+		t.Fatalf("window not grown, peak: %d, windows: %v", peak, windows)
+	}
+	// RTT 增加后窗口减小
+	if last := windows[len(windows)-1]; last >= peak || p.s.slowStart {
+		t.Fatalf("window not shrunk, peak: %d, last: %d, slowStart: %t", peak, last, p.s.slowStart)
+	}
+	if len(p.sent(0)) != 1 {
+		t.Fatalf("retransmit without loss: %d", len(p.sent(0)))
+	}
+}
+
+func TestSenderFastRetransmit(t *testing.T) {
+	const lost = 10 * testChunk
+	p := newTestPeer(64, 16)
+	p.drop = func(offset uint64, n int) bool { return offset == lost && n == 1 }
+	p.delay = func(uint64) time.Duration { return time.Millisecond }
+	p.run(t)
+
+	if n := len(p.sent(lost)); n != 2 {
+		t.Fatalf("offset: %d, sent %d times", lost, n)
+	}
+	// 重复确认触发重传, 没有等待超时(超时时 RTO 加倍)
+	if p.s.rto >= 2*minRTO {
+		t.Fatalf("rto: %s, retransmit by timeout", p.s.rto)
+	}
+	if p.s.slowStart {
+		t.Fatal("slow start after loss")
+	}
+}
+
+func TestSenderRTOBackoff(t *testing.T) {
+	const rto = 20 * time.Millisecond
+	// 只有一个分片, 没有重复确认, 前 3 次发送丢失
+	p := newTestPeer(1, 16)
+	p.drop = func(offset uint64, n int) bool { return n <= 3 }
+	p.s.rto = rto
+	p.run(t)
+
+	at := p.sent(0)
+	if len(at) != 4 {
+		t.Fatalf("offset 0 sent %d times", len(at))
+	}
+	// 每次超时后 RTO 加倍
+	for i := 1; i < len(at); i++ {
+		if d, want := at[i].Sub(at[i-1]), rto<<(i-1); d < want {
+			t.Fatalf("retransmit %d after %s, want >= %s", i, d, want)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package ppfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"sync/atomic"
+
+	pprpc "github.com/pprpc/core"
+	"github.com/pprpc/packets"
+	"github.com/pprpc/status"
+	"github.com/pprpc/util/logs"
+)
+
+// Server 文件传输服务端.
+type Server struct {
+	st            Storage
+	ChunkSize     uint32       // 分片大小上限, 0 使用 DefChunkSize
+	EncryptType   uint8        // 下载数据的加密类型, 默认 AESNONE
+	MaxWindow     int          // 下载的最大发送窗口(分片数), 0 使用 DefMaxWindow
+	RateLimit     int64        // 每个下载的速率上限(字节/秒), 0 不限制
+	ConnRateLimit int64        // 每个连接所有下载的速率上限(字节/秒), 0 不限制
+	Progress      ProgressFunc // 上传(接收)和下载(确认)的进度
+
+	fileID     uint64
+	mu         sync.Mutex
+	uploads    map[uint64]*upload         // FileID(服务端分配)
+	names      map[string]uint64          // 正在上传的文件(nameKey)
+	downloads  map[dlKey]*download        // 连接 + FileID(客户端分配)
+	connLimits map[pprpc.RPCConn]*Limiter // 连接的速率限制
+}
+
+// upload 上传会话
+type upload struct {
+	id     uint64
+	name   string
+	key    string // nameKey(name)
+	conn   pprpc.RPCConn
+	size   uint64
+	sha256 []byte
+	done   chan struct{}
+
+	mu   sync.Mutex
+	pf   PartialFile
+	last error // 最后一个分片的错误
+}
+
+type dlKey struct {
+	conn   pprpc.RPCConn
+	fileID uint64
+}
+
+// download 下载会话
+type download struct {
+	s      *sender
+	cancel context.CancelFunc
+}
+
+// NewServer 创建服务端, 文件保存在 st.
+func NewServer(st Storage) *Server {
+	return &Server{
+		st:         st,
+		uploads:    make(map[uint64]*upload),
+		names:      make(map[string]uint64),
+		downloads:  make(map[dlKey]*download),
+		connLimits: make(map[pprpc.RPCConn]*Limiter),
+	}
+}
+
+// Register 在 s 注册文件传输命令.
+func (fs *Server) Register(s *pprpc.Service) {
+	RegisterFile(s, fileServer{fs})
+	RegisterChunk(s, fileServer{fs})
+}
+
+// fileServer 实现生成的 FileServer 和 ChunkServer, Server 不导出这些方法.
+type fileServer struct {
+	fs *Server
+}
+
+func (x fileServer) UploadOpen(conn pprpc.RPCConn, pkg *packets.CmdPacket, req *UploadOpenReq) (*UploadOpenResp, error) {
+	return x.fs.uploadOpen(conn, req)
+}
+
+func (x fileServer) UploadCommit(conn pprpc.RPCConn, pkg *packets.CmdPacket, req *CommitReq) (*CommitResp, error) {
+	return x.fs.uploadCommit(conn, req)
+}
+
+func (x fileServer) DownloadOpen(conn pprpc.RPCConn, pkg *packets.CmdPacket, req *DownloadOpenReq) (*DownloadOpenResp, error) {
+	return x.fs.downloadOpen(conn, req)
+}
+
+func (x fileServer) Close(conn pprpc.RPCConn, pkg *packets.CmdPacket, req *CloseReq) (*CloseResp, error) {
+	return x.fs.close(conn, req)
+}
+
+func (x fileServer) Ack(conn pprpc.RPCConn, pkg *packets.CmdPacket, req *AckReq) error {
+	return x.fs.onAck(conn, req)
+}
+
+func (fs *Server) chunkSize(n uint32) uint32 {
+	n = chunkSize(n)
+	if fs.ChunkSize > 0 && n > fs.ChunkSize {
+		n = fs.ChunkSize
+	}
+	return n
+}
+
+func (fs *Server) uploadOpen(conn pprpc.RPCConn, req *UploadOpenReq) (*UploadOpenResp, error) {
+	if len(req.Sha256) != 32 {
+		return nil, status.Errorf(status.InvalidArgument, "sha256 length: %d", len(req.Sha256))
+	}
+
+	key, err := fs.nameKey(req.Name)
+	if err != nil {
+		return nil, status.Errorf(status.InvalidArgument, "%s", err)
+	}
+	fs.mu.Lock()
+	if _, ok := fs.names[key]; ok {
+		fs.mu.Unlock()
+		return nil, status.Errorf(status.Aborted, "file: %s, upload in progress", req.Name)
+	}
+	fs.names[key] = 0
+	fs.mu.Unlock()
+
+	pf, err := fs.st.OpenPartial(req.Name)
+	if err != nil {
+		fs.mu.Lock()
+		delete(fs.names, key)
+		fs.mu.Unlock()
+		return nil, status.Errorf(status.InvalidArgument, "file: %s, open: %s", req.Name, err)
+	}
+	if uint64(pf.Size()) > req.Size {
+		// 已经保存的数据比文件长, 不是同一个文件
+		if err = pf.Truncate(0); err != nil {
+			pf.Close()
+			fs.mu.Lock()
+			delete(fs.names, key)
+			fs.mu.Unlock()
+			return nil, status.Errorf(status.Internal, "file: %s, truncate: %s", req.Name, err)
+		}
+	}
+
+	u := &upload{
+		id:     atomic.AddUint64(&fs.fileID, 1),
+		name:   req.Name,
+		key:    key,
+		conn:   conn,
+		size:   req.Size,
+		sha256: req.Sha256,
+		done:   make(chan struct{}),
+		pf:     pf,
+	}
+	fs.mu.Lock()
+	fs.uploads[u.id] = u
+	fs.names[u.key] = u.id
+	fs.mu.Unlock()
+	go func() {
+		// 连接断开时关闭会话, 保留已经保存的数据
+		select {
+		case <-conn.HandleClose().Done():
+			fs.endUpload(u, false)
+		case <-u.done:
+		}
+	}()
+
+	logs.Logger.Debugf("%s, upload: %s, FileID: %d, offset: %d/%d.", conn, u.name, u.id, pf.Size(), u.size)
+	return &UploadOpenResp{FileId: u.id, Offset: uint64(pf.Size()), ChunkSize: fs.chunkSize(req.ChunkSize)}, nil
+}
+
+// nameKey 文件在存储中的标识, 同一个文件的不同写法("a/../b", "/b")相同.
+func (fs *Server) nameKey(name string) (string, error) {
+	if ls, ok := fs.st.(*LocalStorage); ok {
+		return ls.path(name)
+	}
+	return path.Clean("/" + name), nil
+}
+
+// endUpload 关闭上传会话, commit 为 true 时完成文件.
+func (fs *Server) endUpload(u *upload, commit bool) (err error) {
+	fs.mu.Lock()
+	if fs.uploads[u.id] != u {
+		fs.mu.Unlock()
+		return status.Errorf(status.NotFound, "FileID: %d, not found", u.id)
+	}
+	delete(fs.uploads, u.id)
+	delete(fs.names, u.key)
+	fs.mu.Unlock()
+	close(u.done)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if commit {
+		err = u.pf.Commit()
+	} else {
+		err = u.pf.Close()
+	}
+	return
+}
+
+func (fs *Server) getUpload(conn pprpc.RPCConn, id uint64) *upload {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	u := fs.uploads[id]
+	if u == nil || u.conn != conn {
+		return nil
+	}
+	return u
+}
+
+func (fs *Server) uploadCommit(conn pprpc.RPCConn, req *CommitReq) (*CommitResp, error) {
+	u := fs.getUpload(conn, req.FileId)
+	if u == nil {
+		return nil, status.Errorf(status.NotFound, "FileID: %d, not found", req.FileId)
+	}
+
+	u.mu.Lock()
+	size := uint64(u.pf.Size())
+	if size < u.size {
+		// 数据不完整, 从已经保存的长度继续发送
+		if u.last != nil {
+			logs.Logger.Warnf("%s, upload: %s, FileID: %d, offset: %d/%d, %s.", conn, u.name, u.id, size, u.size, u.last)
+			u.last = nil
+		}
+		u.mu.Unlock()
+		return &CommitResp{Offset: size}, nil
+	}
+	sum, err := fileSHA256(u.pf, u.pf.Size())
+	if err == nil && !bytes.Equal(sum, u.sha256) {
+		err = u.pf.Truncate(0)
+		u.mu.Unlock()
+		fs.endUpload(u, false)
+		if err != nil {
+			return nil, status.Errorf(status.Internal, "file: %s, truncate: %s", u.name, err)
+		}
+		return nil, status.Errorf(status.DataLoss, "file: %s, sha256 mismatch", u.name)
+	}
+	u.mu.Unlock()
+	if err != nil {
+		fs.endUpload(u, false)
+		return nil, status.Errorf(status.Internal, "file: %s, sha256: %s", u.name, err)
+	}
+	if err = fs.endUpload(u, true); err != nil {
+		return nil, status.Errorf(status.Internal, "file: %s, commit: %s", u.name, err)
+	}
+	logs.Logger.Debugf("%s, upload: %s, FileID: %d, size: %d, complete.", conn, u.name, u.id, size)
+	return &CommitResp{Offset: size}, nil
+}
+
+func (fs *Server) downloadOpen(conn pprpc.RPCConn, req *DownloadOpenReq) (*DownloadOpenResp, error) {
+	f, err := fs.st.Open(req.Name)
+	if err != nil {
+		if isNotExist(err) {
+			return nil, status.Errorf(status.NotFound, "file: %s, not found", req.Name)
+		}
+		return nil, status.Errorf(status.InvalidArgument, "file: %s, open: %s", req.Name, err)
+	}
+	if req.Offset > uint64(f.Size()) {
+		f.Close()
+		return nil, status.Errorf(status.OutOfRange, "file: %s, offset: %d > size: %d", req.Name, req.Offset, f.Size())
+	}
+	sum, err := fileSHA256(f, f.Size())
+	if err != nil {
+		f.Close()
+		return nil, status.Errorf(status.Internal, "file: %s, sha256: %s", req.Name, err)
+	}
+
+	cs := fs.chunkSize(req.ChunkSize)
+	k := dlKey{conn, req.FileId}
+	d := &download{s: newSender(conn, conn.Type(), packets.FILES2C, req.FileId, f, cs, fs.MaxWindow)}
+	d.s.encType = fs.EncryptType
+	d.s.limiters = []*Limiter{NewLimiter(fs.RateLimit), fs.connLimiter(conn)}
+	d.s.closed = conn.HandleClose().Done()
+	d.s.progress = fs.Progress
+	d.s.info = Progress{Name: req.Name}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	fs.mu.Lock()
+	if old, ok := fs.downloads[k]; ok {
+		old.cancel()
+	}
+	fs.downloads[k] = d
+	fs.mu.Unlock()
+
+	go fs.sendFile(ctx, conn, k, d, f, req.Offset)
+	return &DownloadOpenResp{Size: uint64(f.Size()), Sha256: sum, ChunkSize: cs}, nil
+}
+
+// connLimiter 连接的速率限制, 连接断开时删除.
+func (fs *Server) connLimiter(conn pprpc.RPCConn) *Limiter {
+	if fs.ConnRateLimit <= 0 {
+		return nil
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	l, ok := fs.connLimits[conn]
+	if !ok {
+		l = NewLimiter(fs.ConnRateLimit)
+		fs.connLimits[conn] = l
+		go func() {
+			<-conn.HandleClose().Done()
+			fs.mu.Lock()
+			delete(fs.connLimits, conn)
+			fs.mu.Unlock()
+		}()
+	}
+	return l
+}
+
+// sendFile 从 offset 开始按窗口发送文件, 全部确认后发送结束分片.
+func (fs *Server) sendFile(ctx context.Context, conn pprpc.RPCConn, k dlKey, d *download, f File, offset uint64) {
+	defer func() {
+		d.cancel()
+		f.Close()
+		fs.mu.Lock()
+		if fs.downloads[k] == d {
+			delete(fs.downloads, k)
+		}
+		fs.mu.Unlock()
+	}()
+
+	if err := d.s.run(ctx, offset); err != nil {
+		logs.Logger.Debugf("%s, download FileID: %d, offset: %d, %s.", conn, k.fileID, offset, err)
+		return
+	}
+	fp := newChunk(conn.Type(), packets.FILES2C, k.fileID, uint64(f.Size()), nil, fs.EncryptType)
+	if _, err := fp.Write(conn); err != nil {
+		logs.Logger.Debugf("%s, download FileID: %d, write: %s.", conn, k.fileID, err)
+	}
+}
+
+func (fs *Server) close(conn pprpc.RPCConn, req *CloseReq) (*CloseResp, error) {
+	fs.mu.Lock()
+	d, ok := fs.downloads[dlKey{conn, req.FileId}]
+	fs.mu.Unlock()
+	if ok {
+		d.cancel()
+	}
+	if u := fs.getUpload(conn, req.FileId); u != nil {
+		fs.endUpload(u, false)
+	}
+	return &CloseResp{}, nil
+}
+
+// onAck 客户端确认下载的数据.
+func (fs *Server) onAck(conn pprpc.RPCConn, req *AckReq) error {
+	fs.mu.Lock()
+	d, ok := fs.downloads[dlKey{conn, req.FileId}]
+	fs.mu.Unlock()
+	if ok {
+		d.s.ack(req.Offset)
+	}
+	return nil
+}
+
+// HandleFile 处理上传的分片并确认已经保存的长度, 设置为 RPCTCPServer/RPCUDPServer 的 FileCB.
+func (fs *Server) HandleFile(fp *packets.FilePacket, conn pprpc.RPCConn) error {
+	u := fs.getUpload(conn, fp.FileID)
+	if u == nil {
+		return fmt.Errorf("FileID: %d, upload session not found", fp.FileID)
+	}
+	u.mu.Lock()
+	wrote, err := u.write(fp)
+	if err != nil {
+		u.last = err
+	}
+	size := uint64(u.pf.Size())
+	u.mu.Unlock()
+
+	if e := pprpc.InvokeAsync(conn, CmdIDChunkAck, &AckReq{FileId: u.id, Offset: size}, packets.TYPEPBBIN, packets.AESNONE); e != nil && err == nil {
+		err = e
+	}
+	if wrote && fs.Progress != nil {
+		fs.Progress(Progress{FileID: u.id, Name: u.name, Upload: true, Offset: size, Size: u.size})
+	}
+	return err
+}
+
+// write 保存与已保存长度连续的分片, 其他分片(重传, 丢包后的分片)丢弃.
+func (u *upload) write(fp *packets.FilePacket) (bool, error) {
+	size := uint64(u.pf.Size())
+	if fp.Offset != size {
+		return false, nil
+	}
+	data, err := chunkData(fp, packets.FILEC2S)
+	if err != nil {
+		return false, err
+	}
+	if size+uint64(len(data)) > u.size {
+		return false, fmt.Errorf("FileID: %d, offset: %d, length: %d, exceeds size: %d", fp.FileID, fp.Offset, len(data), u.size)
+	}
+	_, err = u.pf.Write(data)
+	return err == nil, err
+}
@@ -0,0 +1,159 @@
+package ppfile
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage 文件存储后端. 未完成的文件(PartialFile)与完成的文件分开保存,
+// 断点续传时重新 OpenPartial 获取已经保存的长度.
+type Storage interface {
+	// Open 打开已经完成的文件(上传的源文件, 下载的文件), 不存在时返回 os.ErrNotExist.
+	Open(name string) (File, error)
+	// OpenPartial 打开未完成的文件, 不存在时创建.
+	OpenPartial(name string) (PartialFile, error)
+}
+
+// File 已经完成的文件
+type File interface {
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// PartialFile 未完成的文件, 数据只能追加; Close 保留已经写入的数据用于续传.
+type PartialFile interface {
+	File
+	// Write 在文件末尾追加数据
+	Write(p []byte) (int, error)
+	// Truncate 截断(数据校验失败时重新开始)
+	Truncate(size int64) error
+	// Commit 完成写入, 之后可以通过 Open 打开; 同时关闭文件.
+	Commit() error
+}
+
+// partialSuffix 本地未完成文件的后缀
+const partialSuffix = ".part"
+
+// LocalStorage 本地文件系统存储, 文件保存在 Dir 目录下.
+type LocalStorage struct {
+	Dir string
+}
+
+// NewLocalStorage 创建本地存储, dir 不存在时创建.
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{Dir: dir}, nil
+}
+
+// path 文件名转换为 Dir 下的路径, 不允许访问 Dir 之外的文件.
+func (ls *LocalStorage) path(name string) (string, error) {
+	clean := filepath.Clean("/" + filepath.FromSlash(name))
+	if clean == string(filepath.Separator) || strings.HasSuffix(clean, partialSuffix) {
+		return "", fmt.Errorf("file name: %q, invalid", name)
+	}
+	return filepath.Join(ls.Dir, clean), nil
+}
+
+// Open 实现 Storage.
+func (ls *LocalStorage) Open(name string) (File, error) {
+	p, err := ls.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("file name: %q, is a directory", name)
+	}
+	return &localFile{File: f, size: fi.Size()}, nil
+}
+
+// OpenPartial 实现 Storage.
+func (ls *LocalStorage) OpenPartial(name string) (PartialFile, error) {
+	p, err := ls.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p+partialSuffix, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &localPartial{localFile: localFile{File: f, size: fi.Size()}, path: p}, nil
+}
+
+type localFile struct {
+	*os.File
+	size int64
+}
+
+func (f *localFile) Size() int64 {
+	return f.size
+}
+
+type localPartial struct {
+	localFile
+	path string
+}
+
+func (f *localPartial) Write(p []byte) (int, error) {
+	n, err := f.File.WriteAt(p, f.size)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *localPartial) Truncate(size int64) error {
+	if err := f.File.Truncate(size); err != nil {
+		return err
+	}
+	f.size = size
+	return nil
+}
+
+func (f *localPartial) Commit() error {
+	if err := f.File.Sync(); err != nil {
+		f.File.Close()
+		return err
+	}
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.path+partialSuffix, f.path)
+}
+
+// fileSHA256 计算文件前 size 字节的 SHA-256.
+func fileSHA256(f io.ReaderAt, size int64) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, size)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// isNotExist Storage.Open 返回的文件不存在错误
+func isNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
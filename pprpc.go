@@ -1,17 +1,23 @@
 package pprpc
 
 import (
+	"context"
 	"fmt"
+	"time"
 
-	"github.com/golang/protobuf/proto"
+	"github.com/pprpc/metrics"
 	"github.com/pprpc/packets"
 	"github.com/pprpc/ppudp"
+	"github.com/pprpc/sess"
+	"github.com/pprpc/status"
+	"github.com/pprpc/util/logs"
 )
 
 type pkgCallBack func(packets.PPPacket, RPCConn) error
 type hbCallBack func(*packets.HBPacket, RPCConn) error
 type cmdCallBack func(*packets.CmdPacket, RPCConn) error
 type avCallBack func(*packets.AVPacket, RPCConn) error
+type fileCallBack func(*packets.FilePacket, RPCConn) error
 type customerCallBack func(*packets.CustomerPacket, RPCConn) error
 type attrDefine func() interface{}
 
@@ -25,31 +31,77 @@ type tcpCliCallBack func(RPCCliConn)
 //type udpCliCallBack func(*UDPCliConn)
 type udpCliCallBack func(RPCCliConn)
 
+// inOrder 需要在读取协程中按顺序处理的报文(文件数据).
+func inOrder(pkg packets.PPPacket) bool {
+	_, ok := pkg.(*packets.FilePacket)
+	return ok
+}
+
 // WriteResp 写入RESP
 func WriteResp(c RPCConn, pkg *packets.CmdPacket, resp interface{}) (n int64, err error) {
 	var b []byte
 	if resp != nil && pkg.Code == 0 {
-		if pkg.MessageType == packets.TYPEPBBIN {
-			b, err = proto.Marshal(resp.(proto.Message))
-		} else if pkg.MessageType == packets.TYPEPBJSON {
-			b, err = proto.MarshalMessageSetJSON(resp)
-		} else {
-			err = fmt.Errorf("not support MessageType: %d", pkg.MessageType)
-		}
-		if err != nil {
+		if b, err = encodePayload(pkg.MessageType, resp); err != nil {
 			return
 		}
 	}
 	pkg.Payload = b
 	pkg.RPCType = packets.RPCRESP
+	pkg.Metadata = trailerMD(pkg)
+	markResponded(pkg)
 	n, err = pkg.Write(c)
 
 	return
 }
 
+// writeGoAway 通知对端服务即将关闭(RPCCTRL CTRLGOAWAY).
+func writeGoAway(c RPCConn) (err error) {
+	cmd := packets.NewCmdPacket(packets.TYPEPBBIN)
+	switch c.(type) {
+	case *ppudp.Connection:
+		cmd.FixHeader.SetProtocol(packets.PROTOUDP)
+	}
+	cmd.EncType = packets.AESNONE
+	cmd.RPCType = packets.RPCCTRL
+	cmd.Code = packets.CTRLGOAWAY
+	_, err = cmd.Write(c)
+	return
+}
+
+// goAwayAll 异步通知所有连接服务即将关闭; 对端不读取时写入阻塞, 直到关闭连接.
+func goAwayAll(conns *sess.Sessions) {
+	conns.Range(func(k, v interface{}) bool {
+		go func(k interface{}, c RPCConn) {
+			if e := writeGoAway(c); e != nil {
+				logs.Logger.Debugf("%s, writeGoAway, error: %s.", k, e)
+			}
+		}(k, v.(RPCConn))
+		return true
+	})
+}
+
+func isGoAway(cmd *packets.CmdPacket) bool {
+	return cmd.RPCType == packets.RPCCTRL && cmd.Code == packets.CTRLGOAWAY
+}
+
 // InvokeAsync 执行远程调用(异步).
 func InvokeAsync(c RPCConn, cmdid uint64, req interface{}, mt, crypt uint8) (err error) {
-	seq := GetSeqID()
+	return InvokeAsyncContext(context.Background(), c, cmdid, req, mt, crypt)
+}
+
+// InvokeAsyncContext 执行远程调用(异步), ctx 的跟踪上下文和元数据随请求发送;
+// 在处理函数中使用 CallContext(pkg) 作为 ctx 可以关联到当前请求的跟踪.
+func InvokeAsyncContext(ctx context.Context, c RPCConn, cmdid uint64, req interface{}, mt, crypt uint8) (err error) {
+	var seq uint64
+	var s *Service
+	if ct := getCallTable(c); ct != nil {
+		if seq, err = ct.seqs.Next(crypt); err != nil {
+			return
+		}
+		s = ct.Service
+	} else {
+		seq = GetSeqID()
+	}
 	cmd := packets.NewCmdPacket(mt)
 
 	switch c.(type) {
@@ -61,12 +113,13 @@ func InvokeAsync(c RPCConn, cmdid uint64, req interface{}, mt, crypt uint8) (err
 	cmd.CmdID = cmdid
 	cmd.EncType = crypt
 	cmd.RPCType = packets.RPCREQ
+	cmd.Metadata = outgoingMD(ctx)
+	sp := s.startSendSpan(ctx, c, cmd)
+	defer func() {
+		endSendSpan(sp, err)
+	}()
 
-	if mt == packets.TYPEPBBIN {
-		cmd.Payload, err = proto.Marshal(req.(proto.Message))
-	} else if mt == packets.TYPEPBJSON {
-		cmd.Payload, err = proto.MarshalMessageSetJSON(req)
-	}
+	cmd.Payload, err = encodePayload(mt, req)
 	if err != nil {
 		return
 	}
@@ -78,10 +131,71 @@ func InvokeAsync(c RPCConn, cmdid uint64, req interface{}, mt, crypt uint8) (err
 	return
 }
 
-// // Invoke 执行远程调用(同步)[FIXME].
-// func Invoke(c RPCConn, cmdid uint64, req interface{}, mt, crypt uint8) (pkg *packets.CmdPacket, resp interface{}, err error) {
-// 	return
-// }
+// Invoke 执行远程调用(同步).
+// c 必须是 RPCTCPServer/RPCUDPServer 接入的连接, 应答由服务注册的 RespHandler 解码.
+// 错误应答(Code != 0)返回 *status.Status.
+func Invoke(ctx context.Context, c RPCConn, cmdid uint64, req interface{}, mt, crypt uint8) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	ct := getCallTable(c)
+	if ct == nil {
+		err = fmt.Errorf("%s, not bind Service, not Invoke", c)
+		return
+	}
+	defer func(start time.Time) {
+		ct.observeCall(metrics.SideClient, cmdid, start, uint64(status.CodeOf(err)))
+	}(time.Now())
+
+	cmd := packets.NewCmdPacket(mt)
+
+	protocol := packets.PROTOTCP
+	switch c.(type) {
+	case *ppudp.Connection:
+		protocol = packets.PROTOUDP
+		cmd.FixHeader.SetProtocol(protocol)
+	}
+
+	cmd.CmdID = cmdid
+	cmd.EncType = crypt
+	cmd.RPCType = packets.RPCREQ
+	cmd.Timeout = callTimeout(ctx)
+	cmd.Metadata = outgoingMD(ctx)
+	sp := ct.startClientSpan(ctx, c, cmd)
+	defer func() {
+		endSpan(sp, uint64(status.CodeOf(err)), err)
+	}()
+
+	cmd.Payload, err = encodePayload(mt, req)
+	if err != nil {
+		return
+	}
+
+	// 构造返回请求.
+	ansQueue := make(chan *packets.CmdPacket, 2)
+	seq, err := ct.seqs.Acquire(ansQueue, cmd.EncType)
+	if err != nil {
+		return
+	}
+	defer ct.seqs.Release(seq)
+	cmd.CmdSeq = seq
+
+	_, err = cmd.Write(c)
+	if err != nil {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+		writeCancel(c, cmd, protocol)
+		err = status.Newf(status.FromContextError(ctx.Err()).Code, "seq: %d, cmdid: %d, ctx.Done(), %s", seq, cmdid, ctx.Err())
+	case <-c.HandleClose().Done():
+		err = status.Errorf(status.Unavailable, "%s, seq: %d, cmdid: %d, connection closed", c, seq, cmdid)
+	case pkg = <-ansQueue:
+		if err = respError(pkg); err != nil {
+			return
+		}
+		resp, err = DecodePkg(pkg, ct.Service)
+	}
+	return
+}
 
 // DecodePkg .
 func DecodePkg(pkg *packets.CmdPacket, s *Service) (obj interface{}, err error) {
@@ -92,36 +206,7 @@ func DecodePkg(pkg *packets.CmdPacket, s *Service) (obj interface{}, err error)
 	}
 	pkg.CmdName = v.CmdName
 
-	dec := func(dobj interface{}) error {
-		if pkg.Code != 0 {
-			return nil
-		}
-		if pkg.MessageType == packets.TYPEPBBIN {
-			if err := proto.Unmarshal(pkg.Payload, dobj.(proto.Message)); err != nil {
-				err = fmt.Errorf("proto.Unmarshal error: %s", err)
-				return err
-			}
-		} else if pkg.MessageType == packets.TYPEPBJSON {
-			if err := proto.UnmarshalMessageSetJSON(pkg.Payload, dobj.(proto.Message)); err != nil {
-				err = fmt.Errorf("proto.UnmarshalMessageSetJSON error: %s", err)
-				return err
-			}
-		} else {
-			err := fmt.Errorf("CmdID: %d, Name: %s, MessageType: %d Not Support",
-				pkg.CmdID, pkg.CmdName, pkg.MessageType)
-			return err
-		}
-		return nil
-	}
-
-	if pkg.RPCType == packets.RPCREQ {
-		obj, err = v.ReqHandler(v.Hanlder, nil, pkg, false, dec)
-	} else if pkg.RPCType == packets.RPCRESP {
-		obj, err = v.RespHandler(v.Hanlder, nil, pkg, false, dec)
-	} else {
-		err = fmt.Errorf("CmdId: %d, Name: %s, pkg.RPCType: %d, not support",
-			v.CmdID, v.CmdName, pkg.RPCType)
-	}
+	obj, err = s.callHandler(v, nil, pkg, false)
 	if err != nil {
 		err = fmt.Errorf("CmdId: %d, Name: %s, call Handler error: %s",
 			v.CmdID, v.CmdName, err)
@@ -52,8 +52,13 @@ func (c *ClientConn) Connect() error {
 		ct = "S"
 	case "quic":
 		ct = "Q"
+	case "ws", "wss":
+		ct = "W"
+	case "mem":
+		ct = "P"
 	}
 	c.Connection = NewConnection(conn, ct)
+	c.Connection.client = true
 	c.SetState(StateConnected)
 	return nil
 }
@@ -91,12 +96,24 @@ func openClientConn(uri *url.URL, tlsc *tls.Config, timeout time.Duration) (net.
 			return nil, err
 		}
 		return conn, nil
-		// case "quic":
-		// 	conn, err := quicconn.Dial(uri.Host, tlsc)
-		// 	if err != nil {
-		// 		return nil, err
-		// 	}
-		// 	return conn, nil
+	case "quic":
+		conn, err := dialQUIC(uri.Host, tlsc, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	case "ws", "wss":
+		conn, err := dialWS(uri, tlsc, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	case "mem":
+		conn, err := dialMem(uri.Host, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
 	}
 
 	return nil, fmt.Errorf("Unknown protocol: %s", uri.Scheme)
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/pprpc/packets"
 	"github.com/pprpc/util/common"
 )
 
@@ -18,11 +19,12 @@ type Connection struct {
 	sync.RWMutex
 	net.Conn
 
-	ct string // T=TCP； S=TLS； Q=QUIC; M = mqtt
+	ct string // T=TCP； S=TLS； Q=QUIC; W=WebSocket; P=mem(进程内); M = mqtt
 	// 传入用户自定义的结构体.
 	attr interface{}
 	// 连接状态:
-	state uint32
+	state  uint32
+	closed uint32
 	// 连接建立时间(ms)
 	//CreateTime int64
 
@@ -32,6 +34,10 @@ type Connection struct {
 	closecb CloseCallback
 	//
 	AutoCrypt bool
+	// 会话密钥(密钥协商产生)
+	sessKey []byte
+	// 是否是客户端连接(ClientConn)
+	client bool
 }
 
 // NewConnection 创建连接
@@ -64,6 +70,27 @@ func (c *Connection) SetAutoCrypt(b bool) {
 	c.AutoCrypt = b
 }
 
+// SetSessionKey 设置会话密钥, 需在收发业务报文之前调用.
+func (c *Connection) SetSessionKey(k []byte) {
+	c.sessKey = k
+}
+
+// SessionKey 会话密钥, 未协商返回nil.
+func (c *Connection) SessionKey() []byte {
+	if c == nil {
+		return nil
+	}
+	return c.sessKey
+}
+
+// SessionDir 本端发送报文的方向, 用于区分会话密钥两个方向的加密Key和nonce.
+func (c *Connection) SessionDir() uint8 {
+	if c.client {
+		return packets.FRAMEC2S
+	}
+	return packets.FRAMES2C
+}
+
 // HandleClose 监视连接断开通知
 func (c *Connection) HandleClose() context.Context {
 	return c.Ctx
@@ -172,6 +199,10 @@ func (c *Connection) LogPreShort() string {
 }
 
 func (c *Connection) String() string {
+	if ci, ok := c.Conn.(interface{ ConnID() string }); ok {
+		// QUIC: 同一会话的多个流; 地址迁移后 RemoteAddr 改变, 使用建立时的ID
+		return fmt.Sprintf("%s-%s", c.ct, ci.ConnID())
+	}
 	return fmt.Sprintf("%s-%s-%s", c.ct, common.GetPort(c.LocalAddr()), c.RemoteAddr())
 }
 
@@ -180,15 +211,16 @@ func (c *Connection) Type() string {
 	return c.ct
 }
 
-// Close 关闭连接
+// Close 关闭连接, 不等待进行中的 Write(关闭底层连接后返回错误); 重复调用无效.
 func (c *Connection) Close() (err error) {
 	if c == nil {
 		err = fmt.Errorf("not init Connection")
 		return
 	}
-	c.Lock()
-	defer c.Unlock()
 	atomic.StoreUint32(&c.state, StateDisconnected)
+	if !atomic.CompareAndSwapUint32(&c.closed, 0, 1) {
+		return
+	}
 	//logs.Logger.Debugf("%s", common.CallStack("pptcp.Connection.Close()", 2))
 	c.CtxCancel()
 	if c.Conn != nil {
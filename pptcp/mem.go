@@ -0,0 +1,94 @@
+package pptcp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 进程内连接(mem://name): 按名称注册监听, Dail 时通过 net.Pipe 建立连接, 不经过网络.
+
+var memListeners sync.Map // name -> *memListener
+
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+type memConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *memConn) LocalAddr() net.Addr  { return c.local }
+func (c *memConn) RemoteAddr() net.Addr { return c.remote }
+
+type memListener struct {
+	name  string
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+	count uint64
+}
+
+func listenMem(name string) (net.Listener, error) {
+	l := &memListener{name: name, conns: make(chan net.Conn), done: make(chan struct{})}
+	if _, loaded := memListeners.LoadOrStore(name, l); loaded {
+		return nil, fmt.Errorf("mem://%s already in use", name)
+	}
+	return l, nil
+}
+
+// dialMem 连接进程内监听, timeout 内未被 Accept 返回错误.
+func dialMem(name string, timeout time.Duration) (net.Conn, error) {
+	v, ok := memListeners.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("mem://%s connection refused", name)
+	}
+	l := v.(*memListener)
+	id := atomic.AddUint64(&l.count, 1)
+	local := memAddr(name)
+	remote := memAddr(fmt.Sprintf("%s:%d", name, id))
+	var after <-chan time.Time
+	if timeout > 0 {
+		after = time.After(timeout)
+	}
+	cli, srv := net.Pipe()
+	select {
+	case l.conns <- &memConn{Conn: srv, local: local, remote: remote}:
+		return &memConn{Conn: cli, local: remote, remote: local}, nil
+	case <-l.done:
+		err := fmt.Errorf("mem://%s connection refused", name)
+		cli.Close()
+		srv.Close()
+		return nil, err
+	case <-after:
+		cli.Close()
+		srv.Close()
+		return nil, fmt.Errorf("mem://%s dial timeout", name)
+	}
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		memListeners.Delete(l.name)
+	})
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return memAddr(l.name)
+}
@@ -0,0 +1,174 @@
+package pptcp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pprpc/util/common"
+	"github.com/quic-go/quic-go"
+)
+
+// QUICALPN QUIC 使用的 ALPN(tls.Config.NextProtos 为空时使用).
+const QUICALPN = "pprpc"
+
+// QUICConfig QUIC 传输配置; 连接 ID 与地址无关, NAT 重绑定/地址迁移后连接继续有效.
+var QUICConfig = &quic.Config{
+	KeepAlivePeriod: 15 * time.Second,
+	MaxIdleTimeout:  60 * time.Second,
+}
+
+// quicSessions QUIC 会话序号
+var quicSessions uint64
+
+// quicConn 将 QUIC 的一个双向流封装为 net.Conn, 每个流对应一个 Connection.
+type quicConn struct {
+	*quic.Stream
+	conn *quic.Conn
+	id   string
+	// 客户端拥有整个会话, 关闭流时同时关闭会话
+	owner bool
+	// 服务端: 会话中没有关闭的流的个数, 最后一个流关闭时关闭会话
+	streams *int32
+	once    sync.Once
+}
+
+func (c *quicConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *quicConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// newQUICConn sess 为会话ID(quicSessionID).
+func newQUICConn(conn *quic.Conn, sess string, stream *quic.Stream) *quicConn {
+	return &quicConn{Stream: stream, conn: conn, id: fmt.Sprintf("%s-%d", sess, stream.StreamID())}
+}
+
+// quicSessionID 会话建立时的本端端口, 对端地址和会话序号.
+func quicSessionID(conn *quic.Conn) string {
+	return fmt.Sprintf("%s-%s#%d", common.GetPort(conn.LocalAddr()), conn.RemoteAddr(), atomic.AddUint64(&quicSessions, 1))
+}
+
+// ConnID 连接ID: 会话ID + 流ID, 地址迁移后不变.
+func (c *quicConn) ConnID() string { return c.id }
+
+func (c *quicConn) Close() (err error) {
+	c.once.Do(func() {
+		c.Stream.CancelRead(0)
+		err = c.Stream.Close()
+		if c.owner || (c.streams != nil && atomic.AddInt32(c.streams, -1) == 0) {
+			err = c.conn.CloseWithError(0, "")
+		}
+	})
+	return
+}
+
+func quicTLSConfig(tlsc *tls.Config) (*tls.Config, error) {
+	if tlsc == nil {
+		return nil, errors.New("quic: tls.Config is required")
+	}
+	if len(tlsc.NextProtos) == 0 {
+		tlsc = tlsc.Clone()
+		tlsc.NextProtos = []string{QUICALPN}
+	}
+	return tlsc, nil
+}
+
+// dialQUIC 建立 QUIC 会话并打开一个流.
+func dialQUIC(addr string, tlsc *tls.Config, timeout time.Duration) (net.Conn, error) {
+	tlsc, err := quicTLSConfig(tlsc)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, addr, tlsc, QUICConfig)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+	qc := newQUICConn(conn, quicSessionID(conn), stream)
+	qc.owner = true
+	return qc, nil
+}
+
+// quicListener 接受 QUIC 会话, 会话中的每个流作为一个连接返回.
+type quicListener struct {
+	ln        *quic.Listener
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	conns     chan net.Conn
+}
+
+func listenQUIC(addr string, tlsc *tls.Config) (net.Listener, error) {
+	tlsc, err := quicTLSConfig(tlsc)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := quic.ListenAddr(addr, tlsc, QUICConfig)
+	if err != nil {
+		return nil, err
+	}
+	l := &quicListener{ln: ln, conns: make(chan net.Conn, 128)}
+	l.ctx, l.ctxCancel = context.WithCancel(context.Background())
+	go l.acceptConns()
+	return l, nil
+}
+
+func (l *quicListener) acceptConns() {
+	for {
+		conn, err := l.ln.Accept(l.ctx)
+		if err != nil {
+			return
+		}
+		go l.acceptStreams(conn)
+	}
+}
+
+func (l *quicListener) acceptStreams(conn *quic.Conn) {
+	streams := new(int32)
+	sess := quicSessionID(conn)
+	for {
+		stream, err := conn.AcceptStream(l.ctx)
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(streams, 1)
+		qc := newQUICConn(conn, sess, stream)
+		qc.streams = streams
+		select {
+		case l.conns <- qc:
+		case <-l.ctx.Done():
+			stream.CancelRead(0)
+			stream.Close()
+			if atomic.AddInt32(streams, -1) == 0 {
+				conn.CloseWithError(0, "")
+			}
+			return
+		}
+	}
+}
+
+func (l *quicListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *quicListener) Close() error {
+	l.ctxCancel()
+	return l.ln.Close()
+}
+
+func (l *quicListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
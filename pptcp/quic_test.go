@@ -0,0 +1,117 @@
+package pptcp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTLSConfig 自签名证书(127.0.0.1), 返回服务端和客户端配置.
+func testTLSConfig(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		&tls.Config{RootCAs: pool}
+}
+
+// openStream 在客户端会话中打开新的流; 对端收到数据后才能 Accept.
+func openStream(t *testing.T, c *quicConn) {
+	t.Helper()
+	stream, err := c.conn.OpenStreamSync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stream.Write([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func acceptStream(t *testing.T, ln net.Listener) *quicConn {
+	t.Helper()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1)
+	if _, err = c.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return c.(*quicConn)
+}
+
+func TestQUICSessionClose(t *testing.T) {
+	srvTLS, cliTLS := testTLSConfig(t)
+	ln, err := listenQUIC("127.0.0.1:0", srvTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	nc, err := dialQUIC(ln.Addr().String(), cliTLS, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := nc.(*quicConn)
+	defer cli.Close()
+	if _, err = cli.Write([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	s1 := acceptStream(t, ln)
+	openStream(t, cli)
+	s2 := acceptStream(t, ln)
+
+	// 同一会话的流使用不同的ID, 会话ID相同
+	c1, c2 := NewConnection(s1, "Q"), NewConnection(s2, "Q")
+	if c1.String() == c2.String() || !strings.HasPrefix(c1.String(), "Q-") {
+		t.Fatalf("String: %s, %s", c1, c2)
+	}
+	sess := func(id string) string { return id[:strings.LastIndex(id, "-")] }
+	if sess(s1.ConnID()) != sess(s2.ConnID()) {
+		t.Fatalf("ConnID: %s, %s", s1.ConnID(), s2.ConnID())
+	}
+
+	// 服务端关闭一个流, 会话继续有效
+	c1.Close()
+	select {
+	case <-cli.conn.Context().Done():
+		t.Fatal("session closed with open streams")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// 最后一个流关闭时关闭会话
+	c2.Close()
+	select {
+	case <-cli.conn.Context().Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("session not closed after last stream")
+	}
+}
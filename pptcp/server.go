@@ -42,20 +42,65 @@ func NewTLSTCPServer(addr string, config *tls.Config) (ts *TCPServer, err error)
 	return
 }
 
-// // NewQUICServer 创建QUIC服务
-// func NewQUICServer(addr string, config *tls.Config) (ts *TCPServer, err error) {
-// 	var lis net.Listener
-// 	lis, err = quicconn.Listen("udp", addr, config)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	ts = new(TCPServer)
-// 	ts.lis = lis
-// 	ts.SSL = true
-// 	ts.ct = "Q"
-// 	return
-// }
+// NewQUICServer 创建QUIC服务, 每个QUIC流对应一个连接
+func NewQUICServer(addr string, config *tls.Config) (ts *TCPServer, err error) {
+	var lis net.Listener
+	lis, err = listenQUIC(addr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	ts = new(TCPServer)
+	ts.lis = lis
+	ts.SSL = true
+	ts.ct = "Q"
+	return
+}
+
+// NewWSServer 创建WebSocket服务(ws), path 为升级请求的路径
+func NewWSServer(addr, path string) (ts *TCPServer, err error) {
+	var lis net.Listener
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ts = new(TCPServer)
+	ts.lis = listenWS(lis, path)
+	ts.SSL = false
+	ts.ct = "W"
+	return
+}
+
+// NewWSSServer 创建安全WebSocket服务(wss)
+func NewWSSServer(addr, path string, config *tls.Config) (ts *TCPServer, err error) {
+	var lis net.Listener
+	lis, err = tls.Listen("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	ts = new(TCPServer)
+	ts.lis = listenWS(lis, path)
+	ts.SSL = true
+	ts.ct = "W"
+	return
+}
+
+// NewMemServer 创建进程内服务(mem://name), 用于测试和同进程组件
+func NewMemServer(name string) (ts *TCPServer, err error) {
+	var lis net.Listener
+	lis, err = listenMem(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ts = new(TCPServer)
+	ts.lis = lis
+	ts.SSL = false
+	ts.ct = "P"
+	return
+}
 
 // Accept 允许连接接入.
 func (ts *TCPServer) Accept() (*Connection, error) {
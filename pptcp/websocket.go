@@ -0,0 +1,151 @@
+package pptcp
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsConn 将 WebSocket 连接封装为 net.Conn: 每次 Write 发送一个二进制消息,
+// Read 按字节流读取(跨消息), 报文边界由 FixHeader 确定.
+type wsConn struct {
+	*websocket.Conn
+	r  io.Reader
+	wm sync.Mutex
+}
+
+func newWSConn(c *websocket.Conn, limit int64) *wsConn {
+	c.SetReadLimit(limit)
+	return &wsConn{Conn: c}
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for {
+		if c.r == nil {
+			mt, r, err := c.Conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if mt != websocket.BinaryMessage {
+				continue
+			}
+			c.r = r
+		}
+		n, err := c.r.Read(b)
+		if err == io.EOF {
+			c.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	c.wm.Lock()
+	defer c.wm.Unlock()
+	if err := c.Conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// dialWS 建立 WebSocket 连接(ws, wss).
+func dialWS(uri *url.URL, tlsc *tls.Config, timeout time.Duration) (net.Conn, error) {
+	d := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: timeout,
+		TLSClientConfig:  tlsc,
+	}
+	c, _, err := d.Dial(uri.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return newWSConn(c, WSReadLimit), nil
+}
+
+// wsListener 在 HTTP 服务上接受 WebSocket 连接.
+type wsListener struct {
+	lis   net.Listener
+	srv   *http.Server
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+var (
+	// WSCheckOrigin 校验浏览器请求的 Origin, nil 只允许同源(没有 Origin 的非浏览器客户端不受影响);
+	// 允许所有来源需要显式设置为 WSAllowAnyOrigin. 在创建服务(监听)之前设置.
+	WSCheckOrigin func(r *http.Request) bool
+	// WSReadLimit 接收的 WebSocket 消息(一个报文)的最大长度, 超过时断开连接; 在创建服务或者连接之前设置.
+	WSReadLimit int64 = 16 << 20
+)
+
+// WSAllowAnyOrigin 允许所有来源, 仅在有其他认证方式时使用(否则任意网页都可以连接).
+func WSAllowAnyOrigin(r *http.Request) bool {
+	return true
+}
+
+func listenWS(lis net.Listener, path string) net.Listener {
+	if path == "" {
+		path = "/"
+	}
+	l := &wsListener{lis: lis, conns: make(chan net.Conn, 128), done: make(chan struct{})}
+	// CheckOrigin 为 nil 时使用 gorilla 的同源检查
+	upgrader := &websocket.Upgrader{CheckOrigin: WSCheckOrigin}
+	limit := WSReadLimit
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		select {
+		case l.conns <- newWSConn(c, limit):
+		case <-l.done:
+			c.Close()
+		}
+	})
+	l.srv = &http.Server{Handler: mux}
+	go l.srv.Serve(lis)
+	return l
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *wsListener) Close() error {
+	err := errors.New("already closed")
+	l.once.Do(func() {
+		close(l.done)
+		// 已经升级的连接不受影响
+		err = l.srv.Close()
+	})
+	return err
+}
+
+func (l *wsListener) Addr() net.Addr {
+	return l.lis.Addr()
+}
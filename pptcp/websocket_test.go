@@ -0,0 +1,135 @@
+package pptcp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsServer 创建 ws/wss 服务, 返回连接地址.
+func wsServer(t *testing.T, scheme string, tlsc *tls.Config) (*TCPServer, *url.URL) {
+	t.Helper()
+	var ts *TCPServer
+	var err error
+	if scheme == "wss" {
+		ts, err = NewWSSServer("127.0.0.1:0", "/pp", tlsc)
+	} else {
+		ts, err = NewWSServer("127.0.0.1:0", "/pp")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ts.Close() })
+	return ts, &url.URL{Scheme: scheme, Host: ts.Addr().String(), Path: "/pp"}
+}
+
+func TestWSLoopback(t *testing.T) {
+	srvTLS, cliTLS := testTLSConfig(t)
+	for _, scheme := range []string{"ws", "wss"} {
+		ts, u := wsServer(t, scheme, srvTLS)
+		cc := NewClientConn(u, cliTLS, time.Second)
+		if err := cc.Connect(); err != nil {
+			t.Fatalf("%s: %s", scheme, err)
+		}
+		sc, err := ts.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 多次 Write 的数据按字节流读取, 不受消息边界影响
+		if _, err = cc.Write([]byte("hello ")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cc.Write([]byte("websocket")); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, len("hello websocket"))
+		if _, err = io.ReadFull(sc, b); err != nil || string(b) != "hello websocket" {
+			t.Fatalf("%s: read: %q, %v", scheme, b, err)
+		}
+		if sc.Type() != "W" || cc.Type() != "W" {
+			t.Fatalf("%s: type: %s, %s", scheme, sc.Type(), cc.Type())
+		}
+
+		cc.Disconnect()
+		if _, err = sc.Read(b); err == nil {
+			t.Fatalf("%s: read after close", scheme)
+		}
+		sc.Close()
+	}
+}
+
+func TestWSCheckOrigin(t *testing.T) {
+	dial := func(u *url.URL, origin string) error {
+		h := http.Header{}
+		if origin != "" {
+			h.Set("Origin", origin)
+		}
+		c, _, err := websocket.DefaultDialer.Dial(u.String(), h)
+		if err == nil {
+			c.Close()
+		}
+		return err
+	}
+
+	// 默认只允许同源, 没有 Origin 的客户端不受影响
+	_, u := wsServer(t, "ws", nil)
+	for origin, ok := range map[string]bool{
+		"":                               true,
+		fmt.Sprintf("http://%s", u.Host): true,
+		"http://evil.example.com":        false,
+	} {
+		if err := dial(u, origin); (err == nil) != ok {
+			t.Fatalf("Origin: %q, err: %v", origin, err)
+		}
+	}
+
+	// 显式允许所有来源
+	WSCheckOrigin = WSAllowAnyOrigin
+	defer func() { WSCheckOrigin = nil }()
+	_, u = wsServer(t, "ws", nil)
+	if err := dial(u, "http://evil.example.com"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWSReadLimit(t *testing.T) {
+	defer func(n int64) { WSReadLimit = n }(WSReadLimit)
+	WSReadLimit = 1024
+
+	ts, u := wsServer(t, "ws", nil)
+	cc := NewClientConn(u, nil, time.Second)
+	if err := cc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Disconnect()
+	sc, err := ts.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	// 不超过限制的消息
+	msg := bytes.Repeat([]byte{1}, 1024)
+	if _, err = cc.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(sc, make([]byte, len(msg))); err != nil {
+		t.Fatal(err)
+	}
+
+	// 超过限制时读取失败
+	if _, err = cc.Write(append(msg, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(sc, make([]byte, len(msg)+1)); err != websocket.ErrReadLimit {
+		t.Fatalf("read: %v", err)
+	}
+}
@@ -10,6 +10,15 @@ const (
 	// StateConnected 已经建立的连接
 	StateConnected
 
-	// MAXPKGSIZE 最大报大小
+	// MAXPKGSIZE 最大报大小, 不超过该大小的报文不分片(旧版本的读缓存为该大小)
 	MAXPKGSIZE int = 1500
+	// FRAGSIZE 超过 MAXPKGSIZE 的报文按该大小分片发送(预留可靠传输报头, 小于 MTU)
+	FRAGSIZE int = 1400
+	// MAXMSGSIZE 分片发送的报文最大长度, 接收时限制分片个数
+	MAXMSGSIZE int = 4 << 20
+	// SOCKBUFSIZE socket 接收缓存大小, 避免分片突发时内核丢包(受 net.core.rmem_max 限制)
+	SOCKBUFSIZE int = 4 << 20
 )
+
+// readBufSize UDP 读缓存大小(可靠传输报头 + MAXPKGSIZE 的报文)
+const readBufSize = 65535
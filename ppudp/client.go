@@ -12,6 +12,8 @@ type ClientConn struct {
 	ctxCancel   context.CancelFunc
 	addr        string
 	readTimeout int64
+	// 连接后协商可靠传输模式
+	reliable bool
 }
 
 // NewClientConn .
@@ -30,9 +32,20 @@ func (c *ClientConn) Connect() error {
 		return err
 	}
 	c.Connection = NewConnection(c.ctx, conn, nil, c.readTimeout, nil, nil)
+	if c.reliable {
+		if err = c.Connection.EnableReliable(); err != nil {
+			c.Connection.Close()
+			return err
+		}
+	}
 	return nil
 }
 
+// SetReliable Connect 时是否协商可靠传输模式, 需在 Connect 之前调用.
+func (c *ClientConn) SetReliable(b bool) {
+	c.reliable = b
+}
+
 // Disconnect 断开连接.
 func (c *ClientConn) Disconnect() error {
 	return c.Connection.Close()
@@ -54,5 +67,9 @@ func openClientConn(addr string) (*net.UDPConn, error) {
 		return nil, err
 	}
 	conn, err := net.DialUDP("udp", nil, dstAddr)
-	return conn, err
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadBuffer(SOCKBUFSIZE)
+	return conn, nil
 }
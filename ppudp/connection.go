@@ -8,9 +8,17 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/pprpc/packets"
 	"github.com/pprpc/util/common"
 )
 
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, readBufSize)
+		return &b
+	},
+}
+
 // CloseCallback 连接端口回调定义
 type CloseCallback func(*Connection)
 
@@ -47,6 +55,15 @@ type Connection struct {
 
 	closecb   CloseCallback
 	AutoCrypt bool
+	// 会话密钥(密钥协商产生)
+	sessKey []byte
+	// 可靠传输状态(*reliable), 未协商为nil
+	rel atomic.Value
+	// 是否允许对端协商可靠传输模式(服务端)
+	allowReliable bool
+	// 分片
+	fragID uint32
+	reasm  *reassembler
 }
 
 // NewConnection 创建连接
@@ -65,6 +82,7 @@ func NewConnection(ctx context.Context, c *net.UDPConn, remoteAddr *net.UDPAddr,
 	retConn.closeChan = closeChan
 	retConn.closecb = nil
 	retConn.AutoCrypt = true
+	retConn.reasm = newReassembler()
 
 	return retConn
 }
@@ -79,6 +97,27 @@ func (c *Connection) SetCloseCB(fn CloseCallback) (err error) {
 	return
 }
 
+// SetSessionKey 设置会话密钥, 需在收发业务报文之前调用.
+func (c *Connection) SetSessionKey(k []byte) {
+	c.sessKey = k
+}
+
+// SessionKey 会话密钥, 未协商返回nil.
+func (c *Connection) SessionKey() []byte {
+	if c == nil {
+		return nil
+	}
+	return c.sessKey
+}
+
+// SessionDir 本端发送报文的方向, 用于区分会话密钥两个方向的加密Key和nonce.
+func (c *Connection) SessionDir() uint8 {
+	if c.Connected() {
+		return packets.FRAMEC2S
+	}
+	return packets.FRAMES2C
+}
+
 // HandleClose 监视连接断开通知
 func (c *Connection) HandleClose() context.Context {
 	return c.Ctx
@@ -143,6 +182,7 @@ func (c *Connection) GetState() (v uint32, err error) {
 	return
 }
 
+// Write 发送一个报文, 超过 MAXPKGSIZE 自动分片.
 func (c *Connection) Write(b []byte) (n int, err error) {
 	if c == nil {
 		return 0, fmt.Errorf("not init Connection")
@@ -150,6 +190,31 @@ func (c *Connection) Write(b []byte) (n int, err error) {
 	if c.IsClose() {
 		return 0, fmt.Errorf("use close connection")
 	}
+	unreliable := isUnreliable(b)
+	if len(b) <= MAXPKGSIZE {
+		return c.writeDatagram(b, unreliable)
+	}
+	frags, err := fragment(atomic.AddUint32(&c.fragID, 1), b)
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range frags {
+		if _, err = c.writeDatagram(f, unreliable); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (c *Connection) writeDatagram(b []byte, unreliable bool) (int, error) {
+	if r := c.reliable(); r != nil && !unreliable {
+		return r.send(b)
+	}
+	return c.rawWrite(b)
+}
+
+// rawWrite 直接发送一个UDP报文.
+func (c *Connection) rawWrite(b []byte) (n int, err error) {
 	if c.remoteAddr != nil {
 		c.sendChan <- sendPkg{b, c.remoteAddr}
 		n = len(b)
@@ -182,29 +247,136 @@ func (c *Connection) Type() string {
 }
 
 func (c *Connection) Read(b []byte) (n int, err error) {
+	data, err := c.ReadMessage()
+	if err != nil {
+		return 0, err
+	}
+	return copy(b, data), nil
+}
+
+// ReadMessage 读取一个完整的报文(分片重组后), 可以超过 MAXPKGSIZE.
+func (c *Connection) ReadMessage() ([]byte, error) {
 	if c == nil {
-		return 0, fmt.Errorf("not init Connection")
+		return nil, fmt.Errorf("not init Connection")
 	}
 	if c.IsClose() {
-		return 0, fmt.Errorf("use close connection")
+		return nil, fmt.Errorf("use close connection")
 	}
 
+	deadline := time.Now().Add(time.Duration(c.readTimeoutSec) * time.Second)
+	for {
+		data, err := c.readDatagram(deadline)
+		if err != nil {
+			return nil, err
+		}
+		if !isFragment(data) {
+			return data, nil
+		}
+		if msg, ok := c.reasm.add(data); ok {
+			return msg, nil
+		}
+	}
+}
+
+// readDatagram 读取一个(可靠模式下按序的)UDP报文.
+func (c *Connection) readDatagram(deadline time.Time) ([]byte, error) {
+	for {
+		if r := c.reliable(); r != nil {
+			if p, ok := r.pop(); ok {
+				return p, nil
+			}
+		}
+		data, err := c.rawRead(deadline)
+		if err != nil {
+			return nil, err
+		}
+		if !isRelSegment(data) {
+			return data, nil
+		}
+		c.handleRelSegment(data)
+	}
+}
+
+// rawRead 读取一个UDP报文.
+func (c *Connection) rawRead(deadline time.Time) ([]byte, error) {
 	if c.remoteAddr != nil {
 		select {
-		case <-time.After(time.Second * time.Duration(c.readTimeoutSec)):
-			err = fmt.Errorf("read timeout %d, %s", c.readTimeoutSec, c.remoteAddr)
-			return 0, err
+		case <-time.After(time.Until(deadline)):
+			return nil, fmt.Errorf("read timeout %d, %s", c.readTimeoutSec, c.remoteAddr)
 		case data := <-c.recvChan:
-			copy(b, data)
-			return len(data), nil
+			return data, nil
 		case <-c.Ctx.Done():
-			return 0, fmt.Errorf("ctx.Done()")
+			return nil, fmt.Errorf("ctx.Done()")
 		}
-	} else {
-		c.conn.SetReadDeadline(time.Now().Add(time.Duration(c.readTimeoutSec) * time.Second))
-		n, err = c.conn.Read(b)
 	}
-	return
+	bp := bufPool.Get().(*[]byte)
+	defer bufPool.Put(bp)
+	c.conn.SetReadDeadline(deadline)
+	n, err := c.conn.Read(*bp)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), (*bp)[:n]...), nil
+}
+
+// handleRelSegment 处理可靠传输报文.
+func (c *Connection) handleRelSegment(data []byte) {
+	if data[2] == relSYN {
+		if c.reliable() == nil {
+			if !c.allowReliable {
+				// 不回复, 客户端协商超时
+				return
+			}
+			c.rel.Store(newReliable(c))
+		}
+		// SYNACK 丢失后客户端会重发 SYN
+		c.rawWrite(relPack(relSYNACK, 0, 0, 0, nil))
+		return
+	}
+	if r := c.reliable(); r != nil {
+		r.input(data)
+	}
+}
+
+func (c *Connection) reliable() *reliable {
+	r, _ := c.rel.Load().(*reliable)
+	return r
+}
+
+// Reliable 是否为可靠传输模式.
+func (c *Connection) Reliable() bool {
+	return c.reliable() != nil
+}
+
+// EnableReliable 与服务端协商可靠传输模式(客户端连接), 需在收发业务报文之前调用;
+// 服务端未开启返回 ErrReliableRefused.
+func (c *Connection) EnableReliable() error {
+	if c.remoteAddr != nil {
+		return fmt.Errorf("not client connection")
+	}
+	if c.reliable() != nil {
+		return nil
+	}
+	for i := 0; i < relSynRetries; i++ {
+		_, err := c.rawWrite(relPack(relSYN, 0, 0, 0, nil))
+		if err != nil {
+			return err
+		}
+		deadline := time.Now().Add(relSynTimeout)
+		for {
+			data, err := c.rawRead(deadline)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			} else if err != nil {
+				return err
+			}
+			if isRelSegment(data) && data[2] == relSYNACK {
+				c.rel.Store(newReliable(c))
+				return nil
+			}
+		}
+	}
+	return ErrReliableRefused
 }
 
 // Close 关闭连接
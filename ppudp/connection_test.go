@@ -0,0 +1,60 @@
+package ppudp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestWriteFragment 不超过 MAXPKGSIZE 的报文整个发送(旧版本可以接收), 超过时按 FRAGSIZE 分片.
+func TestWriteFragment(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	uc, err := net.DialUDP("udp", nil, peer.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewConnection(context.Background(), uc, nil, 1, nil, nil)
+	defer c.Close()
+
+	read := func() []byte {
+		b := make([]byte, readBufSize)
+		peer.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := peer.ReadFromUDP(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b[:n]
+	}
+
+	msg := bytes.Repeat([]byte{0x42}, MAXPKGSIZE)
+	if _, err = c.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); !bytes.Equal(got, msg) {
+		t.Fatalf("datagram length: %d, want: %d", len(got), len(msg))
+	}
+
+	msg = bytes.Repeat([]byte{0x42}, MAXPKGSIZE+1)
+	if _, err = c.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	ra := newReassembler()
+	for i := 0; ; i++ {
+		f := read()
+		if !isFragment(f) || len(f) > FRAGSIZE {
+			t.Fatalf("fragment %d, length: %d", i, len(f))
+		}
+		if out, ok := ra.add(f); ok {
+			if !bytes.Equal(out, msg) {
+				t.Fatalf("reassembled length: %d", len(out))
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package ppudp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pprpc/metrics"
+)
+
+/*
+分片报文(超过 MAXPKGSIZE 的报文, 每个分片不超过 FRAGSIZE):
+
+	0x51 0x70 | 0xF0 | msgID(uint32) | index(uint16) | count(uint16) | data
+
+0xF0 不是合法的 FixHeader 首字节(报文类型 3-9), 不会与普通报文混淆.
+分片在可靠传输层之上, 可靠模式下每个分片单独确认/重传.
+*/
+
+const (
+	fragMark       byte = 0xF0
+	fragHeaderSize      = 11
+	// fragTimeout 分片重组超时时间.
+	fragTimeout = 5 * time.Second
+	// fragMemLimit 每个连接重组缓存的最大字节数(包括分片槽位).
+	fragMemLimit = 16 << 20
+	// fragMaxMsgs 每个连接同时重组的最大报文数.
+	fragMaxMsgs = 64
+	// fragSlotSize 每个分片槽位([]byte)占用的字节数.
+	fragSlotSize = 24
+	// fragChunk 每个分片的数据长度.
+	fragChunk = FRAGSIZE - fragHeaderSize
+	// fragMaxCount 报文的最大分片个数.
+	fragMaxCount = (MAXMSGSIZE + fragChunk - 1) / fragChunk
+)
+
+var fragPreHeader = []byte{0x51, 0x70, fragMark}
+
+func isFragment(b []byte) bool {
+	return len(b) >= fragHeaderSize && b[0] == fragPreHeader[0] && b[1] == fragPreHeader[1] && b[2] == fragMark
+}
+
+// fragment 将报文按 FRAGSIZE 分片.
+func fragment(msgID uint32, b []byte) ([][]byte, error) {
+	if len(b) > MAXMSGSIZE {
+		return nil, fmt.Errorf("packet length(%d) over %d", len(b), MAXMSGSIZE)
+	}
+	chunk := fragChunk
+	count := (len(b) + chunk - 1) / chunk
+	frags := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * chunk
+		if end > len(b) {
+			end = len(b)
+		}
+		f := make([]byte, fragHeaderSize, fragHeaderSize+end-i*chunk)
+		copy(f, fragPreHeader)
+		binary.BigEndian.PutUint32(f[3:], msgID)
+		binary.BigEndian.PutUint16(f[7:], uint16(i))
+		binary.BigEndian.PutUint16(f[9:], uint16(count))
+		frags = append(frags, append(f, b[i*chunk:end]...))
+	}
+	return frags, nil
+}
+
+type fragMsg struct {
+	parts   [][]byte
+	got     int
+	n       int // 数据长度
+	size    int // 占用的内存(数据和槽位)
+	created time.Time
+}
+
+// reassembler 分片重组.
+type reassembler struct {
+	sync.Mutex
+	msgs map[uint32]*fragMsg
+	size int
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{msgs: make(map[uint32]*fragMsg)}
+}
+
+// add 添加一个分片, 报文完整时返回重组后的数据.
+func (ra *reassembler) add(b []byte) ([]byte, bool) {
+	msgID := binary.BigEndian.Uint32(b[3:])
+	index := int(binary.BigEndian.Uint16(b[7:]))
+	count := int(binary.BigEndian.Uint16(b[9:]))
+	data := b[fragHeaderSize:]
+	if index >= count || count > fragMaxCount {
+		metrics.Get().IncDrop(metrics.DropFragInvalid)
+		return nil, false
+	}
+
+	ra.Lock()
+	defer ra.Unlock()
+	now := time.Now()
+	ra.expire(now)
+
+	m, ok := ra.msgs[msgID]
+	if !ok {
+		slots := count * fragSlotSize
+		if len(ra.msgs) >= fragMaxMsgs || ra.size+slots > fragMemLimit {
+			metrics.Get().IncDrop(metrics.DropFragMem)
+			return nil, false
+		}
+		m = &fragMsg{parts: make([][]byte, count), size: slots, created: now}
+		ra.msgs[msgID] = m
+		ra.size += slots
+	}
+	if len(m.parts) != count || m.parts[index] != nil {
+		// 重复分片或者 count 不一致
+		metrics.Get().IncDrop(metrics.DropFragInvalid)
+		return nil, false
+	}
+	if ra.size+len(data) > fragMemLimit {
+		ra.remove(msgID)
+		metrics.Get().IncDrop(metrics.DropFragMem)
+		return nil, false
+	}
+	m.parts[index] = append([]byte(nil), data...)
+	m.got++
+	m.n += len(data)
+	m.size += len(data)
+	ra.size += len(data)
+	if m.got < count {
+		return nil, false
+	}
+
+	out := make([]byte, 0, m.n)
+	for _, p := range m.parts {
+		out = append(out, p...)
+	}
+	ra.remove(msgID)
+	return out, true
+}
+
+// expire 删除超时未完成的报文, 调用者持有锁.
+func (ra *reassembler) expire(now time.Time) {
+	for id, m := range ra.msgs {
+		if now.Sub(m.created) > fragTimeout {
+			ra.remove(id)
+			metrics.Get().IncDrop(metrics.DropFragTimeout)
+		}
+	}
+}
+
+func (ra *reassembler) remove(msgID uint32) {
+	if m, ok := ra.msgs[msgID]; ok {
+		ra.size -= m.size
+		delete(ra.msgs, msgID)
+	}
+}
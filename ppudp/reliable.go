@@ -0,0 +1,314 @@
+package ppudp
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/pprpc/metrics"
+	"github.com/pprpc/packets"
+	"github.com/pprpc/util/logs"
+)
+
+/*
+可靠传输模式(按连接协商):
+
+	0x51 0x72 | kind(uint8) | seq(uint32) | ack(uint32) | sack(uint32) | payload
+
+kind: SYN 客户端请求可靠模式; SYNACK 服务端同意; DATA 数据; ACK 纯确认.
+ack: 期望收到的下一个序列号(之前的均已收到);
+sack: 第 i 位为1 表示 ack+1+i 已经收到.
+AV 报文(TYPEAV)始终不可靠发送, 不经过该层.
+*/
+
+const (
+	relSYN uint8 = iota + 1
+	relSYNACK
+	relDATA
+	relACK
+)
+
+const (
+	relHeaderSize = 15
+	// relWindow 最大未确认报文数, 超过后 Write 阻塞; 不超过 ack+sack 能确认的范围.
+	relWindow = 33
+	// relFastResend 后续报文被确认(sack)该次数后立即重传.
+	relFastResend = 3
+	// relMaxRetries 重传次数超过该值认为连接断开.
+	relMaxRetries = 10
+	relMinRTO     = 100 * time.Millisecond
+	relMaxRTO     = 10 * time.Second
+	relInitRTO    = 500 * time.Millisecond
+	relTick       = 20 * time.Millisecond
+	// relSynTimeout 单次 SYN 等待时间, 共尝试 relSynRetries 次.
+	relSynTimeout = 500 * time.Millisecond
+	relSynRetries = 6
+)
+
+var (
+	relPreHeader = []byte{0x51, 0x72}
+	// ErrReliableRefused 对端不支持(或未开启)可靠传输模式.
+	ErrReliableRefused = errors.New("peer refused reliable mode")
+	// ErrReliableTimeout 重传超过 relMaxRetries 次仍未收到确认.
+	ErrReliableTimeout = errors.New("reliable retransmission timeout")
+)
+
+type relSegment struct {
+	seq     uint32
+	payload []byte
+	sent    time.Time
+	retries int
+	// 重传过的报文不参与 RTT 采样(Karn)
+	retrans bool
+	// 被后续报文越过确认的次数
+	skipped int
+}
+
+// reliable 连接的可靠传输状态.
+type reliable struct {
+	c *Connection
+	sync.Mutex
+	cond *sync.Cond
+
+	sndNext uint32
+	unacked map[uint32]*relSegment
+
+	rcvNext uint32
+	ooo     map[uint32][]byte
+	// 已经按序可以交付给 Read 的数据
+	ready [][]byte
+
+	srtt   time.Duration
+	rttvar time.Duration
+	rto    time.Duration
+
+	closed bool
+}
+
+func newReliable(c *Connection) *reliable {
+	r := new(reliable)
+	r.c = c
+	r.cond = sync.NewCond(&r.Mutex)
+	r.sndNext = 1
+	r.rcvNext = 1
+	r.unacked = make(map[uint32]*relSegment)
+	r.ooo = make(map[uint32][]byte)
+	r.rto = relInitRTO
+	go r.run()
+	return r
+}
+
+// seqLess a < b (考虑回绕).
+func seqLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+func relPack(kind uint8, seq, ack, sack uint32, payload []byte) []byte {
+	b := make([]byte, relHeaderSize+len(payload))
+	copy(b, relPreHeader)
+	b[2] = kind
+	binary.BigEndian.PutUint32(b[3:], seq)
+	binary.BigEndian.PutUint32(b[7:], ack)
+	binary.BigEndian.PutUint32(b[11:], sack)
+	copy(b[relHeaderSize:], payload)
+	return b
+}
+
+func isRelSegment(b []byte) bool {
+	return len(b) >= relHeaderSize && b[0] == relPreHeader[0] && b[1] == relPreHeader[1]
+}
+
+// isUnreliable 不需要可靠发送的报文(AV).
+func isUnreliable(b []byte) bool {
+	return len(b) > 2 && b[2]>>4 == packets.TYPEAV
+}
+
+// ackState 当前的确认信息, 调用者持有锁.
+func (r *reliable) ackState() (ack, sack uint32) {
+	ack = r.rcvNext
+	for i := uint32(0); i < 32; i++ {
+		if _, ok := r.ooo[ack+1+i]; ok {
+			sack |= 1 << i
+		}
+	}
+	return
+}
+
+// send 可靠发送, 窗口满时阻塞.
+func (r *reliable) send(b []byte) (n int, err error) {
+	r.Lock()
+	for len(r.unacked) >= relWindow && !r.closed {
+		r.cond.Wait()
+	}
+	if r.closed {
+		r.Unlock()
+		return 0, errors.New("use close connection")
+	}
+	seg := &relSegment{seq: r.sndNext, payload: append([]byte(nil), b...), sent: time.Now()}
+	r.sndNext++
+	r.unacked[seg.seq] = seg
+	ack, sack := r.ackState()
+	r.Unlock()
+
+	_, err = r.c.rawWrite(relPack(relDATA, seg.seq, ack, sack, seg.payload))
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// input 处理收到的 DATA/ACK 报文, 按序的数据放入 ready.
+func (r *reliable) input(b []byte) {
+	kind := b[2]
+	seq := binary.BigEndian.Uint32(b[3:])
+	ack := binary.BigEndian.Uint32(b[7:])
+	sack := binary.BigEndian.Uint32(b[11:])
+
+	if kind != relDATA && kind != relACK {
+		return
+	}
+
+	r.Lock()
+	r.handleAck(ack, sack)
+	if kind != relDATA {
+		r.Unlock()
+		return
+	}
+	if _, dup := r.ooo[seq]; dup || seqLess(seq, r.rcvNext) || !seqLess(seq, r.rcvNext+relWindow) {
+		// 重复或超出接收窗口, 只回复确认
+		metrics.Get().IncDrop(metrics.DropRelDup)
+	} else {
+		r.ooo[seq] = append([]byte(nil), b[relHeaderSize:]...)
+		for {
+			p, ok := r.ooo[r.rcvNext]
+			if !ok {
+				break
+			}
+			delete(r.ooo, r.rcvNext)
+			r.ready = append(r.ready, p)
+			r.rcvNext++
+		}
+	}
+	ack, sack = r.ackState()
+	r.Unlock()
+
+	r.c.rawWrite(relPack(relACK, 0, ack, sack, nil))
+}
+
+// handleAck 处理确认信息, 调用者持有锁.
+func (r *reliable) handleAck(ack, sack uint32) {
+	now := time.Now()
+	acked := false
+	for seq, seg := range r.unacked {
+		if !seqLess(seq, ack) && !sacked(seq, ack, sack) {
+			if sack != 0 && seqLess(seq, ack+32-uint32(bits.LeadingZeros32(sack))) {
+				seg.skipped++
+			}
+			continue
+		}
+		if !seg.retrans {
+			r.updateRTO(now.Sub(seg.sent))
+		}
+		delete(r.unacked, seq)
+		acked = true
+	}
+	if acked {
+		r.cond.Broadcast()
+	}
+}
+
+// sacked seq 是否在 sack 中被确认.
+func sacked(seq, ack, sack uint32) bool {
+	d := seq - ack - 1
+	return seq != ack && d < 32 && sack&(1<<d) != 0
+}
+
+// updateRTO RFC 6298.
+func (r *reliable) updateRTO(rtt time.Duration) {
+	if r.srtt == 0 {
+		r.srtt = rtt
+		r.rttvar = rtt / 2
+	} else {
+		d := r.srtt - rtt
+		if d < 0 {
+			d = -d
+		}
+		r.rttvar = (3*r.rttvar + d) / 4
+		r.srtt = (7*r.srtt + rtt) / 8
+	}
+	r.rto = r.srtt + 4*r.rttvar
+	if r.rto < relMinRTO {
+		r.rto = relMinRTO
+	} else if r.rto > relMaxRTO {
+		r.rto = relMaxRTO
+	}
+}
+
+// segRTO 报文的重传超时, 每次重传后加倍(退避).
+func (r *reliable) segRTO(seg *relSegment) time.Duration {
+	rto := r.rto << uint(seg.retries)
+	if rto > relMaxRTO || rto <= 0 {
+		rto = relMaxRTO
+	}
+	return rto
+}
+
+// pop 取出一个可以交付的数据.
+func (r *reliable) pop() ([]byte, bool) {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.ready) == 0 {
+		return nil, false
+	}
+	p := r.ready[0]
+	r.ready = r.ready[1:]
+	return p, true
+}
+
+// run 超时重传.
+func (r *reliable) run() {
+	ticker := time.NewTicker(relTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.c.Ctx.Done():
+			r.Lock()
+			r.closed = true
+			r.cond.Broadcast()
+			r.Unlock()
+			return
+		case now := <-ticker.C:
+			var resend [][]byte
+			timeout := false
+			r.Lock()
+			ack, sack := r.ackState()
+			for _, seg := range r.unacked {
+				if seg.skipped >= relFastResend {
+					// 快速重传, 不退避
+					seg.skipped = 0
+				} else if now.Sub(seg.sent) < r.segRTO(seg) {
+					continue
+				} else if seg.retries >= relMaxRetries {
+					timeout = true
+					break
+				} else {
+					seg.retries++
+				}
+				seg.retrans = true
+				seg.sent = now
+				resend = append(resend, relPack(relDATA, seg.seq, ack, sack, seg.payload))
+			}
+			r.Unlock()
+			if timeout {
+				logs.Logger.Warnf("%s, %s.", r.c, ErrReliableTimeout)
+				r.c.Close()
+				return
+			}
+			for _, b := range resend {
+				r.c.rawWrite(b)
+			}
+		}
+	}
+}
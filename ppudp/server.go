@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync/atomic"
 
+	"github.com/pprpc/metrics"
 	"github.com/pprpc/sess"
 
 	"github.com/pprpc/util/logs"
@@ -27,7 +29,9 @@ type UDPServer struct {
 
 	newConn     chan *Connection
 	closeConn   chan string
-	readTimeout int64
+	readTimeout int64 // atomic, readLoop 中读取
+	// 允许客户端协商可靠传输模式, atomic
+	reliable int32
 
 	sendChan chan sendPkg
 	//bufPool  sync.Pool
@@ -46,6 +50,7 @@ func NewUDPServer(ip string, port, maxSess int) (ts *UDPServer, err error) {
 	if err != nil {
 		return nil, err
 	}
+	ts.conn.SetReadBuffer(SOCKBUFSIZE)
 	//ts.RWMutex = sync.RWMutex{}
 	ts.ctx, ts.ctxCancel = context.WithCancel(context.Background())
 	ts.conns = sess.NewSessions(int32(maxSess))
@@ -91,7 +96,22 @@ func (ts *UDPServer) Addr() net.Addr {
 
 // SetReadTimeout 设置读取数据超时时间.
 func (ts *UDPServer) SetReadTimeout(to int64) {
-	ts.readTimeout = to
+	atomic.StoreInt64(&ts.readTimeout, to)
+}
+
+// SetReliable 是否允许客户端协商可靠传输模式(def: false).
+func (ts *UDPServer) SetReliable(b bool) {
+	var v int32
+	if b {
+		v = 1
+	}
+	atomic.StoreInt32(&ts.reliable, v)
+}
+
+func (ts *UDPServer) newConnection(rAddr *net.UDPAddr) *Connection {
+	c := NewConnection(ts.ctx, ts.conn, rAddr, atomic.LoadInt64(&ts.readTimeout), ts.sendChan, ts.closeConn)
+	c.allowReliable = atomic.LoadInt32(&ts.reliable) == 1
+	return c
 }
 
 func (ts *UDPServer) run() {
@@ -101,7 +121,7 @@ func (ts *UDPServer) run() {
 }
 
 func (ts *UDPServer) readLoop() {
-	data := make([]byte, MAXPKGSIZE)
+	data := make([]byte, readBufSize)
 	// read
 	for {
 		select {
@@ -116,10 +136,11 @@ func (ts *UDPServer) readLoop() {
 			}
 			v, err := ts.conns.Get(remoteAddr.String())
 			if err != nil {
-				c := NewConnection(ts.ctx, ts.conn, remoteAddr, ts.readTimeout, ts.sendChan, ts.closeConn)
+				c := ts.newConnection(remoteAddr)
 				_, err := ts.conns.Push(remoteAddr.String(), c)
 				if err != nil {
 					logs.Logger.Debugf("ts.conns.Push(remoteAddr.String(), c), error: %s.", err)
+					metrics.Get().IncDrop(metrics.DropSessFull)
 					continue
 				}
 				ts.newConn <- c
@@ -166,7 +187,7 @@ func (ts *UDPServer) closeLoop() {
 
 // GetUDPConn 输入对端地址，返回一条连接；不会进入到Accept中
 func (ts *UDPServer) GetUDPConn(rAddr *net.UDPAddr) (c *Connection, err error) {
-	c = NewConnection(ts.ctx, ts.conn, rAddr, ts.readTimeout, ts.sendChan, ts.closeConn)
+	c = ts.newConnection(rAddr)
 	_, err = ts.conns.Push(rAddr.String(), c)
 	if err != nil {
 		logs.Logger.Debugf("ts.conns.Push(remoteAddr.String(), c), error: %s.", err)
@@ -0,0 +1,368 @@
+// protoc-gen-pprpc 根据 proto service 定义生成 pprpc 的 ServiceDesc 注册代码和客户端存根.
+//
+//	protoc --go_out=. --pprpc_out=. greeter.proto
+//
+// 每个方法必须通过 (pprpc.cmdid) 选项指定 CmdID, 参见 pprpc.proto(生成的 Go 代码在 pprpcopt).
+// 指定 (pprpc.noresp) 的方法没有应答, 客户端通过 InvokeAsync 发送, 处理函数只返回 error.
+// stream 方法(客户端流, 服务端流, 双向流)统一生成双向的流接口, 基于 pprpc.Stream.
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// pprpc.proto 中扩展的字段编号
+const (
+	cmdIDField  protowire.Number = 52018
+	noRespField protowire.Number = 52019
+)
+
+// maxCmdID CmdID 最大值(packets 编码上限)
+const maxCmdID uint64 = 268435455
+
+var (
+	contextPackage = protogen.GoImportPath("context")
+	fmtPackage     = protogen.GoImportPath("fmt")
+	packetsPackage = protogen.GoImportPath("github.com/pprpc/packets")
+)
+
+func main() {
+	var flags flag.FlagSet
+	pprpcImport := flags.String("pprpc_import", "github.com/pprpc/core", "import path of the pprpc core package")
+
+	protogen.Options{ParamFunc: flags.Set}.Run(func(gen *protogen.Plugin) error {
+		return generate(gen, protogen.GoImportPath(*pprpcImport))
+	})
+}
+
+// generate 为所有需要生成并且定义了 service 的文件生成代码.
+func generate(gen *protogen.Plugin, pprpcPackage protogen.GoImportPath) error {
+	for _, f := range gen.Files {
+		if !f.Generate || len(f.Services) == 0 {
+			continue
+		}
+		if err := generateFile(gen, f, pprpcPackage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getCmdID 读取方法上的 (pprpc.cmdid) 选项.
+func getCmdID(m *protogen.Method) (uint64, bool) {
+	return methodOption(m, cmdIDField)
+}
+
+// isNoResp 方法是否指定了 (pprpc.noresp) = true.
+func isNoResp(m *protogen.Method) bool {
+	v, _ := methodOption(m, noRespField)
+	return v != 0
+}
+
+// methodOption 读取方法上 varint 类型的扩展选项.
+func methodOption(m *protogen.Method, field protowire.Number) (uint64, bool) {
+	opts, ok := m.Desc.Options().(*descriptorpb.MethodOptions)
+	if !ok || opts == nil {
+		return 0, false
+	}
+	b := opts.ProtoReflect().GetUnknown()
+	if len(b) == 0 {
+		// 扩展已注册时不会出现在 unknown 中, 重新编码后查找.
+		b, _ = proto.Marshal(opts)
+	}
+	var val uint64
+	var found bool
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, false
+		}
+		b = b[n:]
+		if num == field && typ == protowire.VarintType {
+			v, l := protowire.ConsumeVarint(b)
+			if l < 0 {
+				return 0, false
+			}
+			val, found = v, true
+			b = b[l:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return 0, false
+		}
+		b = b[n:]
+	}
+	return val, found
+}
+
+func generateFile(gen *protogen.Plugin, file *protogen.File, pprpcPackage protogen.GoImportPath) error {
+	// 先检查 CmdID, 出错时不输出文件.
+	ids := make(map[uint64]string)
+	for _, s := range file.Services {
+		for _, m := range s.Methods {
+			id, ok := getCmdID(m)
+			if !ok {
+				return fmt.Errorf("%s: method %s missing option (pprpc.cmdid)", file.Desc.Path(), m.Desc.FullName())
+			}
+			if id == 0 || id > maxCmdID {
+				return fmt.Errorf("%s: method %s cmdid %d out of range [1, %d]", file.Desc.Path(), m.Desc.FullName(), id, maxCmdID)
+			}
+			if isNoResp(m) && isStreaming(m) {
+				return fmt.Errorf("%s: method %s stream not support option (pprpc.noresp)", file.Desc.Path(), m.Desc.FullName())
+			}
+			if v, ok := ids[id]; ok {
+				return fmt.Errorf("%s: method %s cmdid %d already used by %s", file.Desc.Path(), m.Desc.FullName(), id, v)
+			}
+			ids[id] = string(m.Desc.FullName())
+		}
+	}
+
+	filename := file.GeneratedFilenamePrefix + ".pprpc.go"
+	g := gen.NewGeneratedFile(filename, file.GoImportPath)
+	g.P("// Code generated by protoc-gen-pprpc. DO NOT EDIT.")
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+
+	for _, s := range file.Services {
+		generateService(g, s, pprpcPackage)
+	}
+	return nil
+}
+
+func cmdIDName(s *protogen.Service, m *protogen.Method) string {
+	return "CmdID" + s.GoName + m.GoName
+}
+
+func isStreaming(m *protogen.Method) bool {
+	return m.Desc.IsStreamingClient() || m.Desc.IsStreamingServer()
+}
+
+func generateService(g *protogen.GeneratedFile, s *protogen.Service, pprpcPackage protogen.GoImportPath) {
+	serverName := s.GoName + "Server"
+	clientName := s.GoName + "Client"
+	rpcConn := g.QualifiedGoIdent(pprpcPackage.Ident("RPCConn"))
+	cmdPacket := g.QualifiedGoIdent(packetsPackage.Ident("CmdPacket"))
+
+	// CmdID
+	g.P("// ", s.GoName, " CmdID 定义")
+	g.P("const (")
+	for _, m := range s.Methods {
+		id, _ := getCmdID(m)
+		g.P(cmdIDName(s, m), " uint64 = ", id)
+	}
+	g.P(")")
+	g.P()
+
+	// Server interface
+	g.P("// ", serverName, " ", s.GoName, " 服务端接口, 返回的应答由生成代码写回.")
+	g.P("type ", serverName, " interface {")
+	for _, m := range s.Methods {
+		if isStreaming(m) {
+			g.P(m.GoName, "(conn ", rpcConn, ", stream ", s.GoName, "_", m.GoName, "Server) error")
+			continue
+		}
+		if isNoResp(m) {
+			g.P(m.GoName, "(conn ", rpcConn, ", pkg *", cmdPacket, ", req *", m.Input.GoIdent, ") error")
+			continue
+		}
+		g.P(m.GoName, "(conn ", rpcConn, ", pkg *", cmdPacket, ", req *", m.Input.GoIdent, ") (*", m.Output.GoIdent, ", error)")
+	}
+	g.P("}")
+	g.P()
+
+	// Handlers
+	for _, m := range s.Methods {
+		hname := "_" + s.GoName + "_" + m.GoName
+		if isStreaming(m) {
+			generateStreamServer(g, s, m, pprpcPackage)
+			continue
+		}
+		g.P("func ", hname, "_ReqHandler(srv interface{}, conn ", rpcConn, ", pkg *", cmdPacket,
+			", isCall bool, dec func(interface{}) error) (interface{}, error) {")
+		g.P("in := new(", m.Input.GoIdent, ")")
+		g.P("if err := dec(in); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("if !isCall {")
+		g.P("return in, nil")
+		g.P("}")
+		g.P("impl, ok := srv.(", serverName, ")")
+		g.P("if !ok {")
+		g.P("return in, ", fmtPackage.Ident("Errorf"), "(\"CmdID: %d, ", serverName, " not implement\", ", cmdIDName(s, m), ")")
+		g.P("}")
+		if isNoResp(m) {
+			g.P("return in, impl.", m.GoName, "(conn, pkg, in)")
+			g.P("}")
+			g.P()
+			continue
+		}
+		g.P("out, err := impl.", m.GoName, "(conn, pkg, in)")
+		g.P("if err != nil {")
+		g.P("return out, err")
+		g.P("}")
+		g.P("_, err = ", pprpcPackage.Ident("WriteResp"), "(conn, pkg, out)")
+		g.P("return out, err")
+		g.P("}")
+		g.P()
+		g.P("func ", hname, "_RespHandler(srv interface{}, conn ", rpcConn, ", pkg *", cmdPacket,
+			", isCall bool, dec func(interface{}) error) (interface{}, error) {")
+		g.P("out := new(", m.Output.GoIdent, ")")
+		g.P("if err := dec(out); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return out, nil")
+		g.P("}")
+		g.P()
+	}
+
+	// Register
+	g.P("// Register", s.GoName, " 注册 ", s.GoName, " 的所有命令; 仅作为客户端使用时 impl 可以为 nil.")
+	g.P("func Register", s.GoName, "(s *", pprpcPackage.Ident("Service"), ", impl ", serverName, ") {")
+	for _, m := range s.Methods {
+		hname := "_" + s.GoName + "_" + m.GoName
+		g.P("s.RegisterService(&", pprpcPackage.Ident("ServiceDesc"), "{")
+		g.P("CmdID: ", cmdIDName(s, m), ",")
+		g.P("CmdName: \"", s.GoName, ".", m.GoName, "\",")
+		if isStreaming(m) {
+			g.P("StreamHandler: ", hname, "_StreamHandler,")
+		} else {
+			g.P("ReqHandler: ", hname, "_ReqHandler,")
+			if !isNoResp(m) {
+				g.P("RespHandler: ", hname, "_RespHandler,")
+			}
+		}
+		g.P("}, impl)")
+	}
+	g.P("}")
+	g.P()
+
+	// Client
+	g.P("// ", clientName, " ", s.GoName, " 客户端, 连接上的 Service 需要先调用 Register", s.GoName, ".")
+	g.P("type ", clientName, " struct {")
+	g.P("cc ", pprpcPackage.Ident("RPCCliConn"))
+	g.P("}")
+	g.P()
+	g.P("// New", clientName, " 创建客户端")
+	g.P("func New", clientName, "(cc ", pprpcPackage.Ident("RPCCliConn"), ") *", clientName, " {")
+	g.P("return &", clientName, "{cc: cc}")
+	g.P("}")
+	g.P()
+	for _, m := range s.Methods {
+		if isStreaming(m) {
+			generateStreamClient(g, s, m, pprpcPackage)
+			continue
+		}
+		if isNoResp(m) {
+			g.P("// ", m.GoName, " 异步调用 ", cmdIDName(s, m), ", 对端不写入应答")
+			g.P("func (c *", clientName, ") ", m.GoName, "(ctx ", contextPackage.Ident("Context"), ", req *", m.Input.GoIdent, ") error {")
+			g.P("return c.cc.InvokeAsync(ctx, ", cmdIDName(s, m), ", req)")
+			g.P("}")
+			g.P()
+			continue
+		}
+		g.P("// ", m.GoName, " 同步调用 ", cmdIDName(s, m), ", 错误应答返回 *status.Status")
+		g.P("func (c *", clientName, ") ", m.GoName, "(ctx ", contextPackage.Ident("Context"), ", req *", m.Input.GoIdent,
+			") (*", m.Output.GoIdent, ", error) {")
+		g.P("_, resp, err := c.cc.Invoke(ctx, ", cmdIDName(s, m), ", req)")
+		g.P("if err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("out, ok := resp.(*", m.Output.GoIdent, ")")
+		g.P("if !ok {")
+		g.P("return nil, ", fmtPackage.Ident("Errorf"), "(\"CmdID: %d, response type %T not match\", ", cmdIDName(s, m), ", resp)")
+		g.P("}")
+		g.P("return out, nil")
+		g.P("}")
+		g.P()
+	}
+}
+
+// generateStreamServer 生成 stream 方法的服务端流接口和 StreamHandler.
+func generateStreamServer(g *protogen.GeneratedFile, s *protogen.Service, m *protogen.Method, pprpcPackage protogen.GoImportPath) {
+	hname := "_" + s.GoName + "_" + m.GoName
+	iname := s.GoName + "_" + m.GoName + "Server"
+	serverName := s.GoName + "Server"
+
+	g.P("// ", iname, " ", s.GoName, ".", m.GoName, " 服务端流, 处理函数返回即结束流.")
+	g.P("type ", iname, " interface {")
+	g.P("Context() ", contextPackage.Ident("Context"))
+	g.P("Send(*", m.Output.GoIdent, ") error")
+	g.P("Recv() (*", m.Input.GoIdent, ", error)")
+	g.P("}")
+	g.P()
+	g.P("type ", hname, "Server struct {")
+	g.P(pprpcPackage.Ident("Stream"))
+	g.P("}")
+	g.P()
+	g.P("func (x *", hname, "Server) Send(m *", m.Output.GoIdent, ") error {")
+	g.P("return x.Stream.Send(m)")
+	g.P("}")
+	g.P()
+	g.P("func (x *", hname, "Server) Recv() (*", m.Input.GoIdent, ", error) {")
+	g.P("m := new(", m.Input.GoIdent, ")")
+	g.P("if err := x.Stream.Recv(m); err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("return m, nil")
+	g.P("}")
+	g.P()
+	g.P("func ", hname, "_StreamHandler(srv interface{}, conn ", pprpcPackage.Ident("RPCConn"), ", s ", pprpcPackage.Ident("Stream"), ") error {")
+	g.P("impl, ok := srv.(", serverName, ")")
+	g.P("if !ok {")
+	g.P("return ", fmtPackage.Ident("Errorf"), "(\"CmdID: %d, ", serverName, " not implement\", ", cmdIDName(s, m), ")")
+	g.P("}")
+	g.P("return impl.", m.GoName, "(conn, &", hname, "Server{s})")
+	g.P("}")
+	g.P()
+}
+
+// generateStreamClient 生成 stream 方法的客户端流接口和打开流的方法.
+func generateStreamClient(g *protogen.GeneratedFile, s *protogen.Service, m *protogen.Method, pprpcPackage protogen.GoImportPath) {
+	hname := "_" + s.GoName + "_" + m.GoName
+	iname := s.GoName + "_" + m.GoName + "Client"
+	clientName := s.GoName + "Client"
+
+	g.P("// ", iname, " ", s.GoName, ".", m.GoName, " 客户端流, 发送结束调用 CloseSend, 服务端结束后 Recv 返回 io.EOF.")
+	g.P("type ", iname, " interface {")
+	g.P("Context() ", contextPackage.Ident("Context"))
+	g.P("Send(*", m.Input.GoIdent, ") error")
+	g.P("Recv() (*", m.Output.GoIdent, ", error)")
+	g.P("CloseSend() error")
+	g.P("Cancel()")
+	g.P("}")
+	g.P()
+	g.P("type ", hname, "Client struct {")
+	g.P(pprpcPackage.Ident("Stream"))
+	g.P("}")
+	g.P()
+	g.P("func (x *", hname, "Client) Send(m *", m.Input.GoIdent, ") error {")
+	g.P("return x.Stream.Send(m)")
+	g.P("}")
+	g.P()
+	g.P("func (x *", hname, "Client) Recv() (*", m.Output.GoIdent, ", error) {")
+	g.P("m := new(", m.Output.GoIdent, ")")
+	g.P("if err := x.Stream.Recv(m); err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("return m, nil")
+	g.P("}")
+	g.P()
+	g.P("// ", m.GoName, " 打开流 ", cmdIDName(s, m), ", ctx 结束时取消流.")
+	g.P("func (c *", clientName, ") ", m.GoName, "(ctx ", contextPackage.Ident("Context"), ") (", iname, ", error) {")
+	g.P("s, err := c.cc.NewStream(ctx, ", cmdIDName(s, m), ")")
+	g.P("if err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("return &", hname, "Client{s}, nil")
+	g.P("}")
+	g.P()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// testdata/greeter.desc 由 testdata/greeter.proto 生成:
+//
+//	protoc -I . -I testdata --include_imports --descriptor_set_out=testdata/greeter.desc greeter.proto
+//	protoc -I . -I testdata --go_out=. --go_opt=module=github.com/pprpc/protoc-gen-pprpc greeter.proto
+//	go test -update
+var update = flag.Bool("update", false, "update golden files")
+
+const golden = "internal/greeter/greeter.pprpc.go"
+
+func TestGolden(t *testing.T) {
+	b, err := os.ReadFile("testdata/greeter.desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := new(descriptorpb.FileDescriptorSet)
+	if err = proto.Unmarshal(b, set); err != nil {
+		t.Fatal(err)
+	}
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"greeter.proto"},
+		ProtoFile:      set.File,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = generate(gen, "github.com/pprpc/core"); err != nil {
+		t.Fatal(err)
+	}
+	resp := gen.Response()
+	if resp.Error != nil {
+		t.Fatal(resp.GetError())
+	}
+	if len(resp.File) != 1 {
+		t.Fatalf("generated %d files, expect 1", len(resp.File))
+	}
+	got := []byte(resp.File[0].GetContent())
+
+	if *update {
+		if err = os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: generated code not match, run go test -update", golden)
+	}
+
+	// 生成的代码(包括 import 的 pprpcopt)可以编译
+	out, err := exec.Command("go", "vet", "./internal/greeter").CombinedOutput()
+	if err != nil {
+		t.Fatalf("go vet: %s\n%s", err, out)
+	}
+}
+
+func TestCmdIDErrors(t *testing.T) {
+	b, err := os.ReadFile("testdata/greeter.desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := new(descriptorpb.FileDescriptorSet)
+	if err = proto.Unmarshal(b, set); err != nil {
+		t.Fatal(err)
+	}
+	var f *descriptorpb.FileDescriptorProto
+	for _, fd := range set.File {
+		if fd.GetName() == "greeter.proto" {
+			f = fd
+		}
+	}
+	// stream 方法不支持 noresp
+	chat := f.Service[0].Method[1]
+	chat.Options = proto.Clone(chat.Options).(*descriptorpb.MethodOptions)
+	noresp := protowire.AppendTag(nil, noRespField, protowire.VarintType)
+	noresp = protowire.AppendVarint(noresp, 1)
+	chat.Options.ProtoReflect().SetUnknown(append(chat.Options.ProtoReflect().GetUnknown(), noresp...))
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"greeter.proto"},
+		ProtoFile:      set.File,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = generate(gen, "github.com/pprpc/core"); err == nil {
+		t.Fatal("noresp stream, expect error")
+	}
+
+	// 两个方法使用相同的 CmdID
+	chat.Options = proto.Clone(f.Service[0].Method[0].Options).(*descriptorpb.MethodOptions)
+	gen, err = protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"greeter.proto"},
+		ProtoFile:      set.File,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = generate(gen, "github.com/pprpc/core"); err == nil {
+		t.Fatal("duplicate cmdid, expect error")
+	}
+
+	// 没有 CmdID
+	chat.Options = nil
+	gen, err = protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"greeter.proto"},
+		ProtoFile:      set.File,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = generate(gen, "github.com/pprpc/core"); err == nil {
+		t.Fatal("missing cmdid, expect error")
+	}
+}
@@ -0,0 +1,178 @@
+package pprpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pprpc/packets"
+)
+
+// callTable 服务端连接上的同步调用表.
+type callTable struct {
+	*Service
+	calls *sync.Map // CmdSeq -> chan *packets.CmdPacket
+	seqs  *seqAllocator
+	// 对端打开的流, CmdSeq -> *rpcStream
+	streams *sync.Map
+}
+
+// callCtxs 处理中的请求的 context, *packets.CmdPacket -> context.Context
+var callCtxs = new(sync.Map)
+
+// callKey 连接上处理中的请求
+type callKey struct {
+	c   RPCConn
+	seq uint64
+}
+
+// callCancels 处理中的请求(服务端和客户端), callKey -> context.CancelFunc
+var callCancels = new(sync.Map)
+
+// callTables 所有服务端连接的同步调用表, RPCConn -> *callTable
+var callTables = new(sync.Map)
+
+// bindCallTable 连接建立时绑定调用表.
+func bindCallTable(c RPCConn, s *Service) *callTable {
+	ct := new(callTable)
+	ct.Service = s
+	ct.calls = new(sync.Map)
+	ct.seqs = newSeqAllocator(ct.calls)
+	ct.streams = new(sync.Map)
+	callTables.Store(c, ct)
+	return ct
+}
+
+// unbindCallTable 连接断开时解除绑定.
+func unbindCallTable(c RPCConn) {
+	callTables.Delete(c)
+}
+
+// getCallTable 获取连接的调用表, 未绑定返回nil.
+func getCallTable(c RPCConn) *callTable {
+	v, ok := callTables.Load(c)
+	if ok {
+		return v.(*callTable)
+	}
+	return nil
+}
+
+// dispatchResp 将应答报文交给等待的 Invoke, 没有等待者返回 false.
+func dispatchResp(c RPCConn, pkg *packets.CmdPacket) bool {
+	if pkg.RPCType != packets.RPCRESP {
+		return false
+	}
+	ct := getCallTable(c)
+	if ct == nil {
+		return false
+	}
+	v, ok := ct.calls.Load(pkg.CmdSeq)
+	if !ok {
+		return false
+	}
+	select {
+	case v.(chan *packets.CmdPacket) <- pkg:
+	default:
+	}
+	return true
+}
+
+// CallContext 获取请求的 context: 对端取消, 超过请求的 Timeout 或者连接断开时 Done.
+// 仅在服务端 ReqHandler 执行期间有效, 其他情况返回 context.Background().
+// RPCTCPServer.RunGO 为 false 时处理期间不读取报文, 对端的取消要等处理完成后才能收到.
+func CallContext(pkg *packets.CmdPacket) context.Context {
+	if v, ok := callCtxs.Load(pkg); ok {
+		return v.(context.Context)
+	}
+	return context.Background()
+}
+
+// callState 处理中的请求的状态
+type callState struct {
+	mu        sync.Mutex
+	trailer   MD   // 应答元数据
+	responded bool // 已经写入应答
+}
+
+type callStateKey struct{}
+
+// getCallState 获取处理中的请求的状态, 未找到返回 nil.
+func getCallState(pkg *packets.CmdPacket) *callState {
+	cs, _ := CallContext(pkg).Value(callStateKey{}).(*callState)
+	return cs
+}
+
+// markResponded 记录请求已经应答, 返回之前是否已经应答.
+func markResponded(pkg *packets.CmdPacket) bool {
+	cs := getCallState(pkg)
+	if cs == nil {
+		return false
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	old := cs.responded
+	cs.responded = true
+	return old
+}
+
+// beginCall 为请求创建 context(包含服务端的 span), 请求处理完成后调用返回的 cancel.
+func (s *Service) beginCall(c RPCConn, pkg *packets.CmdPacket) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if pkg.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(c.HandleClose(), time.Duration(pkg.Timeout)*time.Millisecond)
+	} else {
+		ctx, cancel = context.WithCancel(c.HandleClose())
+	}
+	ctx = withIncomingMD(ctx, pkg)
+	ctx = context.WithValue(ctx, callStateKey{}, new(callState))
+	ctx = s.startServerSpan(ctx, c, pkg)
+	key := callKey{c, pkg.CmdSeq}
+	callCancels.Store(key, cancel)
+	callCtxs.Store(pkg, ctx)
+	return ctx, func() {
+		callCtxs.Delete(pkg)
+		callCancels.Delete(key)
+		cancel()
+	}
+}
+
+// isCallCancel 是否是对端取消请求的报文(writeCancel), 流的取消携带报文计数.
+func isCallCancel(cmd *packets.CmdPacket) bool {
+	return cmd.RPCType == packets.RPCCTRL && cmd.Code == packets.CTRLCANCEL && cmd.Frame == 0
+}
+
+// cancelCall 对端取消请求(RPCCTRL CTRLCANCEL), 返回是否找到处理中的请求.
+func cancelCall(c RPCConn, seq uint64) bool {
+	v, ok := callCancels.Load(callKey{c, seq})
+	if ok {
+		v.(context.CancelFunc)()
+	}
+	return ok
+}
+
+// callTimeout 请求的剩余超时时间(毫秒), ctx 没有 deadline 返回 0(不编码扩展段, 兼容旧版本).
+func callTimeout(ctx context.Context) uint64 {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+	ms := time.Until(d).Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return uint64(ms)
+}
+
+// writeCancel 通知对端取消请求.
+func writeCancel(c RPCConn, req *packets.CmdPacket, protocol uint8) error {
+	cmd := packets.NewCmdPacket(req.MessageType)
+	cmd.FixHeader.SetProtocol(protocol)
+	cmd.CmdID = req.CmdID
+	cmd.CmdSeq = req.CmdSeq
+	cmd.EncType = req.EncType
+	cmd.RPCType = packets.RPCCTRL
+	cmd.Code = packets.CTRLCANCEL
+	_, err := cmd.Write(c)
+	return err
+}
@@ -0,0 +1,168 @@
+package pprpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pprpc/packets"
+	"github.com/pprpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestInvoke(t *testing.T) {
+	h := newTestHandler()
+	srv := newMemServer(t, h.service(), nil)
+	cli := dialMem(t, srv.url, newTestHandler().service(), nil)
+	sc := <-srv.conns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, crypt := range []uint8{packets.AESNONE, packets.AES256CFB, packets.AES128GCM, packets.AES256GCM, packets.CHACHA20POLY1305} {
+		cli.SetCrypt(crypt)
+		_, resp, err := cli.Invoke(ctx, testCmdEcho, wrapperspb.String("c2s"))
+		if v := respValue(t, resp, err); v != "echo:c2s" {
+			t.Fatalf("crypt: %d, resp: %q", crypt, v)
+		}
+
+		// 服务端调用客户端注册的服务
+		_, resp, err = Invoke(ctx, sc, testCmdEcho, wrapperspb.String("s2c"), packets.TYPEPBBIN, crypt)
+		if v := respValue(t, resp, err); v != "echo:s2c" {
+			t.Fatalf("crypt: %d, resp: %q", crypt, v)
+		}
+	}
+
+	_, _, err := cli.Invoke(ctx, 99, wrapperspb.String("x"))
+	if status.CodeOf(err) != status.Unimplemented {
+		t.Fatalf("unregistered cmdid, err: %v", err)
+	}
+}
+
+func TestInvokeCancel(t *testing.T) {
+	h := newTestHandler()
+	srv := newMemServer(t, h.service(), nil)
+	cli := dialMem(t, srv.url, h.service(), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-h.started
+		cancel()
+	}()
+	_, _, err := cli.Invoke(ctx, testCmdWait, wrapperspb.String("cancel"))
+	if status.CodeOf(err) != status.Canceled {
+		t.Fatalf("err: %v", err)
+	}
+	// 服务端收到 CTRLCANCEL 后结束 CallContext
+	select {
+	case err = <-h.done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("server ctx err: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server CallContext not canceled")
+	}
+}
+
+func TestInvokeDeadline(t *testing.T) {
+	h := newTestHandler()
+	srv := newMemServer(t, h.service(), nil)
+	cli := dialMem(t, srv.url, h.service(), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := cli.Invoke(ctx, testCmdWait, wrapperspb.String("deadline"))
+	if status.CodeOf(err) != status.DeadlineExceeded {
+		t.Fatalf("err: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Invoke returned after %s", d)
+	}
+	select {
+	case err = <-h.done:
+		if err == nil {
+			t.Fatal("server ctx not done")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server CallContext not done")
+	}
+
+	// 没有设置超时时间的 ctx 使用 SyncWriteTimeoutMs
+	cli.SyncWriteTimeoutMs = 100
+	_, _, err = cli.Invoke(context.Background(), testCmdWait, wrapperspb.String("default"))
+	if status.CodeOf(err) != status.DeadlineExceeded {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestInvokeNoDeadline(t *testing.T) {
+	h := newTestHandler()
+	srv := newMemServer(t, h.service(), nil)
+	timeouts := make(chan uint64, 4)
+	srv.PreHookCB = func(pkg packets.PPPacket, conn RPCConn) bool {
+		if cmd, ok := pkg.(*packets.CmdPacket); ok && cmd.RPCType == packets.RPCREQ {
+			timeouts <- cmd.Timeout
+		}
+		return true
+	}
+	cli := dialMem(t, srv.url, h.service(), nil)
+	cli.SyncWriteTimeoutMs = 100
+
+	// SyncWriteTimeoutMs 只用于本地等待, 请求不携带 Timeout(旧版本服务端不支持扩展段)
+	_, _, err := cli.Invoke(context.Background(), testCmdSlow, wrapperspb.String("default"))
+	if status.CodeOf(err) != status.DeadlineExceeded {
+		t.Fatalf("err: %v", err)
+	}
+	if v := <-timeouts; v != 0 {
+		t.Fatalf("Timeout: %d", v)
+	}
+
+	// 调用者设置的超时时间发送给对端
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, resp, err := cli.Invoke(ctx, testCmdEcho, wrapperspb.String("deadline"))
+	respValue(t, resp, err)
+	if v := <-timeouts; v == 0 || v > 2000 {
+		t.Fatalf("Timeout: %d", v)
+	}
+}
+
+func TestInvokeConcurrent(t *testing.T) {
+	h := newTestHandler()
+	srv := newMemServer(t, h.service(), nil)
+	cli := dialMem(t, srv.url, h.service(), nil)
+	go func() {
+		for range h.started {
+		}
+	}()
+	// 同一个连接上并发调用, 序列号从接近最大值开始以覆盖回绕
+	cli.seqs.mu.Lock()
+	cli.seqs.last = maxSeqID - 50
+	cli.seqs.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				v := fmt.Sprintf("%d-%d", g, i)
+				_, resp, err := cli.Invoke(ctx, testCmdEcho, wrapperspb.String(v))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got := resp.(*wrapperspb.StringValue).Value; got != "echo:"+v {
+					t.Errorf("resp: %q, want: %q", got, "echo:"+v)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
@@ -8,10 +8,11 @@ import (
 	"sync"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/pprpc/util/logs"
+	"github.com/pprpc/metrics"
 	"github.com/pprpc/packets"
 	"github.com/pprpc/ppudp"
+	"github.com/pprpc/status"
 )
 
 // UDPCliConn PPRPC TCP 连接.
@@ -19,6 +20,7 @@ type UDPCliConn struct {
 	ctx                context.Context
 	ctxCancel          context.CancelFunc
 	hbSec              int
+	// SyncWriteTimeoutMs Invoke 的 ctx 没有设置超时时间时使用的超时时间(毫秒)
 	SyncWriteTimeoutMs int
 
 	*ppudp.ClientConn
@@ -27,28 +29,53 @@ type UDPCliConn struct {
 	firstChan chan error
 
 	asyncChans *sync.Map
+	seqs       *seqAllocator
 	// 加密类型
 	cryptType   uint8
 	messageType uint8
-	// 如果该接口返回值 !=nil 则不执行后续回调(HBCB, CmdCB, AVCB, CustomerCB)
+	// 如果该接口返回值 !=nil 则不执行后续回调(HBCB, CmdCB, AVCB, FileCB, CustomerCB)
 	PreHookCB pkgCallBack
 
 	HBCB       hbCallBack       // 心跳回调
 	CmdCB      cmdCallBack      // 控制报文回调
 	AVCB       avCallBack       // 音视频流回调
+	FileCB     fileCallBack     // 文件传输报文回调(在读取协程中按顺序调用, 不能长时间阻塞)
 	CustomerCB customerCallBack // 自定义数据回调
 	autohb     bool
+	// 密钥协商配置
+	kx *KXConfig
 }
 
-// DailUDP 建立PPRPC的连接(udp)
+// DailUDP 建立PPRPC的连接(udp), 使用默认配置进行密钥协商.
 func DailUDP(addr string, si *Service, readTimeout int64, fn udpCliCallBack) (tcc *UDPCliConn, err error) {
+	return DailUDPKX(addr, si, readTimeout, nil, fn)
+}
+
+// DailUDPKX 建立PPRPC的连接(udp), kx 为 nil 使用默认配置; kx.Legacy 不进行密钥协商.
+func DailUDPKX(addr string, si *Service, readTimeout int64, kx *KXConfig, fn udpCliCallBack) (tcc *UDPCliConn, err error) {
+	return dailUDP(addr, si, readTimeout, kx, false, fn)
+}
+
+// DailUDPReliable 建立PPRPC的连接(udp), 并与服务端协商可靠传输模式(需服务端 SetReliable(true)).
+// AV 报文仍然不可靠发送.
+func DailUDPReliable(addr string, si *Service, readTimeout int64, kx *KXConfig, fn udpCliCallBack) (tcc *UDPCliConn, err error) {
+	return dailUDP(addr, si, readTimeout, kx, true, fn)
+}
+
+func dailUDP(addr string, si *Service, readTimeout int64, kx *KXConfig, reliable bool, fn udpCliCallBack) (tcc *UDPCliConn, err error) {
+	if kx == nil {
+		kx = defKXConfig
+	}
 	tcc = new(UDPCliConn)
+	tcc.kx = kx
 	tcc.ctx, tcc.ctxCancel = context.WithCancel(context.Background())
 	tcc.Service = si
 
 	tcc.asyncChans = new(sync.Map)
+	tcc.seqs = newSeqAllocator(tcc.asyncChans)
 
 	tcc.ClientConn = ppudp.NewClientConn(addr, readTimeout)
+	tcc.ClientConn.SetReliable(reliable)
 	tcc.hbSec = 10
 	tcc.SyncWriteTimeoutMs = 3000
 
@@ -66,11 +93,23 @@ func (tcc *UDPCliConn) run(fn udpCliCallBack) {
 	cli := tcc.ClientConn
 	var err error
 	err = cli.Connect()
+	if err == nil && !tcc.kx.Legacy {
+		err = clientKX(cli.Connection, tcc.kx, packets.PROTOUDP, func() (packets.PPPacket, error) {
+			return packets.ReadUDPPacketAdv(cli, cli.AutoCrypt)
+		})
+		if err != nil {
+			err = fmt.Errorf("clientKX(), %s", err)
+			cli.Close()
+		}
+	}
 	if err != nil {
-		logs.Logger.Errorf("cli.Connect(), waiting 3sec reconnect, error: %s.", err)
+		logs.Logger.Errorf("cli.Connect(), error: %s.", err)
 		tcc.firstChan <- err
+		return
 	}
 	tcc.firstChan <- nil
+	metrics.Get().AddConns("udp", metrics.SideClient, 1)
+	defer metrics.Get().AddConns("udp", metrics.SideClient, -1)
 	// 连接建立回调
 	if fn != nil {
 		go fn(tcc)
@@ -91,7 +130,7 @@ func (tcc *UDPCliConn) run(fn udpCliCallBack) {
 				logs.Logger.Warn("tcc.ctx.Done(), HB exit.")
 				return
 			default:
-				pkg, err := packets.ReadUDPPacket(cli)
+				pkg, err := packets.ReadUDPPacketAdv(cli, cli.AutoCrypt)
 				if err == io.EOF {
 					err = nil
 					goto connEnd
@@ -99,7 +138,14 @@ func (tcc *UDPCliConn) run(fn udpCliCallBack) {
 					logs.Logger.Errorf("packets.ReadUDPPacket(), error: %s.", err)
 					break
 				}
-				go tcc.handlePacket(pkg)
+				if routeStream(tcc.asyncChans, tcc.ClientConn, pkg) {
+					continue
+				}
+				if inOrder(pkg) {
+					tcc.handlePacket(pkg)
+				} else {
+					go tcc.handlePacket(pkg)
+				}
 			}
 		}
 	connEnd:
@@ -135,29 +181,36 @@ Stop:
 	logs.Logger.Warnf("Close UDPCliConn.")
 }
 
-// Invoke 调用Service,同步
+// Invoke 调用Service,同步; 错误应答(Code != 0), 超时和取消返回 *status.Status.
 func (tcc *UDPCliConn) Invoke(ctx context.Context, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	defer func(start time.Time) {
+		tcc.observeCall(metrics.SideClient, cmdid, start, uint64(status.CodeOf(err)))
+	}(time.Now())
 	// 检查连接状态
 	_s, _ := tcc.GetState()
 	if _s != ppudp.StateConnected {
-		err = fmt.Errorf("connect status: %d, not Invoke", _s)
+		err = status.Errorf(status.Unavailable, "connect status: %d, not Invoke", _s)
+		return
+	}
+	if tcc.seqs.Draining() {
+		err = status.Errorf(status.Unavailable, "server going away, not Invoke")
 		return
 	}
 
 	// 构造 CmdHeader.
-	seq := GetSeqID()
 	cmd := packets.NewCmdPacket(tcc.messageType)
 	cmd.FixHeader.SetProtocol(packets.PROTOUDP)
-	cmd.CmdSeq = seq
 	cmd.CmdID = cmdid
 	cmd.EncType = tcc.cryptType
 	cmd.RPCType = packets.RPCREQ
+	cmd.Timeout = callTimeout(ctx)
+	cmd.Metadata = outgoingMD(ctx)
+	sp := tcc.startClientSpan(ctx, tcc.ClientConn, cmd)
+	defer func() {
+		endSpan(sp, uint64(status.CodeOf(err)), err)
+	}()
 
-	if tcc.messageType == packets.TYPEPBBIN {
-		cmd.Payload, err = proto.Marshal(req.(proto.Message))
-	} else if tcc.messageType == packets.TYPEPBJSON {
-		cmd.Payload, err = proto.MarshalMessageSetJSON(req)
-	}
+	cmd.Payload, err = encodePayload(tcc.messageType, req)
 	if err != nil {
 		return
 	}
@@ -165,24 +218,37 @@ func (tcc *UDPCliConn) Invoke(ctx context.Context, cmdid uint64, req interface{}
 	// 构造返回请求.
 	ansQueue := make(chan *packets.CmdPacket, 2)
 
-	tcc.asyncChans.Store(seq, ansQueue)
-	defer func() {
-		tcc.asyncChans.Delete(seqID)
-		close(ansQueue)
-	}()
+	seq, err := tcc.seqs.Acquire(ansQueue, cmd.EncType)
+	if err != nil {
+		return
+	}
+	defer tcc.seqs.Release(seq)
+	cmd.CmdSeq = seq
 	// Write
 	_, err = cmd.Write(tcc.ClientConn)
 	if err != nil {
 		return
 	}
+	// 没有设置超时时间的 ctx 使用 SyncWriteTimeoutMs, 只用于本地等待, 不发送给对端(兼容旧版本)
+	var wait <-chan time.Time
+	if cmd.Timeout == 0 {
+		timer := time.NewTimer(time.Millisecond * time.Duration(tcc.SyncWriteTimeoutMs))
+		defer timer.Stop()
+		wait = timer.C
+	}
 	// Read
 	select {
-	case <-time.After(time.Millisecond * time.Duration(tcc.SyncWriteTimeoutMs)):
-		err = fmt.Errorf("write cmdid: %d timeout", cmdid)
+	case <-wait:
+		err = status.Errorf(status.DeadlineExceeded, "wait response timeout: %dms", tcc.SyncWriteTimeoutMs)
+		return
 	case <-ctx.Done():
-		err = fmt.Errorf("ctx.Done()")
+		writeCancel(tcc.ClientConn, cmd, packets.PROTOUDP)
+		err = status.FromContextError(ctx.Err())
 		return
 	case pkg = <-ansQueue:
+		if err = respError(pkg); err != nil {
+			return
+		}
 		resp, err = tcc.decodeCmd(pkg, tcc.ClientConn)
 	}
 
@@ -197,20 +263,28 @@ func (tcc *UDPCliConn) InvokeAsync(ctx context.Context, cmdid uint64, req interf
 		err = fmt.Errorf("connect status: %d, not Invoke", _s)
 		return
 	}
+	if tcc.seqs.Draining() {
+		err = fmt.Errorf("server going away, not Invoke")
+		return
+	}
 	// 构造 CmdHeader.
-	seq := GetSeqID()
+	seq, err := tcc.seqs.Next(tcc.cryptType)
+	if err != nil {
+		return
+	}
 	cmd := packets.NewCmdPacket(tcc.messageType)
 	cmd.FixHeader.SetProtocol(packets.PROTOUDP)
 	cmd.CmdSeq = seq
 	cmd.CmdID = cmdid
 	cmd.EncType = tcc.cryptType
 	cmd.RPCType = packets.RPCREQ
+	cmd.Metadata = outgoingMD(ctx)
+	sp := tcc.startSendSpan(ctx, tcc.ClientConn, cmd)
+	defer func() {
+		endSendSpan(sp, err)
+	}()
 
-	if tcc.messageType == packets.TYPEPBBIN {
-		cmd.Payload, err = proto.Marshal(req.(proto.Message))
-	} else if tcc.messageType == packets.TYPEPBJSON {
-		cmd.Payload, err = proto.MarshalMessageSetJSON(req)
-	}
+	cmd.Payload, err = encodePayload(tcc.messageType, req)
 	if err != nil {
 		return
 	}
@@ -222,6 +296,18 @@ func (tcc *UDPCliConn) InvokeAsync(ctx context.Context, cmdid uint64, req interf
 	return
 }
 
+// NewStream 打开流式调用, ctx 结束时取消流.
+func (tcc *UDPCliConn) NewStream(ctx context.Context, cmdid uint64) (Stream, error) {
+	_s, _ := tcc.GetState()
+	if _s != ppudp.StateConnected {
+		return nil, status.Errorf(status.Unavailable, "connect status: %d, not NewStream", _s)
+	}
+	if tcc.seqs.Draining() {
+		return nil, status.Errorf(status.Unavailable, "server going away, not NewStream")
+	}
+	return openStream(ctx, tcc.ClientConn, tcc.seqs, cmdid, tcc.messageType, tcc.cryptType, packets.PROTOUDP)
+}
+
 // Close 关闭连接,退出重连
 func (tcc *UDPCliConn) Close() (err error) {
 	tcc.ctxCancel()
@@ -253,6 +339,7 @@ func (tcc *UDPCliConn) SetCrypt(v uint8) {
 }
 
 func (tcc *UDPCliConn) handlePacket(pkg packets.PPPacket) {
+	defer tcc.recoverPanic(tcc.ClientConn, pkg)
 	var err error
 	if tcc.PreHookCB != nil {
 		err = tcc.PreHookCB(pkg, tcc.ClientConn)
@@ -269,9 +356,18 @@ func (tcc *UDPCliConn) handlePacket(pkg packets.PPPacket) {
 		}
 	case *packets.CmdPacket:
 		cmd := pkg.(*packets.CmdPacket)
-		v, ok := tcc.asyncChans.Load(cmd.CmdSeq)
-		if ok {
-			v.(chan *packets.CmdPacket) <- cmd
+		if isGoAway(cmd) {
+			logs.Logger.Warnf("%s, server going away, disconnect after pending calls.", tcc.ClientConn)
+			tcc.seqs.Drain(func() { tcc.ClientConn.Close() })
+			return
+		}
+		// 只有响应交给等待的调用, 对端发起的请求(序列号由对端分配)交给 CmdCB
+		var v interface{}
+		if cmd.RPCType == packets.RPCRESP {
+			v, _ = tcc.asyncChans.Load(cmd.CmdSeq)
+		}
+		if ch, ok := v.(chan *packets.CmdPacket); ok {
+			ch <- cmd
 			tcc.asyncChans.Delete(cmd.CmdSeq)
 		} else {
 			if tcc.CmdCB != nil {
@@ -294,6 +390,13 @@ func (tcc *UDPCliConn) handlePacket(pkg packets.PPPacket) {
 		} else {
 			err = tcc.defavcb(av, tcc.ClientConn)
 		}
+	case *packets.FilePacket:
+		fp := pkg.(*packets.FilePacket)
+		if tcc.FileCB != nil {
+			err = tcc.FileCB(fp, tcc.ClientConn)
+		} else {
+			err = tcc.deffilecb(fp, tcc.ClientConn)
+		}
 	default:
 		err = errors.New("not support pkg.(type)")
 	}
@@ -312,51 +415,19 @@ func (tcc *UDPCliConn) decodeCmd(pkg *packets.CmdPacket, conn RPCConn) (dobj int
 	if v != nil {
 		pkg.CmdName = v.CmdName
 
-		dec := func(dobj interface{}) error {
-			if pkg.Code != 0 {
-				return nil
-			}
-			if pkg.MessageType == packets.TYPEPBBIN {
-				if err := proto.Unmarshal(pkg.Payload, dobj.(proto.Message)); err != nil {
-					err = fmt.Errorf("proto.Unmarshal error: %s", err)
-					return err
-				}
-			} else if pkg.MessageType == packets.TYPEPBJSON {
-				if err := proto.UnmarshalMessageSetJSON(pkg.Payload, dobj.(proto.Message)); err != nil {
-					err = fmt.Errorf("proto.UnmarshalMessageSetJSON error: %s", err)
-					return err
-				}
-			} else {
-				err := fmt.Errorf("CmdID: %d, Name: %s, MessageType: %d Not Support",
-					pkg.CmdID, pkg.CmdName, pkg.MessageType)
-				return err
-			}
-			return nil
-		}
-
-		if pkg.RPCType == packets.RPCREQ {
-			dobj, err = v.ReqHandler(v.Hanlder, conn, pkg, false, dec)
-		} else if pkg.RPCType == packets.RPCRESP {
-			dobj, err = v.RespHandler(v.Hanlder, conn, pkg, false, dec)
-		} else {
-			err = fmt.Errorf("CmdId: %d, Name: %s, pkg.RPCType: %d, not support",
-				v.CmdID, v.CmdName, pkg.RPCType)
-		}
+		dobj, err = tcc.callHandler(v, conn, pkg, false)
 		if err != nil {
 			err = fmt.Errorf("%s, CmdId: %d, Name: %s, call Handler error: %s",
 				conn.RemoteAddr(), v.CmdID, v.CmdName, err)
 		}
 
 	} else {
-		// 没有注册的命令
-		pkg.Code = CmdIDNotReg
+		// 没有注册的命令, 应答不再回复(避免双方循环应答)
 		if pkg.RPCType == packets.RPCREQ {
-			pkg.RPCType = packets.RPCRESP
-		}
-		pkg.Payload = []byte{}
-		_, err = pkg.Write(conn)
-		if err != nil {
-			logs.Logger.Errorf("%s, WritePkg error: %s.", conn.RemoteAddr(), err)
+			_, err = writeStatus(conn, pkg, status.Newf(status.Unimplemented, "cmdid: %d not register", pkg.CmdID))
+			if err != nil {
+				logs.Logger.Errorf("%s, WritePkg error: %s.", conn.RemoteAddr(), err)
+			}
 		}
 		err = fmt.Errorf("%s, not find cmdid: %d register", conn.RemoteAddr(), pkg.CmdID)
 	}
@@ -369,51 +440,19 @@ func (tcc *UDPCliConn) defcmdcb(pkg *packets.CmdPacket, conn RPCConn) error {
 	if v != nil {
 		pkg.CmdName = v.CmdName
 
-		dec := func(dobj interface{}) error {
-			if pkg.Code != 0 {
-				return nil
-			}
-			if pkg.MessageType == packets.TYPEPBBIN {
-				if err := proto.Unmarshal(pkg.Payload, dobj.(proto.Message)); err != nil {
-					err = fmt.Errorf("proto.Unmarshal error: %s", err)
-					return err
-				}
-			} else if pkg.MessageType == packets.TYPEPBJSON {
-				if err := proto.UnmarshalMessageSetJSON(pkg.Payload, dobj.(proto.Message)); err != nil {
-					err = fmt.Errorf("proto.UnmarshalMessageSetJSON error: %s", err)
-					return err
-				}
-			} else {
-				err := fmt.Errorf("CmdID: %d, Name: %s, MessageType: %d Not Support",
-					pkg.CmdID, pkg.CmdName, pkg.MessageType)
-				return err
-			}
-			return nil
-		}
-
-		if pkg.RPCType == packets.RPCREQ {
-			_, err = v.ReqHandler(v.Hanlder, conn, pkg, true, dec)
-		} else if pkg.RPCType == packets.RPCRESP {
-			_, err = v.RespHandler(v.Hanlder, conn, pkg, true, dec)
-		} else {
-			err = fmt.Errorf("CmdId: %d, Name: %s, pkg.RPCType: %d, not support",
-				v.CmdID, v.CmdName, pkg.RPCType)
-		}
+		_, err = tcc.callHandler(v, conn, pkg, true)
 		if err != nil {
 			err = fmt.Errorf("%s, CmdId: %d, Name: %s, call Handler error: %s",
 				conn.RemoteAddr(), v.CmdID, v.CmdName, err)
 		}
 
 	} else {
-		// 没有注册的命令
-		pkg.Code = CmdIDNotReg
+		// 没有注册的命令, 应答不再回复(避免双方循环应答)
 		if pkg.RPCType == packets.RPCREQ {
-			pkg.RPCType = packets.RPCRESP
-		}
-		pkg.Payload = []byte{}
-		_, err = pkg.Write(conn)
-		if err != nil {
-			logs.Logger.Errorf("%s, WritePkg error: %s.", conn.RemoteAddr(), err)
+			_, err = writeStatus(conn, pkg, status.Newf(status.Unimplemented, "cmdid: %d not register", pkg.CmdID))
+			if err != nil {
+				logs.Logger.Errorf("%s, WritePkg error: %s.", conn.RemoteAddr(), err)
+			}
 		}
 		err = fmt.Errorf("%s, not find cmdid: %d register", conn.RemoteAddr(), pkg.CmdID)
 	}
@@ -432,6 +471,12 @@ func (tcc *UDPCliConn) defavcb(pkg *packets.AVPacket, conn RPCConn) error {
 	return nil
 }
 
+func (tcc *UDPCliConn) deffilecb(pkg *packets.FilePacket, conn RPCConn) error {
+	logs.Logger.Debugf("%s, FilePacket not support, FileID: %d, Offset: %d, Payload Length: %d.",
+		conn.RemoteAddr(), pkg.FileID, pkg.Offset, len(pkg.RAWPayload))
+	return nil
+}
+
 /*
 
 go func() {
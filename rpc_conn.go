@@ -28,7 +28,7 @@ type RPCConn interface {
 	HandleClose() context.Context
 	String() string
 	SetAutoCrypt(bool)
-	Type() string // T=TCP； S=TLS； Q=QUIC; M = mqtt; U=UDP
+	Type() string // T=TCP； S=TLS； Q=QUIC; W=WebSocket; P=mem(进程内); M = mqtt; U=UDP
 }
 
 // RPCCliConn 定义RPC Client 连接.
@@ -49,9 +49,11 @@ type RPCCliConn interface {
 	LogPre() string
 	LogPreShort() string
 	String() string
-	Type() string // T=TCP； S=TLS； Q=QUIC; M = mqtt; U=UDP
+	Type() string // T=TCP； S=TLS； Q=QUIC; W=WebSocket; P=mem(进程内); M = mqtt; U=UDP
 	SetAutoHB(b bool)
 	// 增加两个方法调用
 	Invoke(ctx context.Context, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error)
 	InvokeAsync(ctx context.Context, cmdid uint64, req interface{}) (err error)
+	// 流式调用
+	NewStream(ctx context.Context, cmdid uint64) (Stream, error)
 }
@@ -0,0 +1,201 @@
+package pprpc
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pprpc/packets"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// KXConfig 密钥协商配置.
+// 连接建立后客户端发起 X25519 密钥协商, 双方得到的会话密钥代替 AESKEYPREFIX
+// 用于 CmdPacket/AVPacket/FilePacket 的加密.
+type KXConfig struct {
+	// Legacy 兼容旧版本, 使用固定前导密钥(AESKEYPREFIX).
+	// 客户端: 不发起密钥协商; 服务端: 同时接受协商和未协商的连接.
+	Legacy bool
+	// PSK 预共享密钥, 设置后双方必须一致才能完成协商
+	PSK []byte
+	// PrivateKey 服务端身份密钥, 设置后对协商过程签名
+	PrivateKey ed25519.PrivateKey
+	// PinnedKeys 客户端信任的服务端公钥, 不为空时要求服务端签名且公钥匹配
+	PinnedKeys []ed25519.PublicKey
+	// Timeout 等待对端协商报文的超时时间, 默认 10s
+	Timeout time.Duration
+}
+
+// defKXConfig 默认配置: 匿名密钥协商
+var defKXConfig = &KXConfig{}
+
+const kxLabel = "pprpc-kx-v1"
+
+func (cfg *KXConfig) timeout() time.Duration {
+	if cfg.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return cfg.Timeout
+}
+
+// kxConn 可以进行密钥协商的连接
+type kxConn interface {
+	io.Writer
+	SetSessionKey([]byte)
+}
+
+// newKXPacket 生成临时密钥和协商报文, 返回私钥.
+func newKXPacket(protoType uint8) (priv []byte, kx *packets.KXPacket, err error) {
+	priv = make([]byte, curve25519.ScalarSize)
+	if _, err = rand.Read(priv); err != nil {
+		return
+	}
+	kx = packets.NewKXPacket()
+	kx.FixHeader.SetProtocol(protoType)
+	kx.PublicKey, err = curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return
+	}
+	kx.Nonce = make([]byte, packets.KXNONCESIZE)
+	_, err = rand.Read(kx.Nonce)
+	return
+}
+
+// kxTranscript 协商过程摘要, 用于签名、MAC和密钥派生.
+func kxTranscript(cli, srv *packets.KXPacket) []byte {
+	h := sha256.New()
+	h.Write([]byte(kxLabel))
+	h.Write(cli.PublicKey)
+	h.Write(cli.Nonce)
+	h.Write(srv.PublicKey)
+	h.Write(srv.Nonce)
+	return h.Sum(nil)
+}
+
+func kxMAC(psk []byte, role string, data ...[]byte) []byte {
+	mac := hmac.New(sha256.New, psk)
+	mac.Write([]byte(kxLabel))
+	mac.Write([]byte(role))
+	for _, v := range data {
+		mac.Write(v)
+	}
+	return mac.Sum(nil)
+}
+
+// kxSessionKey HKDF-SHA256(ECDH, salt: PSK, info: transcript)
+func kxSessionKey(priv, peerPub, psk, transcript []byte) ([]byte, error) {
+	shared, err := curve25519.X25519(priv, peerPub)
+	if err != nil {
+		return nil, fmt.Errorf("X25519, %s", err)
+	}
+	key := make([]byte, 32)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, shared, psk, transcript), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// clientKX 客户端发起密钥协商, 成功后设置连接的会话密钥.
+func clientKX(conn kxConn, cfg *KXConfig, protoType uint8, read func() (packets.PPPacket, error)) error {
+	priv, hello, err := newKXPacket(protoType)
+	if err != nil {
+		return err
+	}
+	if len(cfg.PSK) > 0 {
+		hello.MAC = kxMAC(cfg.PSK, "client", hello.PublicKey, hello.Nonce)
+	}
+	if _, err = hello.Write(conn); err != nil {
+		return fmt.Errorf("write KXPacket, %s", err)
+	}
+
+	pkg, err := read()
+	if err != nil {
+		return fmt.Errorf("read KXPacket, %s", err)
+	}
+	reply, ok := pkg.(*packets.KXPacket)
+	if !ok {
+		return fmt.Errorf("KX reply, unexpected packet: %s", pkg)
+	}
+
+	transcript := kxTranscript(hello, reply)
+	if len(cfg.PSK) > 0 {
+		if !hmac.Equal(reply.MAC, kxMAC(cfg.PSK, "server", transcript)) {
+			return fmt.Errorf("KX reply, PSK MAC not match")
+		}
+	}
+	if len(cfg.PinnedKeys) > 0 {
+		if len(reply.Signature) == 0 {
+			return fmt.Errorf("KX reply, server not signed")
+		}
+		pinned := false
+		for _, pub := range cfg.PinnedKeys {
+			if ed25519.Verify(pub, transcript, reply.Signature) {
+				pinned = true
+				break
+			}
+		}
+		if !pinned {
+			return fmt.Errorf("KX reply, server key not pinned")
+		}
+	}
+
+	key, err := kxSessionKey(priv, reply.PublicKey, cfg.PSK, transcript)
+	if err != nil {
+		return err
+	}
+	conn.SetSessionKey(key)
+	return nil
+}
+
+// acceptKX 服务端处理连接上的第一个报文.
+// 客户端发起协商时回应并设置会话密钥, 返回 nil;
+// 未协商的连接在 cfg.Legacy 时返回该报文由调用方继续处理, 否则返回错误.
+func acceptKX(conn kxConn, cfg *KXConfig, protoType uint8, read func() (packets.PPPacket, error)) (first packets.PPPacket, err error) {
+	pkg, err := read()
+	if err != nil {
+		return
+	}
+	hello, ok := pkg.(*packets.KXPacket)
+	if !ok {
+		if cfg.Legacy {
+			first = pkg
+			return
+		}
+		err = fmt.Errorf("KX required, first packet: %s", pkg)
+		return
+	}
+	if len(cfg.PSK) > 0 {
+		if !hmac.Equal(hello.MAC, kxMAC(cfg.PSK, "client", hello.PublicKey, hello.Nonce)) {
+			err = fmt.Errorf("KX hello, PSK MAC not match")
+			return
+		}
+	}
+
+	priv, reply, err := newKXPacket(protoType)
+	if err != nil {
+		return
+	}
+	transcript := kxTranscript(hello, reply)
+	if len(cfg.PSK) > 0 {
+		reply.MAC = kxMAC(cfg.PSK, "server", transcript)
+	}
+	if len(cfg.PrivateKey) > 0 {
+		reply.Signature = ed25519.Sign(cfg.PrivateKey, transcript)
+	}
+	key, err := kxSessionKey(priv, hello.PublicKey, cfg.PSK, transcript)
+	if err != nil {
+		return
+	}
+	// 在回应之前设置: 客户端收到回应后即可使用会话密钥, 其他协程可能马上向该连接发送报文
+	conn.SetSessionKey(key)
+	if _, err = reply.Write(conn); err != nil {
+		err = fmt.Errorf("write KXPacket, %s", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package pprpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pprpc/packets"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestKX(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	psk := []byte("test-psk")
+	srvKX := &KXConfig{PSK: psk, PrivateKey: priv}
+
+	h := newTestHandler()
+	srv := newMemServer(t, h.service(), srvKX)
+	cli := dialMem(t, srv.url, h.service(), &KXConfig{PSK: psk, PinnedKeys: []ed25519.PublicKey{other, pub}})
+	sc := <-srv.conns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, crypt := range []uint8{packets.AES128GCM, packets.AES256GCM, packets.CHACHA20POLY1305} {
+		cli.SetCrypt(crypt)
+		_, resp, err := cli.Invoke(ctx, testCmdEcho, wrapperspb.String("kx"))
+		if v := respValue(t, resp, err); v != "echo:kx" {
+			t.Fatalf("crypt: %d, resp: %q", crypt, v)
+		}
+	}
+	key := cli.ClientConn.SessionKey()
+	if len(key) == 0 || !bytes.Equal(key, sc.SessionKey()) {
+		t.Fatalf("session key, client: %x, server: %x", key, sc.SessionKey())
+	}
+
+	// 协商失败时 Dail 返回错误
+	for name, kx := range map[string]*KXConfig{
+		"psk":    {PSK: []byte("wrong-psk")},
+		"pinned": {PSK: psk, PinnedKeys: []ed25519.PublicKey{other}},
+		"legacy": {Legacy: true},
+	} {
+		c, err := DailKX(srv.url, nil, h.service(), time.Second, kx, nil)
+		if name == "legacy" && err == nil {
+			// 未协商的连接由服务端在收到第一个报文后关闭
+			lctx, lcancel := context.WithTimeout(ctx, 300*time.Millisecond)
+			_, _, err = c.Invoke(lctx, testCmdEcho, wrapperspb.String("legacy"))
+			lcancel()
+		}
+		c.Close()
+		if err == nil {
+			t.Fatalf("%s: KX mismatch accepted", name)
+		}
+	}
+}
+
+func TestKXLegacy(t *testing.T) {
+	h := newTestHandler()
+	srv := newMemServer(t, h.service(), &KXConfig{Legacy: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	// Legacy 服务端同时接受协商和未协商的连接
+	for _, kx := range []*KXConfig{nil, {Legacy: true}} {
+		cli := dialMem(t, srv.url, h.service(), kx)
+		cli.SetCrypt(packets.AES256CFB)
+		_, resp, err := cli.Invoke(ctx, testCmdEcho, wrapperspb.String("legacy"))
+		if v := respValue(t, resp, err); v != "echo:legacy" {
+			t.Fatalf("resp: %q", v)
+		}
+		if (kx == nil) != (len(cli.ClientConn.SessionKey()) > 0) {
+			t.Fatalf("kx: %v, session key: %x", kx, cli.ClientConn.SessionKey())
+		}
+		// AEAD 加密类型需要会话密钥
+		cli.SetCrypt(packets.AES256GCM)
+		_, resp, err = cli.Invoke(ctx, testCmdEcho, wrapperspb.String("aead"))
+		if kx == nil {
+			if v := respValue(t, resp, err); v != "echo:aead" {
+				t.Fatalf("resp: %q", v)
+			}
+		} else if !errors.Is(err, packets.ErrSessionKeyRequired) {
+			t.Fatalf("legacy AEAD, err: %v", err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package pprpc
+
+import (
+	"context"
+
+	"github.com/pprpc/packets"
+)
+
+// MD 调用元数据(鉴权令牌, 跟踪ID, 租户ID, 语言等), 通过 CmdPacket 扩展段传输.
+// 客户端通过 NewOutgoingContext 发送, Invoke 返回的 pkg.Metadata 为服务端 SetTrailer 设置的应答元数据.
+type MD map[string]string
+
+// Pairs 由 k1, v1, k2, v2... 创建 MD, 个数为奇数时忽略最后一个.
+func Pairs(kv ...string) MD {
+	md := make(MD, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		md[kv[i]] = kv[i+1]
+	}
+	return md
+}
+
+// Copy 复制 MD
+func (md MD) Copy() MD {
+	out := make(MD, len(md))
+	for k, v := range md {
+		out[k] = v
+	}
+	return out
+}
+
+type outgoingMDKey struct{}
+type incomingMDKey struct{}
+
+// NewOutgoingContext 客户端: 附加请求元数据, Invoke/NewStream 时发送.
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, outgoingMDKey{}, md)
+}
+
+// AppendToOutgoingContext 客户端: 在已有的请求元数据上追加键值对.
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md, _ := FromOutgoingContext(ctx)
+	md = md.Copy()
+	for k, v := range Pairs(kv...) {
+		md[k] = v
+	}
+	return NewOutgoingContext(ctx, md)
+}
+
+// FromOutgoingContext 获取将要发送的请求元数据.
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(outgoingMDKey{}).(MD)
+	return md, ok
+}
+
+// FromIncomingContext 服务端: 获取对端发送的请求元数据, ctx 为 CallContext 或 Stream.Context.
+func FromIncomingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(incomingMDKey{}).(MD)
+	return md, ok
+}
+
+// SetTrailer 服务端: 设置应答元数据, ctx 为 CallContext; WriteResp 时发送.
+func SetTrailer(ctx context.Context, md MD) bool {
+	cs, ok := ctx.Value(callStateKey{}).(*callState)
+	if !ok {
+		return false
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.trailer == nil {
+		cs.trailer = make(MD, len(md))
+	}
+	for k, v := range md {
+		cs.trailer[k] = v
+	}
+	return true
+}
+
+// withIncomingMD 为请求的 context 附加对端元数据.
+func withIncomingMD(ctx context.Context, pkg *packets.CmdPacket) context.Context {
+	if len(pkg.Metadata) > 0 {
+		ctx = context.WithValue(ctx, incomingMDKey{}, MD(pkg.Metadata))
+	}
+	return ctx
+}
+
+// outgoingMD 请求需要发送的元数据
+func outgoingMD(ctx context.Context) map[string]string {
+	md, _ := FromOutgoingContext(ctx)
+	return md
+}
+
+// trailerMD 应答需要发送的元数据(请求报文复用为应答时替换请求的元数据)
+func trailerMD(pkg *packets.CmdPacket) map[string]string {
+	cs := getCallState(pkg)
+	if cs == nil {
+		return nil
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.trailer.Copy()
+}
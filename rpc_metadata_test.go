@@ -0,0 +1,117 @@
+package pprpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pprpc/packets"
+	"github.com/pprpc/pptcp"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const testCmdMD uint64 = 14 // 应答 请求:是否收到元数据, 应答元数据 x-echo 为请求的 authorization
+
+func mdService() *Service {
+	s := NewService()
+	s.RegisterService(&ServiceDesc{
+		CmdID:   testCmdMD,
+		CmdName: "MD",
+		ReqHandler: func(srv interface{}, c RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+			in := new(wrapperspb.StringValue)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if !isCall {
+				return in, nil
+			}
+			ctx := CallContext(pkg)
+			md, ok := FromIncomingContext(ctx)
+			if ok {
+				SetTrailer(ctx, MD{"x-echo": md["authorization"]})
+			}
+			out := wrapperspb.String(fmt.Sprintf("%s:%t", in.Value, ok))
+			_, err := WriteResp(c, pkg, out)
+			return out, err
+		},
+		RespHandler: func(srv interface{}, c RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+			out := new(wrapperspb.StringValue)
+			err := dec(out)
+			return out, err
+		},
+	}, nil)
+	return s
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	srv := newMemServer(t, mdService(), nil)
+	cli := dialMem(t, srv.url, mdService(), nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// 加密的报文元数据随载荷加密
+	for _, encType := range []uint8{packets.AESNONE, packets.AES256CFB, packets.AES256GCM} {
+		cli.SetCrypt(encType)
+		mdCtx := AppendToOutgoingContext(ctx, "authorization", "token", "x-tenant", "t1")
+		pkg, resp, err := cli.Invoke(mdCtx, testCmdMD, wrapperspb.String("md"))
+		if v := respValue(t, resp, err); v != "md:true" {
+			t.Fatalf("EncType: %d, resp: %q", encType, v)
+		}
+		if pkg.Metadata["x-echo"] != "token" || len(pkg.Metadata) != 1 {
+			t.Fatalf("EncType: %d, trailer: %v", encType, pkg.Metadata)
+		}
+
+		// 没有元数据
+		pkg, resp, err = cli.Invoke(ctx, testCmdMD, wrapperspb.String("none"))
+		if v := respValue(t, resp, err); v != "none:false" {
+			t.Fatalf("EncType: %d, resp: %q", encType, v)
+		}
+		if len(pkg.Metadata) != 0 {
+			t.Fatalf("EncType: %d, trailer: %v", encType, pkg.Metadata)
+		}
+	}
+}
+
+// cmdFlag 报文标志字节(EncType<<2 | RPCType, 最高位表示扩展段)
+func cmdFlag(cmd *packets.CmdPacket) byte {
+	return cmd.VarHeader[len(proto.EncodeVarint(cmd.CmdSeq))+len(proto.EncodeVarint(cmd.CmdID))]
+}
+
+func TestMetadataNoExt(t *testing.T) {
+	// 旧版本的对端: 不进行密钥协商, 请求不包含扩展段
+	srv := newMemServer(t, mdService(), &KXConfig{Legacy: true})
+	cc := pptcp.NewClientConn(srv.url, nil, time.Second)
+	if err := cc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Disconnect()
+
+	req := packets.NewCmdPacket(packets.TYPEPBBIN)
+	req.CmdSeq = 1
+	req.CmdID = testCmdMD
+	req.EncType = packets.AESNONE
+	req.RPCType = packets.RPCREQ
+	req.Payload, _ = proto.Marshal(wrapperspb.String("old"))
+	if _, err := req.Write(cc); err != nil {
+		t.Fatal(err)
+	}
+	if cmdFlag(req)&0x80 != 0 {
+		t.Fatalf("request flag: %#x", cmdFlag(req))
+	}
+
+	pp, err := packets.ReadTCPPacketAdv(cc, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := pp.(*packets.CmdPacket)
+	out := new(wrapperspb.StringValue)
+	if err = proto.Unmarshal(resp.Payload, out); err != nil || out.Value != "old:false" {
+		t.Fatalf("resp: %q, %v", out.Value, err)
+	}
+	// 没有应答元数据时应答也不包含扩展段
+	if cmdFlag(resp)&0x80 != 0 || len(resp.Metadata) != 0 {
+		t.Fatalf("response flag: %#x, metadata: %v", cmdFlag(resp), resp.Metadata)
+	}
+}
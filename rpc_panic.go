@@ -0,0 +1,46 @@
+package pprpc
+
+import (
+	"runtime/debug"
+
+	"github.com/pprpc/packets"
+	"github.com/pprpc/status"
+	"github.com/pprpc/util/logs"
+)
+
+// PanicCallBack 处理报文时发生 panic 的回调, v 为 recover() 的返回值, stack 为 panic 时的调用栈.
+type PanicCallBack func(conn RPCConn, pkg packets.PPPacket, v interface{}, stack []byte)
+
+// errHandlerPanic 处理函数 panic 时返回给对端的错误; panic 的值和调用栈由 reportPanic 记录, 不发送给对端.
+var errHandlerPanic = status.Error(status.Internal, internalMsg)
+
+// SetPanicCB 设置 panic 回调, 未设置时记录错误日志; 需在服务启动前调用.
+func (s *Service) SetPanicCB(cb PanicCallBack) {
+	s.panicCB = cb
+}
+
+// reportPanic 报告 panic 和调用栈.
+func (s *Service) reportPanic(conn RPCConn, pkg packets.PPPacket, v interface{}) {
+	stack := debug.Stack()
+	if s != nil && s.panicCB != nil {
+		s.panicCB(conn, pkg, v, stack)
+		return
+	}
+	logs.Logger.Errorf("%s, handle packet panic: %v\n%s", conn, v, stack)
+}
+
+// recoverPanic 恢复报文处理中的 panic(需直接 defer 调用), 请求没有应答时写入错误应答.
+func (s *Service) recoverPanic(conn RPCConn, pkg packets.PPPacket) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	s.reportPanic(conn, pkg, v)
+	cmd, ok := pkg.(*packets.CmdPacket)
+	if !ok || cmd.RPCType != packets.RPCREQ || markResponded(cmd) {
+		return
+	}
+	if _, err := writeStatus(conn, cmd, status.Convert(errHandlerPanic)); err != nil {
+		logs.Logger.Errorf("%s, CmdID: %d, CmdSeq: %d, write status error: %s.", conn, cmd.CmdID, cmd.CmdSeq, err)
+	}
+}
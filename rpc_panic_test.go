@@ -0,0 +1,83 @@
+package pprpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pprpc/packets"
+	"github.com/pprpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	testCmdPanic uint64 = 15 // panic, 值为请求
+	testCmdError uint64 = 16 // 返回普通 error, 信息为请求
+)
+
+func panicService(h *testHandler) *Service {
+	s := h.service()
+	s.RegisterService(&ServiceDesc{
+		CmdID:   testCmdPanic,
+		CmdName: "Panic",
+		ReqHandler: func(srv interface{}, c RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+			in := new(wrapperspb.StringValue)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			panic(in.Value)
+		},
+	}, nil)
+	s.RegisterService(&ServiceDesc{
+		CmdID:   testCmdError,
+		CmdName: "Error",
+		ReqHandler: func(srv interface{}, c RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+			in := new(wrapperspb.StringValue)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			return nil, errors.New(in.Value)
+		},
+	}, nil)
+	return s
+}
+
+func TestHandlerPanic(t *testing.T) {
+	panics := make(chan interface{}, 1)
+	s := panicService(newTestHandler())
+	s.SetPanicCB(func(conn RPCConn, pkg packets.PPPacket, v interface{}, stack []byte) {
+		panics <- v
+	})
+	srv := newMemServer(t, s, nil)
+	cli := dialMem(t, srv.url, panicService(newTestHandler()), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// panic 的值只交给服务端的回调, 对端收到 Internal 和通用的错误信息
+	_, _, err := cli.Invoke(ctx, testCmdPanic, wrapperspb.String("secret"))
+	if st, _ := status.FromError(err); st.Code != status.Internal || st.Message != internalMsg {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case v := <-panics:
+		if v != "secret" {
+			t.Fatalf("panic value: %v", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic callback not called")
+	}
+
+	// 普通 error 的信息不发送给对端
+	_, _, err = cli.Invoke(ctx, testCmdError, wrapperspb.String("secret"))
+	if st, _ := status.FromError(err); st.Code != status.Unknown || st.Message != internalMsg {
+		t.Fatalf("err: %v", err)
+	}
+
+	// 连接继续可用
+	_, resp, err := cli.Invoke(ctx, testCmdEcho, wrapperspb.String("alive"))
+	if v := respValue(t, resp, err); v != "echo:alive" {
+		t.Fatalf("resp: %q", v)
+	}
+}
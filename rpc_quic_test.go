@@ -0,0 +1,96 @@
+package pprpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pprpc/pptcp"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// testTLSConfig 自签名证书(127.0.0.1), 返回服务端和客户端配置.
+func testTLSConfig(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		&tls.Config{RootCAs: pool}
+}
+
+func TestQUICLoopback(t *testing.T) {
+	srvTLS, cliTLS := testTLSConfig(t)
+	u, _ := url.Parse("quic://127.0.0.1:0")
+	srv, err := NewRPCTCPServer(u, srvTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Service = newTestHandler().service()
+	srv.RunGO = true
+	conns := make(chan *pptcp.Connection, 4)
+	srv.ConnectCB = func(c *pptcp.Connection) { conns <- c }
+	go srv.Serve()
+	t.Cleanup(srv.Stop)
+
+	u.Host = srv.Addr().String()
+	cli, err := Dail(u, cliTLS, newTestHandler().service(), time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, resp, err := cli.Invoke(ctx, testCmdEcho, wrapperspb.String("quic"))
+	if v := respValue(t, resp, err); v != "echo:quic" {
+		t.Fatalf("resp: %q", v)
+	}
+
+	// 连接名称不包含当前的对端地址(地址迁移后改变)
+	var sc *pptcp.Connection
+	select {
+	case sc = <-conns:
+	case <-time.After(time.Second):
+		t.Fatal("no server connection")
+	}
+	if name := sc.String(); !strings.HasPrefix(name, "Q-") || strings.HasSuffix(name, sc.RemoteAddr().String()) {
+		t.Fatalf("String: %s", name)
+	}
+
+	// 客户端关闭会话, 服务端的连接结束
+	cli.Close()
+	select {
+	case <-sc.Ctx.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("server connection not closed")
+	}
+}
@@ -1,25 +1,70 @@
 package pprpc
 
 import (
+	"context"
+	"fmt"
 	"sync"
+	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/pprpc/metrics"
 	"github.com/pprpc/packets"
+	"github.com/pprpc/status"
+	"github.com/pprpc/trace"
 )
 
 type cmdHandler func(interface{}, RPCConn, *packets.CmdPacket, bool, func(interface{}) error) (interface{}, error)
 
+// CallInfo 拦截器可访问的调用信息
+type CallInfo struct {
+	Conn   RPCConn
+	Pkg    *packets.CmdPacket
+	Desc   *ServiceDesc
+	IsCall bool            // true: 执行业务处理; false: 仅解码(客户端同步调用的应答)
+	Req    interface{}     // 解码后的请求(应答)对象, Handler 调用 dec 之后有效
+	Ctx    context.Context // 请求的 context, 参见 CallContext
+}
+
+// UnaryHandler 拦截器链中的下一个处理
+type UnaryHandler func(ci *CallInfo) (interface{}, error)
+
+// UnaryInterceptor 拦截器, 调用 next 继续执行后续拦截器和 ServiceDesc 的处理函数,
+// 返回值为处理函数返回的应答和错误.
+type UnaryInterceptor func(ci *CallInfo, next UnaryHandler) (interface{}, error)
+
+type streamHandler func(srv interface{}, conn RPCConn, s Stream) error
+
+// StreamInfo 流拦截器可访问的调用信息
+type StreamInfo struct {
+	Conn   RPCConn
+	Pkg    *packets.CmdPacket // 打开流的报文(CTRLOPEN)
+	Desc   *ServiceDesc
+	Stream Stream
+}
+
+// StreamHandler 流拦截器链中的下一个处理
+type StreamHandler func(si *StreamInfo) error
+
+// StreamInterceptor 流拦截器, 调用 next 继续执行后续拦截器和 ServiceDesc 的 StreamHandler.
+type StreamInterceptor func(si *StreamInfo, next StreamHandler) error
+
 // ServiceDesc 存放接口调用描述
 type ServiceDesc struct {
 	CmdID       uint64
 	CmdName     string
 	ReqHandler  cmdHandler
 	RespHandler cmdHandler
-	Hanlder     interface{}
+	// StreamHandler 流式调用的处理函数, 为 nil 时不支持流式调用
+	StreamHandler streamHandler
+	Hanlder       interface{}
 }
 
 // Service 定义支持的服务
 type Service struct {
-	cmds *sync.Map
+	cmds               *sync.Map
+	interceptors       []UnaryInterceptor
+	streamInterceptors []StreamInterceptor
+	panicCB            PanicCallBack
 }
 
 // NewService 创建服务
@@ -35,6 +80,16 @@ func (s *Service) RegisterService(d *ServiceDesc, h interface{}) {
 	s.cmds.Store(d.CmdID, d)
 }
 
+// UseInterceptor 添加拦截器, 按添加顺序由外向内执行; 需在服务启动前调用.
+func (s *Service) UseInterceptor(ics ...UnaryInterceptor) {
+	s.interceptors = append(s.interceptors, ics...)
+}
+
+// UseStreamInterceptor 添加流拦截器, 按添加顺序由外向内执行; 需在服务启动前调用.
+func (s *Service) UseStreamInterceptor(ics ...StreamInterceptor) {
+	s.streamInterceptors = append(s.streamInterceptors, ics...)
+}
+
 // UnRegService 取消注册服务
 func (s *Service) UnRegService(id uint64) {
 	s.cmds.Delete(id)
@@ -58,3 +113,148 @@ func (s *Service) GetService(id uint64) *ServiceDesc {
 func (s *Service) GetAllService() []*ServiceDesc {
 	return nil
 }
+
+// callHandler 经过拦截器链执行 ServiceDesc 的 ReqHandler/RespHandler.
+func (s *Service) callHandler(v *ServiceDesc, conn RPCConn, pkg *packets.CmdPacket, isCall bool) (interface{}, error) {
+	h := func(ci *CallInfo) (interface{}, error) {
+		dec := func(dobj interface{}) error {
+			err := decodePayload(ci.Pkg, dobj)
+			ci.Req = dobj
+			return err
+		}
+		if ci.Pkg.RPCType == packets.RPCREQ && ci.Desc.ReqHandler != nil {
+			return ci.Desc.ReqHandler(ci.Desc.Hanlder, ci.Conn, ci.Pkg, ci.IsCall, dec)
+		} else if ci.Pkg.RPCType == packets.RPCRESP && ci.Desc.RespHandler != nil {
+			return ci.Desc.RespHandler(ci.Desc.Hanlder, ci.Conn, ci.Pkg, ci.IsCall, dec)
+		}
+		return nil, status.Errorf(status.Unimplemented, "CmdId: %d, Name: %s, pkg.RPCType: %d, not support",
+			ci.Desc.CmdID, ci.Desc.CmdName, ci.Pkg.RPCType)
+	}
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		ic, next := s.interceptors[i], h
+		h = func(ci *CallInfo) (interface{}, error) {
+			return ic(ci, next)
+		}
+	}
+
+	ctx := context.Background()
+	isReq := isCall && pkg.RPCType == packets.RPCREQ
+	if isReq {
+		var done context.CancelFunc
+		ctx, done = s.beginCall(conn, pkg)
+		defer done()
+	}
+	start := time.Now()
+	resp, err := s.safeCall(h, &CallInfo{Conn: conn, Pkg: pkg, Desc: v, IsCall: isCall, Ctx: ctx})
+	if err != nil && isReq && !markResponded(pkg) {
+		// 处理函数返回错误(或 panic)且没有应答, 转换为错误应答
+		if _, e := writeStatus(conn, pkg, errStatus(conn, pkg, err)); e != nil {
+			err = fmt.Errorf("%s, write status: %s", err, e)
+		}
+	}
+	if isReq {
+		// 应答的 Code 或者处理函数返回的错误
+		code := pkg.Code
+		if err != nil {
+			code = uint64(status.CodeOf(err))
+		}
+		s.observeCall(metrics.SideServer, v.CmdID, start, code)
+		endSpan(trace.SpanFromContext(ctx), code, err)
+	}
+	return resp, err
+}
+
+// callStreamHandler 经过流拦截器链执行 ServiceDesc 的 StreamHandler.
+func (s *Service) callStreamHandler(v *ServiceDesc, conn RPCConn, pkg *packets.CmdPacket, st Stream) (err error) {
+	h := func(si *StreamInfo) error {
+		return si.Desc.StreamHandler(si.Desc.Hanlder, si.Conn, si.Stream)
+	}
+	for i := len(s.streamInterceptors) - 1; i >= 0; i-- {
+		ic, next := s.streamInterceptors[i], h
+		h = func(si *StreamInfo) error {
+			return ic(si, next)
+		}
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			s.reportPanic(conn, pkg, v)
+			err = errHandlerPanic
+		}
+	}()
+	return h(&StreamInfo{Conn: conn, Pkg: pkg, Desc: v, Stream: st})
+}
+
+// safeCall 执行处理函数, panic 转换为 status.Internal 错误.
+func (s *Service) safeCall(h UnaryHandler, ci *CallInfo) (resp interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			s.reportPanic(ci.Conn, ci.Pkg, v)
+			resp, err = nil, errHandlerPanic
+		}
+	}()
+	return h(ci)
+}
+
+// observeCall 记录调用次数, 耗时和状态码.
+func (s *Service) observeCall(side string, cmdid uint64, start time.Time, code uint64) {
+	metrics.Get().ObserveCall(side, cmdid, s.cmdName(cmdid), code, time.Since(start))
+}
+
+func init() {
+	packets.SetStatsHook(countPacket)
+}
+
+// countPacket 报文收发的字节数交给 metrics 的采集器(packets.StatsHook).
+func countPacket(out bool, t uint8, n int64) {
+	dir := metrics.DirIn
+	if out {
+		dir = metrics.DirOut
+	}
+	metrics.Get().AddBytes(dir, packets.TypeName(t), n)
+}
+
+// cmdName 命令ID对应的名称, 没有注册返回空.
+func (s *Service) cmdName(cmdid uint64) string {
+	if s != nil {
+		if v := s.GetService(cmdid); v != nil {
+			return v.CmdName
+		}
+	}
+	return ""
+}
+
+// encodePayload 按 MessageType 编码 Payload.
+func encodePayload(mt uint8, m interface{}) (b []byte, err error) {
+	if mt == packets.TYPEPBBIN {
+		b, err = proto.Marshal(m.(proto.Message))
+	} else if mt == packets.TYPEPBJSON {
+		b, err = proto.MarshalMessageSetJSON(m)
+	} else {
+		err = fmt.Errorf("not support MessageType: %d", mt)
+	}
+	return
+}
+
+// decodePayload 按 MessageType 解码 Payload.
+func decodePayload(pkg *packets.CmdPacket, dobj interface{}) error {
+	if pkg.Code != 0 {
+		return nil
+	}
+	if pkg.MessageType == packets.TYPEPBBIN {
+		if err := proto.Unmarshal(pkg.Payload, dobj.(proto.Message)); err != nil {
+			err = fmt.Errorf("proto.Unmarshal error: %s", err)
+			return err
+		}
+	} else if pkg.MessageType == packets.TYPEPBJSON {
+		if err := proto.UnmarshalMessageSetJSON(pkg.Payload, dobj.(proto.Message)); err != nil {
+			err = fmt.Errorf("proto.UnmarshalMessageSetJSON error: %s", err)
+			return err
+		}
+	} else {
+		err := fmt.Errorf("CmdID: %d, Name: %s, MessageType: %d Not Support",
+			pkg.CmdID, pkg.CmdName, pkg.MessageType)
+		return err
+	}
+	return nil
+}
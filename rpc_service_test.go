@@ -0,0 +1,205 @@
+package pprpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pprpc/packets"
+	"github.com/pprpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const testCmdFail uint64 = 13 // 返回 status.NotFound
+
+// recorder 记录拦截器的执行顺序.
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) add(s string) {
+	r.mu.Lock()
+	r.calls = append(r.calls, s)
+	r.mu.Unlock()
+}
+
+// wait 等待记录 n 条后返回; 服务端在处理函数中写入应答, 拦截器的后续部分可能在客户端收到应答之后执行.
+func (r *recorder) wait(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		r.mu.Lock()
+		calls := append([]string(nil), r.calls...)
+		r.mu.Unlock()
+		if len(calls) >= n || time.Now().After(deadline) {
+			return calls
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (r *recorder) reset() {
+	r.mu.Lock()
+	r.calls = nil
+	r.mu.Unlock()
+}
+
+// unary 记录进入和返回(状态码)的拦截器.
+func (r *recorder) unary(name string) UnaryInterceptor {
+	return func(ci *CallInfo, next UnaryHandler) (interface{}, error) {
+		r.add(name + ">")
+		resp, err := next(ci)
+		r.add(fmt.Sprintf("<%s:%s", name, status.CodeOf(err)))
+		return resp, err
+	}
+}
+
+func (r *recorder) stream(name string) StreamInterceptor {
+	return func(si *StreamInfo, next StreamHandler) error {
+		r.add(name + ">")
+		err := next(si)
+		r.add(fmt.Sprintf("<%s:%s", name, status.CodeOf(err)))
+		return err
+	}
+}
+
+// authInterceptor 请求没有携带 x-token 时不执行处理函数.
+func authInterceptor(ci *CallInfo, next UnaryHandler) (interface{}, error) {
+	if ci.IsCall && ci.Pkg.RPCType == packets.RPCREQ {
+		if md, ok := FromIncomingContext(ci.Ctx); !ok || md["x-token"] != "ok" {
+			return nil, status.Error(status.Unauthenticated, "missing token")
+		}
+	}
+	return next(ci)
+}
+
+func interceptorService(h *testHandler) *Service {
+	s := h.service()
+	s.RegisterService(&ServiceDesc{
+		CmdID:   testCmdFail,
+		CmdName: "Fail",
+		ReqHandler: func(srv interface{}, c RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+			in := new(wrapperspb.StringValue)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			return nil, status.Error(status.NotFound, "not found: "+in.Value)
+		},
+	}, nil)
+	return s
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	rec := new(recorder)
+	h := newTestHandler()
+	s := interceptorService(h)
+	s.UseInterceptor(rec.unary("a"), rec.unary("b"), authInterceptor)
+	srv := newMemServer(t, s, nil)
+
+	// 客户端的拦截器在解码应答时执行
+	cliRec := new(recorder)
+	cs := interceptorService(newTestHandler())
+	cs.UseInterceptor(func(ci *CallInfo, next UnaryHandler) (interface{}, error) {
+		resp, err := next(ci)
+		cliRec.add(fmt.Sprintf("%t:%v", ci.IsCall, ci.Req))
+		return resp, err
+	})
+	cli := dialMem(t, srv.url, cs, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	authed := AppendToOutgoingContext(ctx, "x-token", "ok")
+
+	// 按添加顺序由外向内执行
+	_, resp, err := cli.Invoke(authed, testCmdEcho, wrapperspb.String("order"))
+	if v := respValue(t, resp, err); v != "echo:order" {
+		t.Fatalf("resp: %q", v)
+	}
+	want := []string{"a>", "b>", "<b:OK", "<a:OK"}
+	if calls := rec.wait(t, len(want)); !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls: %v, want: %v", calls, want)
+	}
+	if calls := cliRec.wait(t, 1); len(calls) != 1 || calls[0] != "false:"+fmt.Sprint(resp) {
+		t.Fatalf("client calls: %v", calls)
+	}
+	<-h.started
+
+	// 拦截器不调用 next: 不执行处理函数, 返回的错误作为应答
+	rec.reset()
+	_, _, err = cli.Invoke(ctx, testCmdEcho, wrapperspb.String("denied"))
+	if status.CodeOf(err) != status.Unauthenticated {
+		t.Fatalf("err: %v", err)
+	}
+	want = []string{"a>", "b>", "<b:Unauthenticated", "<a:Unauthenticated"}
+	if calls := rec.wait(t, len(want)); !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls: %v, want: %v", calls, want)
+	}
+	select {
+	case v := <-h.started:
+		t.Fatalf("handler called: %q", v)
+	default:
+	}
+
+	// 处理函数返回的错误经过拦截器返回给客户端
+	rec.reset()
+	_, _, err = cli.Invoke(authed, testCmdFail, wrapperspb.String("x"))
+	if st, _ := status.FromError(err); st.Code != status.NotFound || st.Message != "not found: x" {
+		t.Fatalf("err: %v", err)
+	}
+	want = []string{"a>", "b>", "<b:NotFound", "<a:NotFound"}
+	if calls := rec.wait(t, len(want)); !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls: %v, want: %v", calls, want)
+	}
+}
+
+func TestStreamInterceptor(t *testing.T) {
+	rec := new(recorder)
+	s := streamService(nil)
+	s.UseStreamInterceptor(rec.stream("a"), rec.stream("b"), func(si *StreamInfo, next StreamHandler) error {
+		if si.Desc.CmdID == testCmdFailStream {
+			return status.Error(status.PermissionDenied, "denied by interceptor")
+		}
+		return next(si)
+	})
+	srv := newMemServer(t, s, nil)
+	cli := dialMem(t, srv.url, NewService(), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	st, err := cli.NewStream(ctx, testCmdEchoStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = st.Send(wrapperspb.String("x")); err != nil {
+		t.Fatal(err)
+	}
+	out := new(wrapperspb.StringValue)
+	if err = st.Recv(out); err != nil || out.Value != "echo:x" {
+		t.Fatalf("Recv: %q, %v", out.Value, err)
+	}
+	if err = st.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a>", "b>", "<b:OK", "<a:OK"}
+	if calls := rec.wait(t, len(want)); !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls: %v, want: %v", calls, want)
+	}
+
+	// 拦截器返回的错误结束流
+	rec.reset()
+	st, err = cli.NewStream(ctx, testCmdFailStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = st.Recv(new(wrapperspb.StringValue)); status.CodeOf(err) != status.PermissionDenied {
+		t.Fatalf("Recv: %v", err)
+	}
+	want = []string{"a>", "b>", "<b:PermissionDenied", "<a:PermissionDenied"}
+	if calls := rec.wait(t, len(want)); !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls: %v, want: %v", calls, want)
+	}
+}
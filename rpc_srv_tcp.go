@@ -1,6 +1,7 @@
 package pprpc
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -8,11 +9,12 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/golang/protobuf/proto"
-
 	"github.com/pprpc/util/logs"
+	"github.com/pprpc/metrics"
 	"github.com/pprpc/packets"
 	"github.com/pprpc/pptcp"
+	"github.com/pprpc/sess"
+	"github.com/pprpc/status"
 )
 
 // RPCTCPServer TCP服务对象
@@ -21,7 +23,8 @@ type RPCTCPServer struct {
 	*pptcp.TCPServer
 	//cv *sync.Cond
 	*Service
-	// 进行GO程处理报文
+	// 进行GO程处理报文; 为 false 时在读取协程中顺序处理, 处理期间不读取报文,
+	// 对端的取消(CTRLCANCEL)要等处理完成后才能收到, CallContext 只在超时或者连接断开时 Done.
 	RunGO bool
 	// 定一个各个回调函数
 	Attr attrDefine // 定义设置连接属性的回调
@@ -30,15 +33,19 @@ type RPCTCPServer struct {
 	DisconnectCB pptcp.CloseCallback // 连接断开时的回调
 	//
 	PkgCB pkgCallBack // 所有数据报文的回调,将不会执行后续的其他回调
-	// 在调用 HBCB, CmdCB, AVCB, CustomerCB 之前调用该接口
-	// 如果该接口返回值 !=nil 则不执行后续回调(HBCB, CmdCB, AVCB, CustomerCB)
+	// 在调用 HBCB, CmdCB, AVCB, FileCB, CustomerCB 之前调用该接口
+	// 如果该接口返回值 !=nil 则不执行后续回调(HBCB, CmdCB, AVCB, FileCB, CustomerCB)
 	PreHookCB prehookCallBack
 	//
 	HBCB       hbCallBack       // 心跳回调
 	CmdCB      cmdCallBack      // 控制报文回调
 	AVCB       avCallBack       // 音视频流回调
+	FileCB     fileCallBack     // 文件传输报文回调(在读取协程中按顺序调用, 不能长时间阻塞)
 	CustomerCB customerCallBack // 自定义数据回调
 
+	// 密钥协商配置, nil 使用默认配置(要求客户端协商)
+	KX *KXConfig
+
 	// ReadTimeout WriteTimeout
 	ReadTimeout int
 	//WriteTimeout int
@@ -47,6 +54,12 @@ type RPCTCPServer struct {
 	count int32
 	// url
 	lisURL *url.URL
+	// 所有连接, 关闭服务时使用
+	conns *sess.Sessions
+	// 正在处理的报文数
+	handling int32
+	// 服务已经关闭
+	inShutdown int32
 }
 
 // NewRPCTCPServer 创建RPC服务
@@ -61,6 +74,7 @@ func NewRPCTCPServer(uri *url.URL, tlsc *tls.Config) (*RPCTCPServer, error) {
 	ts.ReadTimeout = 180
 	ts.count = 0
 	ts.lisURL = uri
+	ts.conns = sess.NewSessions(0)
 
 	return ts, nil
 }
@@ -71,8 +85,14 @@ func newTCPServer(uri *url.URL, tlsc *tls.Config) (srv *pptcp.TCPServer, err err
 		srv, err = pptcp.NewTCPServer(uri.Host)
 	case "tls":
 		srv, err = pptcp.NewTLSTCPServer(uri.Host, tlsc)
-	// case "quic":
-	// 	srv, err = pptcp.NewQUICServer(uri.Host, tlsc)
+	case "quic":
+		srv, err = pptcp.NewQUICServer(uri.Host, tlsc)
+	case "ws":
+		srv, err = pptcp.NewWSServer(uri.Host, uri.Path)
+	case "wss":
+		srv, err = pptcp.NewWSSServer(uri.Host, uri.Path, tlsc)
+	case "mem":
+		srv, err = pptcp.NewMemServer(uri.Host)
 	default:
 		err = fmt.Errorf("not support: %s", uri.Scheme)
 	}
@@ -80,23 +100,57 @@ func newTCPServer(uri *url.URL, tlsc *tls.Config) (srv *pptcp.TCPServer, err err
 	return
 }
 
-// Serve 启动服务
-func (ts *RPCTCPServer) Serve() {
+// Serve 启动服务, 调用 Stop/Shutdown 后返回 ErrServerClosed.
+func (ts *RPCTCPServer) Serve() error {
 	for {
 		conn, err := ts.TCPServer.Accept()
 		if err != nil {
+			if ts.shuttingDown() {
+				return ErrServerClosed
+			}
 			logs.Logger.Warnf("srv.Accept(), error: %s.", err)
+			time.Sleep(shutdownPollInterval)
 			continue
 		}
 		go ts.handleConnect(conn)
 	}
 }
 
-// Stop 停止服务
+// Stop 停止服务, 不再接受新连接, 已建立的连接不受影响.
 func (ts *RPCTCPServer) Stop() {
+	atomic.StoreInt32(&ts.inShutdown, 1)
 	ts.TCPServer.Close()
 }
 
+// Shutdown 优雅关闭服务: 停止接受新连接, 通知客户端(CTRLGOAWAY),
+// 等待处理中的报文完成后关闭所有连接; ctx 超时则直接关闭所有连接并返回 ctx.Err().
+func (ts *RPCTCPServer) Shutdown(ctx context.Context) (err error) {
+	ts.Stop()
+
+	goAwayAll(ts.conns)
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt32(&ts.handling) > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			goto closeConns
+		case <-ticker.C:
+		}
+	}
+closeConns:
+	ts.conns.Range(func(k, v interface{}) bool {
+		v.(*pptcp.Connection).Close()
+		return true
+	})
+	return
+}
+
+func (ts *RPCTCPServer) shuttingDown() bool {
+	return atomic.LoadInt32(&ts.inShutdown) != 0
+}
+
 // SetReadTimeout 设置连接读取超时时间.
 func (ts *RPCTCPServer) SetReadTimeout(to int64) {
 	ts.ReadTimeout = int(to)
@@ -106,11 +160,24 @@ func (ts *RPCTCPServer) handleConnect(conn *pptcp.Connection) {
 	logs.Logger.Debugf("%s, handleConnect, new connect.", conn)
 
 	atomic.AddInt32(&ts.count, 1)
+	metrics.Get().AddConns("tcp", metrics.SideServer, 1)
 	defer func() {
 		atomic.AddInt32(&ts.count, -1)
+		metrics.Get().AddConns("tcp", metrics.SideServer, -1)
 	}()
 
+	// 先加入 conns 再检查: Shutdown 设置标志后遍历 conns, 两者至少有一个能看到该连接
 	ci := conn.String()
+	ts.conns.Push(ci, conn)
+	defer ts.conns.Remove(ci)
+	if ts.shuttingDown() {
+		conn.Close()
+		return
+	}
+
+	bindCallTable(conn, ts.Service)
+	defer unbindCallTable(conn)
+
 	if ts.ConnectCB != nil {
 		ts.ConnectCB(conn)
 	}
@@ -118,6 +185,16 @@ func (ts *RPCTCPServer) handleConnect(conn *pptcp.Connection) {
 		conn.SetCloseCB(ts.DisconnectCB)
 	}
 	var err error
+	var first packets.PPPacket
+	first, err = ts.acceptKX(conn)
+	if err != nil {
+		err = fmt.Errorf("acceptKX(), %s", err)
+		goto connEnd
+	}
+	if first != nil && !ts.dispatchStream(first, conn) {
+		atomic.AddInt32(&ts.handling, 1)
+		ts.dispatch(first, conn)
+	}
 	for {
 		select {
 		case <-conn.Ctx.Done():
@@ -130,19 +207,15 @@ func (ts *RPCTCPServer) handleConnect(conn *pptcp.Connection) {
 				err = fmt.Errorf("packets.ReadTCPPacket(), ts.ReadTimeout: %d, %s", ts.ReadTimeout, e)
 				goto connEnd
 			}
+			if ts.dispatchStream(pkg, conn) {
+				continue
+			}
 
-			if ts.RunGO {
-				if ts.PkgCB == nil {
-					go ts.handlePacket(pkg, conn)
-				} else {
-					go ts.PkgCB(pkg, conn)
-				}
+			atomic.AddInt32(&ts.handling, 1)
+			if ts.RunGO && !inOrder(pkg) {
+				go ts.dispatch(pkg, conn)
 			} else {
-				if ts.PkgCB == nil {
-					ts.handlePacket(pkg, conn)
-				} else {
-					ts.PkgCB(pkg, conn)
-				}
+				ts.dispatch(pkg, conn)
 			}
 		}
 	}
@@ -159,6 +232,32 @@ connEnd:
 	}
 }
 
+func (ts *RPCTCPServer) acceptKX(conn *pptcp.Connection) (packets.PPPacket, error) {
+	cfg := ts.KX
+	if cfg == nil {
+		cfg = defKXConfig
+	}
+	return acceptKX(conn, cfg, packets.PROTOTCP, func() (packets.PPPacket, error) {
+		conn.SetReadDeadline(time.Now().Add(cfg.timeout()))
+		return packets.ReadTCPPacketAdv(conn, conn.AutoCrypt)
+	})
+}
+
+// dispatchStream 处理流报文, 设置了 PkgCB 时不处理.
+func (ts *RPCTCPServer) dispatchStream(pkg packets.PPPacket, conn *pptcp.Connection) bool {
+	return ts.PkgCB == nil && dispatchStream(conn, pkg, packets.PROTOTCP)
+}
+
+func (ts *RPCTCPServer) dispatch(pkg packets.PPPacket, conn *pptcp.Connection) {
+	defer atomic.AddInt32(&ts.handling, -1)
+	defer ts.recoverPanic(conn, pkg)
+	if ts.PkgCB == nil {
+		ts.handlePacket(pkg, conn)
+	} else {
+		ts.PkgCB(pkg, conn)
+	}
+}
+
 func (ts *RPCTCPServer) handlePacket(pkg packets.PPPacket, conn *pptcp.Connection) {
 	var err error
 	if ts.PreHookCB != nil {
@@ -176,6 +275,9 @@ func (ts *RPCTCPServer) handlePacket(pkg packets.PPPacket, conn *pptcp.Connectio
 		}
 	case *packets.CmdPacket:
 		cmd := pkg.(*packets.CmdPacket)
+		if dispatchResp(conn, cmd) {
+			break
+		}
 		if ts.CmdCB != nil {
 			err = ts.CmdCB(cmd, conn)
 		} else {
@@ -195,6 +297,13 @@ func (ts *RPCTCPServer) handlePacket(pkg packets.PPPacket, conn *pptcp.Connectio
 		} else {
 			err = ts.defavcb(av, conn)
 		}
+	case *packets.FilePacket:
+		fp := pkg.(*packets.FilePacket)
+		if ts.FileCB != nil {
+			err = ts.FileCB(fp, conn)
+		} else {
+			err = ts.deffilecb(fp, conn)
+		}
 	default:
 		err = errors.New("not support pkg.(type)")
 	}
@@ -215,51 +324,19 @@ func (ts *RPCTCPServer) defcmdcb(pkg *packets.CmdPacket, conn RPCConn) error {
 	if v != nil {
 		pkg.CmdName = v.CmdName
 
-		dec := func(dobj interface{}) error {
-			if pkg.Code != 0 {
-				return nil
-			}
-			if pkg.MessageType == packets.TYPEPBBIN {
-				if err := proto.Unmarshal(pkg.Payload, dobj.(proto.Message)); err != nil {
-					err = fmt.Errorf("proto.Unmarshal error: %s", err)
-					return err
-				}
-			} else if pkg.MessageType == packets.TYPEPBJSON {
-				if err := proto.UnmarshalMessageSetJSON(pkg.Payload, dobj.(proto.Message)); err != nil {
-					err = fmt.Errorf("proto.UnmarshalMessageSetJSON error: %s", err)
-					return err
-				}
-			} else {
-				err := fmt.Errorf("CmdID: %d, Name: %s, MessageType: %d Not Support",
-					pkg.CmdID, pkg.CmdName, pkg.MessageType)
-				return err
-			}
-			return nil
-		}
-
-		if pkg.RPCType == packets.RPCREQ {
-			_, err = v.ReqHandler(v.Hanlder, conn, pkg, true, dec)
-		} else if pkg.RPCType == packets.RPCRESP {
-			_, err = v.RespHandler(v.Hanlder, conn, pkg, true, dec)
-		} else {
-			err = fmt.Errorf("CmdId: %d, Name: %s, pkg.RPCType: %d, not support",
-				v.CmdID, v.CmdName, pkg.RPCType)
-		}
+		_, err = ts.callHandler(v, conn, pkg, true)
 		if err != nil {
 			err = fmt.Errorf("%s, CmdId: %d, Name: %s, RPCType: %d, call Handler error: %s",
 				conn.RemoteAddr(), v.CmdID, v.CmdName, pkg.RPCType, err)
 		}
 
 	} else {
-		// 没有注册的命令
-		pkg.Code = CmdIDNotReg
+		// 没有注册的命令, 应答不再回复(避免双方循环应答)
 		if pkg.RPCType == packets.RPCREQ {
-			pkg.RPCType = packets.RPCRESP
-		}
-		pkg.Payload = []byte{}
-		_, err = pkg.Write(conn)
-		if err != nil {
-			logs.Logger.Errorf("%s, WritePkg error: %s.", conn.RemoteAddr(), err)
+			_, err = writeStatus(conn, pkg, status.Newf(status.Unimplemented, "cmdid: %d not register", pkg.CmdID))
+			if err != nil {
+				logs.Logger.Errorf("%s, WritePkg error: %s.", conn.RemoteAddr(), err)
+			}
 		}
 		err = fmt.Errorf("%s, not find cmdid: %d register", conn.RemoteAddr(), pkg.CmdID)
 	}
@@ -278,6 +355,12 @@ func (ts *RPCTCPServer) defavcb(pkg *packets.AVPacket, conn RPCConn) error {
 	return nil
 }
 
+func (ts *RPCTCPServer) deffilecb(pkg *packets.FilePacket, conn RPCConn) error {
+	logs.Logger.Debugf("%s, FilePacket not support, FileID: %d, Offset: %d, Payload Length: %d.",
+		conn.RemoteAddr(), pkg.FileID, pkg.Offset, len(pkg.RAWPayload))
+	return nil
+}
+
 // Count get connections count
 func (ts *RPCTCPServer) Count() int32 {
 	return atomic.LoadInt32(&ts.count)
@@ -0,0 +1,77 @@
+package pprpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestShutdown(t *testing.T) {
+	h := newTestHandler()
+	srv := newMemServer(t, h.service(), nil)
+	cli := dialMem(t, srv.url, h.service(), nil)
+
+	type result struct {
+		v   string
+		err error
+	}
+	res := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_, resp, err := cli.Invoke(ctx, testCmdSlow, wrapperspb.String("slow"))
+		r := result{err: err}
+		if err == nil {
+			r.v = resp.(*wrapperspb.StringValue).Value
+		}
+		res <- r
+	}()
+	<-h.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// 处理中的请求完成后才关闭连接
+	r := <-res
+	if r.err != nil || r.v != "echo:slow" {
+		t.Fatalf("in-flight call: %q, %v", r.v, r.err)
+	}
+	select {
+	case err := <-srv.served:
+		if err != ErrServerClosed {
+			t.Fatalf("Serve: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve not return")
+	}
+	c, err := DailKX(srv.url, nil, NewService(), 100*time.Millisecond, nil, nil)
+	c.Close()
+	if err == nil {
+		t.Fatal("dial after Shutdown")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	h := newTestHandler()
+	srv := newMemServer(t, h.service(), nil)
+	cli := dialMem(t, srv.url, h.service(), nil)
+
+	go cli.Invoke(context.Background(), testCmdWait, wrapperspb.String("wait"))
+	<-h.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	// 关闭连接后处理函数的 CallContext 结束
+	select {
+	case <-h.done:
+	case <-time.After(time.Second):
+		t.Fatal("server CallContext not done")
+	}
+}
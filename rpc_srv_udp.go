@@ -1,15 +1,19 @@
 package pprpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"sync/atomic"
+	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/pprpc/util/logs"
+	"github.com/pprpc/metrics"
 	"github.com/pprpc/packets"
 	"github.com/pprpc/ppudp"
+	"github.com/pprpc/sess"
+	"github.com/pprpc/status"
 )
 
 // RPCUDPServer TCP服务对象
@@ -30,18 +34,28 @@ type RPCUDPServer struct {
 	DisconnectCB ppudp.CloseCallback // 连接断开时的回调
 	//
 	PkgCB pkgCallBack // 所有数据报文的回调,将不会执行后续的其他回调
-	// 在调用 HBCB, CmdCB, AVCB, CustomerCB 之前调用该接口
-	// 如果该接口返回值 false 则不执行后续回调(HBCB, CmdCB, AVCB, CustomerCB)
+	// 在调用 HBCB, CmdCB, AVCB, FileCB, CustomerCB 之前调用该接口
+	// 如果该接口返回值 false 则不执行后续回调(HBCB, CmdCB, AVCB, FileCB, CustomerCB)
 	PreHookCB prehookCallBack
 	//
 	HBCB       hbCallBack       // 心跳回调
 	CmdCB      cmdCallBack      // 控制报文回调
 	AVCB       avCallBack       // 音视频流回调
+	FileCB     fileCallBack     // 文件传输报文回调(在读取协程中按顺序调用, 不能长时间阻塞)
 	CustomerCB customerCallBack // 自定义数据回调
 
+	// 密钥协商配置, nil 使用默认配置(要求客户端协商)
+	KX *KXConfig
+
 	readTimeout int64
 	//WriteTimeout int
 	count int32
+	// 所有连接, 关闭服务时使用
+	conns *sess.Sessions
+	// 正在处理的报文数
+	handling int32
+	// 服务已经关闭
+	inShutdown int32
 }
 
 // NewRPCUDPServer 创建RPC服务
@@ -57,18 +71,23 @@ func NewRPCUDPServer(ip string, port, maxSess int) (*RPCUDPServer, error) {
 	us.RunGO = true
 	us.readTimeout = 45
 	us.count = 0
+	us.conns = sess.NewSessions(0)
 
 	srv.SetReadTimeout(us.readTimeout)
 
 	return us, nil
 }
 
-// Serve 启动服务
-func (ts *RPCUDPServer) Serve() {
+// Serve 启动服务, 调用 Stop/Shutdown 后返回 ErrServerClosed.
+func (ts *RPCUDPServer) Serve() error {
 	for {
 		conn, err := ts.UDPServer.Accept()
 		if err != nil {
+			if ts.shuttingDown() {
+				return ErrServerClosed
+			}
 			logs.Logger.Warnf("srv.Accept(), error: %s.", err)
+			time.Sleep(shutdownPollInterval)
 			continue
 		}
 		go ts.handleConnect(conn)
@@ -80,9 +99,46 @@ func (ts *RPCUDPServer) SetReadTimeout(to int64) {
 	ts.UDPServer.SetReadTimeout(to)
 }
 
+// SetReliable 是否允许客户端协商可靠传输模式(def: false), 最好在 Serve 之前调用
+func (ts *RPCUDPServer) SetReliable(b bool) {
+	ts.UDPServer.SetReliable(b)
+}
+
 // Stop 停止服务
 func (ts *RPCUDPServer) Stop() {
+	atomic.StoreInt32(&ts.inShutdown, 1)
+	ts.UDPServer.Close()
+}
+
+// Shutdown 优雅关闭服务: 不再处理新连接, 通知客户端(CTRLGOAWAY),
+// 等待处理中的报文完成后关闭所有连接和监听; ctx 超时则直接关闭并返回 ctx.Err().
+func (ts *RPCUDPServer) Shutdown(ctx context.Context) (err error) {
+	// UDP 所有连接共用监听socket, 需要在通知和关闭连接之后再关闭.
+	atomic.StoreInt32(&ts.inShutdown, 1)
+
+	goAwayAll(ts.conns)
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt32(&ts.handling) > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			goto closeConns
+		case <-ticker.C:
+		}
+	}
+closeConns:
+	ts.conns.Range(func(k, v interface{}) bool {
+		v.(*ppudp.Connection).Close()
+		return true
+	})
 	ts.UDPServer.Close()
+	return
+}
+
+func (ts *RPCUDPServer) shuttingDown() bool {
+	return atomic.LoadInt32(&ts.inShutdown) != 0
 }
 
 // GetListenInfo .
@@ -97,10 +153,24 @@ func (ts *RPCUDPServer) handleConnect(conn *ppudp.Connection) {
 	logs.Logger.Debugf("%s, handleConnect, new connect.", connInfo)
 
 	atomic.AddInt32(&ts.count, 1)
+	metrics.Get().AddConns("udp", metrics.SideServer, 1)
 	defer func() {
 		atomic.AddInt32(&ts.count, -1)
+		metrics.Get().AddConns("udp", metrics.SideServer, -1)
 	}()
 
+	// 先加入 conns 再检查: Shutdown 设置标志后遍历 conns, 两者至少有一个能看到该连接
+	ci := conn.String()
+	ts.conns.Push(ci, conn)
+	defer ts.conns.Remove(ci)
+	if ts.shuttingDown() {
+		conn.Close()
+		return
+	}
+
+	bindCallTable(conn, ts.Service)
+	defer unbindCallTable(conn)
+
 	if ts.ConnectCB != nil {
 		ts.ConnectCB(conn)
 	}
@@ -108,13 +178,23 @@ func (ts *RPCUDPServer) handleConnect(conn *ppudp.Connection) {
 		conn.SetCloseCB(ts.DisconnectCB)
 	}
 	var err error
+	var first packets.PPPacket
+	first, err = ts.acceptKX(conn)
+	if err != nil {
+		err = fmt.Errorf("acceptKX(), %s", err)
+		goto connEnd
+	}
+	if first != nil && !ts.dispatchStream(first, conn) {
+		atomic.AddInt32(&ts.handling, 1)
+		go ts.dispatch(first, conn)
+	}
 	for {
 		select {
 		case <-conn.Ctx.Done():
 			err = errors.New("conn.Ctx.Done()")
 			goto connEnd
 		default:
-			pkg, err := packets.ReadUDPPacket(conn)
+			pkg, err := packets.ReadUDPPacketAdv(conn, conn.AutoCrypt)
 			if err == io.EOF {
 				err = nil
 				goto connEnd
@@ -122,10 +202,14 @@ func (ts *RPCUDPServer) handleConnect(conn *ppudp.Connection) {
 				logs.Logger.Errorf("packets.ReadUDPPacket(), error: %s.", err)
 				goto connEnd
 			}
-			if ts.PkgCB == nil {
-				go ts.handlePacket(pkg, conn)
+			if ts.dispatchStream(pkg, conn) {
+				continue
+			}
+			atomic.AddInt32(&ts.handling, 1)
+			if inOrder(pkg) {
+				ts.dispatch(pkg, conn)
 			} else {
-				go ts.PkgCB(pkg, conn)
+				go ts.dispatch(pkg, conn)
 			}
 		}
 	}
@@ -140,6 +224,31 @@ connEnd:
 	conn.Close()
 }
 
+func (ts *RPCUDPServer) acceptKX(conn *ppudp.Connection) (packets.PPPacket, error) {
+	cfg := ts.KX
+	if cfg == nil {
+		cfg = defKXConfig
+	}
+	return acceptKX(conn, cfg, packets.PROTOUDP, func() (packets.PPPacket, error) {
+		return packets.ReadUDPPacketAdv(conn, conn.AutoCrypt)
+	})
+}
+
+// dispatchStream 处理流报文, 设置了 PkgCB 时不处理.
+func (ts *RPCUDPServer) dispatchStream(pkg packets.PPPacket, conn *ppudp.Connection) bool {
+	return ts.PkgCB == nil && dispatchStream(conn, pkg, packets.PROTOUDP)
+}
+
+func (ts *RPCUDPServer) dispatch(pkg packets.PPPacket, conn *ppudp.Connection) {
+	defer atomic.AddInt32(&ts.handling, -1)
+	defer ts.recoverPanic(conn, pkg)
+	if ts.PkgCB == nil {
+		ts.handlePacket(pkg, conn)
+	} else {
+		ts.PkgCB(pkg, conn)
+	}
+}
+
 func (ts *RPCUDPServer) handlePacket(pkg packets.PPPacket, conn *ppudp.Connection) {
 	var err error
 	if ts.PreHookCB != nil {
@@ -157,6 +266,9 @@ func (ts *RPCUDPServer) handlePacket(pkg packets.PPPacket, conn *ppudp.Connectio
 		}
 	case *packets.CmdPacket:
 		cmd := pkg.(*packets.CmdPacket)
+		if dispatchResp(conn, cmd) {
+			break
+		}
 		if ts.CmdCB != nil {
 			err = ts.CmdCB(cmd, conn)
 		} else {
@@ -176,6 +288,13 @@ func (ts *RPCUDPServer) handlePacket(pkg packets.PPPacket, conn *ppudp.Connectio
 		} else {
 			err = ts.defavcb(av, conn)
 		}
+	case *packets.FilePacket:
+		fp := pkg.(*packets.FilePacket)
+		if ts.FileCB != nil {
+			err = ts.FileCB(fp, conn)
+		} else {
+			err = ts.deffilecb(fp, conn)
+		}
 	default:
 		err = errors.New("not support pkg.(type)")
 	}
@@ -196,51 +315,19 @@ func (ts *RPCUDPServer) defcmdcb(pkg *packets.CmdPacket, conn RPCConn) error {
 	if v != nil {
 		pkg.CmdName = v.CmdName
 
-		dec := func(dobj interface{}) error {
-			if pkg.Code != 0 {
-				return nil
-			}
-			if pkg.MessageType == packets.TYPEPBBIN {
-				if err := proto.Unmarshal(pkg.Payload, dobj.(proto.Message)); err != nil {
-					err = fmt.Errorf("proto.Unmarshal error: %s", err)
-					return err
-				}
-			} else if pkg.MessageType == packets.TYPEPBJSON {
-				if err := proto.UnmarshalMessageSetJSON(pkg.Payload, dobj.(proto.Message)); err != nil {
-					err = fmt.Errorf("proto.UnmarshalMessageSetJSON error: %s", err)
-					return err
-				}
-			} else {
-				err := fmt.Errorf("CmdID: %d, Name: %s, MessageType: %d Not Support",
-					pkg.CmdID, pkg.CmdName, pkg.MessageType)
-				return err
-			}
-			return nil
-		}
-
-		if pkg.RPCType == packets.RPCREQ {
-			_, err = v.ReqHandler(v.Hanlder, conn, pkg, true, dec)
-		} else if pkg.RPCType == packets.RPCRESP {
-			_, err = v.RespHandler(v.Hanlder, conn, pkg, true, dec)
-		} else {
-			err = fmt.Errorf("CmdId: %d, Name: %s, pkg.RPCType: %d, not support",
-				v.CmdID, v.CmdName, pkg.RPCType)
-		}
+		_, err = ts.callHandler(v, conn, pkg, true)
 		if err != nil {
 			err = fmt.Errorf("%s, CmdId: %d, Name: %s, RPCType: %d, call Handler error: %s",
 				conn.RemoteAddr(), v.CmdID, v.CmdName, pkg.RPCType, err)
 		}
 
 	} else {
-		// 没有注册的命令
-		pkg.Code = CmdIDNotReg
+		// 没有注册的命令, 应答不再回复(避免双方循环应答)
 		if pkg.RPCType == packets.RPCREQ {
-			pkg.RPCType = packets.RPCRESP
-		}
-		pkg.Payload = []byte{}
-		_, err = pkg.Write(conn)
-		if err != nil {
-			logs.Logger.Errorf("%s, WritePkg error: %s.", conn.RemoteAddr(), err)
+			_, err = writeStatus(conn, pkg, status.Newf(status.Unimplemented, "cmdid: %d not register", pkg.CmdID))
+			if err != nil {
+				logs.Logger.Errorf("%s, WritePkg error: %s.", conn.RemoteAddr(), err)
+			}
 		}
 		err = fmt.Errorf("%s, not find cmdid: %d register", conn.RemoteAddr(), pkg.CmdID)
 	}
@@ -259,6 +346,12 @@ func (ts *RPCUDPServer) defavcb(pkg *packets.AVPacket, conn RPCConn) error {
 	return nil
 }
 
+func (ts *RPCUDPServer) deffilecb(pkg *packets.FilePacket, conn RPCConn) error {
+	logs.Logger.Debugf("%s, FilePacket not support, FileID: %d, Offset: %d, Payload Length: %d.",
+		conn.RemoteAddr(), pkg.FileID, pkg.Offset, len(pkg.RAWPayload))
+	return nil
+}
+
 // Count get connections count
 func (ts *RPCUDPServer) Count() int32 {
 	return atomic.LoadInt32(&ts.count)
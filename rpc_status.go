@@ -0,0 +1,46 @@
+package pprpc
+
+import (
+	"errors"
+
+	"github.com/pprpc/packets"
+	"github.com/pprpc/status"
+	"github.com/pprpc/util/logs"
+)
+
+// internalMsg 处理函数返回的普通 error 和 panic 应答的错误信息, 详细信息只记录在服务端日志
+const internalMsg = "internal error"
+
+// WriteStatus 写入错误应答: Code 为 st.Code, Payload 为 st.Encode().
+// ReqHandler 返回 error 且没有写入应答时会自动写入, 一般不需要直接调用.
+func WriteStatus(c RPCConn, pkg *packets.CmdPacket, st *status.Status) (n int64, err error) {
+	markResponded(pkg)
+	return writeStatus(c, pkg, st)
+}
+
+func writeStatus(c RPCConn, pkg *packets.CmdPacket, st *status.Status) (int64, error) {
+	pkg.Code = uint64(st.Code)
+	pkg.RPCType = packets.RPCRESP
+	pkg.Payload = st.Encode()
+	pkg.Metadata = trailerMD(pkg)
+	return pkg.Write(c)
+}
+
+// errStatus 处理函数返回的错误转换为应答的 Status: *status.Status 原样返回;
+// 普通 error 只保留状态码(Unknown, context 错误为 Canceled/DeadlineExceeded), err.Error() 记录日志.
+func errStatus(c RPCConn, pkg *packets.CmdPacket, err error) *status.Status {
+	var st *status.Status
+	if errors.As(err, &st) {
+		return st
+	}
+	logs.Logger.Errorf("%s, CmdID: %d, CmdSeq: %d, handler error: %s.", c, pkg.CmdID, pkg.CmdSeq, err)
+	return status.New(status.CodeOf(err), internalMsg)
+}
+
+// respError 应答的 Code 不为 0 时返回 *status.Status.
+func respError(pkg *packets.CmdPacket) error {
+	if pkg.Code == 0 {
+		return nil
+	}
+	return status.Decode(pkg.Code, pkg.Payload)
+}
@@ -0,0 +1,427 @@
+package pprpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pprpc/metrics"
+	"github.com/pprpc/packets"
+	"github.com/pprpc/status"
+	"github.com/pprpc/trace"
+)
+
+/*
+流式调用(基于 CmdPacket, 通过 CmdSeq 关联):
+
+	客户端 RPCCTRL(CTRLOPEN)              打开流
+	双向   RPCSTREAM                      数据
+	客户端 RPCCTRL(CTRLEND)               发送结束(CloseSend)
+	服务端 RPCRESP(Code)                  处理函数返回, 结束流
+	双向   RPCCTRL(CTRLCANCEL)            取消
+	双向   RPCCTRL(CTRLCREDIT, n)         流量控制: 允许对端再发送 n 个数据报文
+
+流只能由客户端(RPCCliConn.NewStream)打开, 服务端由 ServiceDesc.StreamHandler 处理.
+每个方向初始额度为 streamWindow, 接收方每消费一半补充一次.
+流的每个报文携带方向和递增的计数(CmdPacket.FrameDir/Frame), 参与加密Key和nonce的生成;
+接收方丢弃方向不符或者计数没有递增的报文, 计数到达 packets.MAXFRAME 后流不能再发送.
+UDP 连接上的流不保证可靠, 建议使用可靠传输模式(DailUDPReliable).
+*/
+
+// Stream 流
+type Stream interface {
+	// Context 流结束(正常结束, 取消, 连接断开)时 Done.
+	Context() context.Context
+	// Send 发送一个数据报文, 没有发送额度时阻塞.
+	Send(m interface{}) error
+	// Recv 接收一个数据报文, 对端发送结束返回 io.EOF; 服务端返回错误时为 *status.Status.
+	Recv(m interface{}) error
+	// CloseSend 结束发送(客户端); 服务端处理函数返回即结束流.
+	CloseSend() error
+	// Cancel 取消流并通知对端.
+	Cancel()
+}
+
+// streamWindow 流初始的发送额度(报文数)
+const streamWindow = 32
+
+var (
+	// ErrStreamCanceled 流被取消
+	ErrStreamCanceled = errors.New("pprpc: stream canceled")
+	// ErrStreamSendClosed 已经调用 CloseSend
+	ErrStreamSendClosed = errors.New("pprpc: stream send closed")
+	// ErrStreamFrameOverflow 流报文计数已经到达 packets.MAXFRAME
+	ErrStreamFrameOverflow = errors.New("pprpc: stream frame counter overflow")
+)
+
+type rpcStream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	conn     RPCConn
+	cmdid    uint64
+	seq      uint64
+	mt       uint8
+	crypt    uint8
+	protocol uint8
+	server   bool
+
+	// 发送报文计数, wmu 保证计数与写入顺序一致
+	wmu       sync.Mutex
+	sendFrame uint64
+	// 最后收到的报文计数, 仅在读取协程中访问
+	recvFrame uint64
+
+	recvq   chan *packets.CmdPacket
+	recvErr error
+	// 已消费还未补充给对端的额度
+	consumed int
+
+	credits int64
+	creditc chan struct{}
+	// 对端已经结束流(RPCRESP)
+	remoteDone int32
+	sendClosed int32
+
+	once   sync.Once
+	err    error
+	onDone func()
+}
+
+func newRPCStream(ctx context.Context, conn RPCConn, cmdid uint64, mt, crypt, protocol uint8, server bool) *rpcStream {
+	s := new(rpcStream)
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.conn = conn
+	s.cmdid = cmdid
+	s.mt = mt
+	s.crypt = crypt
+	s.protocol = protocol
+	s.server = server
+	s.recvq = make(chan *packets.CmdPacket, streamWindow+2)
+	s.credits = streamWindow
+	s.creditc = make(chan struct{}, 1)
+	return s
+}
+
+// watch 连接断开或者 ctx 结束时结束流.
+func (s *rpcStream) watch(connDone <-chan struct{}) {
+	select {
+	case <-connDone:
+		s.finish(fmt.Errorf("%s, connection closed", s.conn), false)
+	case <-s.ctx.Done():
+		s.finish(s.ctx.Err(), true)
+	}
+}
+
+// finish 结束流, notify 为 true 时通知对端取消.
+func (s *rpcStream) finish(err error, notify bool) {
+	s.once.Do(func() {
+		s.err = err
+		s.cancel()
+		if notify && atomic.LoadInt32(&s.remoteDone) == 0 {
+			s.writeFrame(packets.RPCCTRL, packets.CTRLCANCEL, nil)
+		}
+		if s.onDone != nil {
+			s.onDone()
+		}
+	})
+}
+
+func (s *rpcStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *rpcStream) writeFrame(rpcType uint8, code uint64, payload []byte) error {
+	return s.write(s.newFrame(rpcType, code, payload))
+}
+
+// write 设置报文方向和计数后写入.
+func (s *rpcStream) write(cmd *packets.CmdPacket) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.sendFrame >= packets.MAXFRAME {
+		return ErrStreamFrameOverflow
+	}
+	s.sendFrame++
+	cmd.Frame = s.sendFrame
+	cmd.FrameDir = s.sendDir()
+	_, err := cmd.Write(s.conn)
+	return err
+}
+
+// sendDir 本端发送的报文方向.
+func (s *rpcStream) sendDir() uint8 {
+	if s.server {
+		return packets.FRAMES2C
+	}
+	return packets.FRAMEC2S
+}
+
+// checkFrame 检查对端报文的方向和计数, 不符合的报文(反射或者重放)丢弃.
+func (s *rpcStream) checkFrame(pkg *packets.CmdPacket) bool {
+	if pkg.Frame == 0 || pkg.FrameDir == s.sendDir() || pkg.Frame <= s.recvFrame {
+		return false
+	}
+	s.recvFrame = pkg.Frame
+	return true
+}
+
+func (s *rpcStream) newFrame(rpcType uint8, code uint64, payload []byte) *packets.CmdPacket {
+	cmd := packets.NewCmdPacket(s.mt)
+	if s.protocol == packets.PROTOUDP {
+		cmd.FixHeader.SetProtocol(packets.PROTOUDP)
+	}
+	cmd.CmdID = s.cmdid
+	cmd.CmdSeq = s.seq
+	cmd.EncType = s.crypt
+	cmd.RPCType = rpcType
+	cmd.Code = code
+	cmd.Payload = payload
+	return cmd
+}
+
+func (s *rpcStream) Send(m interface{}) (err error) {
+	if atomic.LoadInt32(&s.sendClosed) != 0 {
+		return ErrStreamSendClosed
+	}
+	if atomic.LoadInt32(&s.remoteDone) != 0 {
+		return io.EOF
+	}
+	for atomic.AddInt64(&s.credits, -1) < 0 {
+		atomic.AddInt64(&s.credits, 1)
+		select {
+		case <-s.creditc:
+		case <-s.ctx.Done():
+			return s.doneErr()
+		}
+	}
+	b, err := encodePayload(s.mt, m)
+	if err != nil {
+		return
+	}
+	err = s.writeFrame(packets.RPCSTREAM, 0, b)
+	if err == ErrStreamFrameOverflow {
+		s.finish(err, false)
+	}
+	return
+}
+
+func (s *rpcStream) Recv(m interface{}) error {
+	if s.recvErr != nil {
+		return s.recvErr
+	}
+	// 流结束后仍然先读取已经收到的报文
+	var pkg *packets.CmdPacket
+	select {
+	case pkg = <-s.recvq:
+	case <-s.ctx.Done():
+		select {
+		case pkg = <-s.recvq:
+		default:
+			return s.doneErr()
+		}
+	}
+
+	switch pkg.RPCType {
+	case packets.RPCSTREAM:
+		s.consumed++
+		if s.consumed >= streamWindow/2 {
+			s.writeFrame(packets.RPCCTRL, packets.CTRLCREDIT, proto.EncodeVarint(uint64(s.consumed)))
+			s.consumed = 0
+		}
+		return decodePayload(pkg, m)
+	case packets.RPCRESP:
+		s.recvErr = io.EOF
+		if pkg.Code != 0 {
+			s.recvErr = respError(pkg)
+		}
+	default:
+		// CTRLEND
+		s.recvErr = io.EOF
+	}
+	return s.recvErr
+}
+
+func (s *rpcStream) CloseSend() error {
+	if !atomic.CompareAndSwapInt32(&s.sendClosed, 0, 1) {
+		return nil
+	}
+	if s.server {
+		return nil
+	}
+	return s.writeFrame(packets.RPCCTRL, packets.CTRLEND, nil)
+}
+
+func (s *rpcStream) Cancel() {
+	s.finish(ErrStreamCanceled, true)
+}
+
+// doneErr 流结束的原因; ctx 可能由调用方直接取消, 需要等待 finish 完成.
+func (s *rpcStream) doneErr() error {
+	s.finish(s.ctx.Err(), true)
+	return s.err
+}
+
+// input 处理对端发送的流报文, 在读取协程中调用, 不能阻塞.
+func (s *rpcStream) input(pkg *packets.CmdPacket) {
+	if !s.checkFrame(pkg) {
+		return
+	}
+	if pkg.RPCType == packets.RPCCTRL {
+		switch pkg.Code {
+		case packets.CTRLCREDIT:
+			n, _ := proto.DecodeVarint(pkg.Payload)
+			atomic.AddInt64(&s.credits, int64(n))
+			select {
+			case s.creditc <- struct{}{}:
+			default:
+			}
+			return
+		case packets.CTRLCANCEL:
+			atomic.StoreInt32(&s.remoteDone, 1)
+			s.finish(ErrStreamCanceled, false)
+			return
+		case packets.CTRLEND:
+		default:
+			return
+		}
+	}
+	if pkg.RPCType == packets.RPCRESP {
+		atomic.StoreInt32(&s.remoteDone, 1)
+	}
+	select {
+	case s.recvq <- pkg:
+	default:
+		// 对端没有遵守发送额度
+		s.finish(fmt.Errorf("CmdID: %d, seq: %d, stream recv overflow", s.cmdid, s.seq), true)
+		return
+	}
+	if pkg.RPCType == packets.RPCRESP {
+		// 服务端已结束, 未读取的报文仍可以通过 Recv 获取
+		s.finish(io.EOF, false)
+	}
+}
+
+// openStream 客户端打开流, 流结束时释放序列号.
+func openStream(ctx context.Context, conn RPCConn, seqs *seqAllocator, cmdid uint64, mt, crypt, protocol uint8) (Stream, error) {
+	s := newRPCStream(ctx, conn, cmdid, mt, crypt, protocol, false)
+	seq, err := seqs.Acquire(s, crypt)
+	if err != nil {
+		return nil, err
+	}
+	s.seq = seq
+	s.onDone = func() {
+		seqs.Release(s.seq)
+	}
+	cmd := s.newFrame(packets.RPCCTRL, packets.CTRLOPEN, nil)
+	cmd.Metadata = injectTrace(outgoingMD(ctx), trace.SpanContextFromContext(ctx))
+	err = s.write(cmd)
+	if err != nil {
+		s.finish(err, false)
+		return nil, err
+	}
+	go s.watch(conn.HandleClose().Done())
+	return s, nil
+}
+
+// routeStream 客户端: 将流报文交给 NewStream 打开的流, 处理对端对请求的取消; 需要在读取协程中同步调用以保证顺序.
+func routeStream(pending *sync.Map, c RPCConn, pkg packets.PPPacket) bool {
+	cmd, ok := pkg.(*packets.CmdPacket)
+	if !ok || cmd.RPCType == packets.RPCREQ || isGoAway(cmd) {
+		// 对端发起的请求与本端的流使用各自的序列号
+		return false
+	}
+	if isCallCancel(cmd) {
+		// 对端(pprpc.Invoke)取消发给本端的请求
+		cancelCall(c, cmd.CmdSeq)
+		return true
+	}
+	v, ok := pending.Load(cmd.CmdSeq)
+	if s, isStream := v.(*rpcStream); ok && isStream {
+		s.input(cmd)
+		return true
+	}
+	// 已经结束的流
+	return cmd.RPCType == packets.RPCSTREAM || cmd.RPCType == packets.RPCCTRL
+}
+
+// dispatchStream 服务端: 处理流报文(RPCSTREAM/RPCCTRL)和请求的取消; 需要在读取协程中同步调用以保证顺序.
+func dispatchStream(c RPCConn, pkg packets.PPPacket, protocol uint8) bool {
+	cmd, ok := pkg.(*packets.CmdPacket)
+	if !ok || (cmd.RPCType != packets.RPCSTREAM && cmd.RPCType != packets.RPCCTRL) {
+		return false
+	}
+	ct := getCallTable(c)
+	if ct == nil {
+		return true
+	}
+	if cmd.RPCType == packets.RPCCTRL && cmd.Code == packets.CTRLOPEN {
+		ct.openStream(c, cmd, protocol)
+		return true
+	}
+	if isCallCancel(cmd) {
+		// 取消处理中的请求
+		cancelCall(c, cmd.CmdSeq)
+		return true
+	}
+	if v, ok := ct.streams.Load(cmd.CmdSeq); ok {
+		v.(*rpcStream).input(cmd)
+	}
+	return true
+}
+
+// openStream 服务端: 对端打开流, 在新的协程中执行 StreamHandler.
+func (ct *callTable) openStream(c RPCConn, pkg *packets.CmdPacket, protocol uint8) {
+	if _, ok := ct.streams.Load(pkg.CmdSeq); ok {
+		return
+	}
+	if pkg.Frame == 0 || pkg.FrameDir != packets.FRAMEC2S {
+		return
+	}
+	ctx := context.Background()
+	if len(pkg.Metadata) > 0 {
+		ctx = context.WithValue(ctx, incomingMDKey{}, MD(pkg.Metadata))
+	}
+	ctx = ct.startServerSpan(ctx, c, pkg)
+	sp := trace.SpanFromContext(ctx)
+	s := newRPCStream(ctx, c, pkg.CmdID, pkg.MessageType, pkg.EncType, protocol, true)
+	s.seq = pkg.CmdSeq
+	s.recvFrame = pkg.Frame
+
+	v := ct.GetService(pkg.CmdID)
+	if v == nil || v.StreamHandler == nil {
+		st := status.Newf(status.Unimplemented, "cmdid: %d stream not register", pkg.CmdID)
+		s.writeFrame(packets.RPCRESP, uint64(st.Code), st.Encode())
+		endSpan(sp, uint64(st.Code), st)
+		s.cancel()
+		return
+	}
+	pkg.CmdName = v.CmdName
+	ct.streams.Store(s.seq, s)
+	s.onDone = func() {
+		ct.streams.Delete(s.seq)
+	}
+	go s.watch(c.HandleClose().Done())
+	go func() {
+		start := time.Now()
+		err := ct.callStreamHandler(v, c, pkg, s)
+		ct.observeCall(metrics.SideServer, pkg.CmdID, start, uint64(status.CodeOf(err)))
+		endSpan(sp, uint64(status.CodeOf(err)), err)
+		if s.ctx.Err() == nil {
+			var code uint64
+			var msg []byte
+			if err != nil {
+				st := errStatus(c, pkg, err)
+				code, msg = uint64(st.Code), st.Encode()
+			}
+			atomic.StoreInt32(&s.remoteDone, 1)
+			s.writeFrame(packets.RPCRESP, code, msg)
+		}
+		s.finish(io.EOF, false)
+	}()
+}
@@ -0,0 +1,170 @@
+package pprpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pprpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 测试使用的流命令ID
+const (
+	testCmdEchoStream uint64 = 20 // 逐个应答 "echo:" + 收到的报文
+	testCmdHoldStream uint64 = 21 // 不读取报文, 等待流结束
+	testCmdFailStream uint64 = 22 // 读取一个报文后返回错误
+)
+
+func streamService(done chan error) *Service {
+	s := NewService()
+	s.RegisterService(&ServiceDesc{CmdID: testCmdEchoStream, CmdName: "EchoStream",
+		StreamHandler: func(srv interface{}, c RPCConn, st Stream) error {
+			for {
+				in := new(wrapperspb.StringValue)
+				err := st.Recv(in)
+				if err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if err = st.Send(wrapperspb.String("echo:" + in.Value)); err != nil {
+					return err
+				}
+			}
+		}}, nil)
+	s.RegisterService(&ServiceDesc{CmdID: testCmdHoldStream, CmdName: "HoldStream",
+		StreamHandler: func(srv interface{}, c RPCConn, st Stream) error {
+			<-st.Context().Done()
+			done <- st.Context().Err()
+			return nil
+		}}, nil)
+	s.RegisterService(&ServiceDesc{CmdID: testCmdFailStream, CmdName: "FailStream",
+		StreamHandler: func(srv interface{}, c RPCConn, st Stream) error {
+			if err := st.Recv(new(wrapperspb.StringValue)); err != nil {
+				return err
+			}
+			return status.Error(status.PermissionDenied, "denied")
+		}}, nil)
+	return s
+}
+
+func TestStream(t *testing.T) {
+	srv := newMemServer(t, streamService(nil), nil)
+	cli := dialMem(t, srv.url, NewService(), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	st, err := cli.NewStream(ctx, testCmdEchoStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 超过初始额度, 需要双方补充额度
+	n := streamWindow * 4
+	sent := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := st.Send(wrapperspb.String(fmt.Sprint(i))); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- st.CloseSend()
+	}()
+	for i := 0; i < n; i++ {
+		out := new(wrapperspb.StringValue)
+		if err = st.Recv(out); err != nil {
+			t.Fatalf("Recv(%d): %v", i, err)
+		}
+		if out.Value != fmt.Sprintf("echo:%d", i) {
+			t.Fatalf("Recv(%d): %q", i, out.Value)
+		}
+	}
+	if err = <-sent; err != nil {
+		t.Fatal(err)
+	}
+	if err = st.Recv(new(wrapperspb.StringValue)); err != io.EOF {
+		t.Fatalf("Recv after CloseSend: %v", err)
+	}
+}
+
+func TestStreamCredit(t *testing.T) {
+	done := make(chan error, 1)
+	srv := newMemServer(t, streamService(done), nil)
+	cli := dialMem(t, srv.url, NewService(), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	st, err := cli.NewStream(ctx, testCmdHoldStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 对端不读取时只能发送初始额度的报文
+	n := 0
+	for ; n <= streamWindow; n++ {
+		if err = st.Send(wrapperspb.String("x")); err != nil {
+			break
+		}
+	}
+	if n != streamWindow || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("sent: %d, err: %v", n, err)
+	}
+	// 客户端结束流时通知服务端
+	select {
+	case err = <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("server stream err: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server stream not canceled")
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	done := make(chan error, 1)
+	srv := newMemServer(t, streamService(done), nil)
+	cli := dialMem(t, srv.url, NewService(), nil)
+
+	st, err := cli.NewStream(context.Background(), testCmdHoldStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Cancel()
+	if err = st.Recv(new(wrapperspb.StringValue)); err != ErrStreamCanceled {
+		t.Fatalf("Recv after Cancel: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("server stream not canceled")
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	srv := newMemServer(t, streamService(nil), nil)
+	cli := dialMem(t, srv.url, NewService(), nil)
+
+	st, err := cli.NewStream(context.Background(), testCmdFailStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = st.Send(wrapperspb.String("x")); err != nil {
+		t.Fatal(err)
+	}
+	err = st.Recv(new(wrapperspb.StringValue))
+	if status.CodeOf(err) != status.PermissionDenied {
+		t.Fatalf("Recv: %v", err)
+	}
+
+	// 没有注册 StreamHandler
+	st, err = cli.NewStream(context.Background(), 99)
+	if err == nil {
+		err = st.Recv(new(wrapperspb.StringValue))
+	}
+	if status.CodeOf(err) != status.Unimplemented {
+		t.Fatalf("unregistered stream: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package pprpc
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pprpc/packets"
+	"github.com/pprpc/pptcp"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 测试使用的命令ID
+const (
+	testCmdEcho uint64 = 10 // 应答 "echo:" + 请求
+	testCmdWait uint64 = 11 // 等待 CallContext 结束
+	testCmdSlow uint64 = 12 // 等待 slowDelay 后应答
+)
+
+const slowDelay = 200 * time.Millisecond
+
+var memSeq uint32
+
+// testHandler 测试服务的处理函数, 处理的请求通过 started 通知, CallContext 结束的原因通过 done 返回.
+type testHandler struct {
+	started chan string
+	done    chan error
+}
+
+func newTestHandler() *testHandler {
+	return &testHandler{started: make(chan string, 16), done: make(chan error, 16)}
+}
+
+func (h *testHandler) desc(cmdid uint64, name string) *ServiceDesc {
+	return &ServiceDesc{
+		CmdID:   cmdid,
+		CmdName: name,
+		ReqHandler: func(srv interface{}, c RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+			in := new(wrapperspb.StringValue)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if !isCall {
+				return in, nil
+			}
+			h.started <- in.Value
+			ctx := CallContext(pkg)
+			switch pkg.CmdID {
+			case testCmdWait:
+				<-ctx.Done()
+				h.done <- ctx.Err()
+				return nil, ctx.Err()
+			case testCmdSlow:
+				time.Sleep(slowDelay)
+			}
+			v := "echo:" + in.Value
+			if md, ok := FromIncomingContext(ctx); ok && len(md["x-test"]) > 0 {
+				v += "," + md["x-test"]
+			}
+			out := wrapperspb.String(v)
+			_, err := WriteResp(c, pkg, out)
+			return out, err
+		},
+		RespHandler: func(srv interface{}, c RPCConn, pkg *packets.CmdPacket, isCall bool, dec func(interface{}) error) (interface{}, error) {
+			out := new(wrapperspb.StringValue)
+			err := dec(out)
+			return out, err
+		},
+	}
+}
+
+func (h *testHandler) service() *Service {
+	s := NewService()
+	s.RegisterService(h.desc(testCmdEcho, "Echo"), nil)
+	s.RegisterService(h.desc(testCmdWait, "Wait"), nil)
+	s.RegisterService(h.desc(testCmdSlow, "Slow"), nil)
+	return s
+}
+
+// memServer mem:// 服务, 服务端的连接通过 conns 获取, Serve 的返回值通过 served 获取.
+type memServer struct {
+	*RPCTCPServer
+	url    *url.URL
+	conns  chan *pptcp.Connection
+	served chan error
+}
+
+func newMemServer(t *testing.T, s *Service, kx *KXConfig) *memServer {
+	t.Helper()
+	u, _ := url.Parse(fmt.Sprintf("mem://%s-%d", t.Name(), atomic.AddUint32(&memSeq, 1)))
+	srv, err := NewRPCTCPServer(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Service = s
+	srv.RunGO = true
+	srv.KX = kx
+	ms := &memServer{RPCTCPServer: srv, url: u, conns: make(chan *pptcp.Connection, 4), served: make(chan error, 1)}
+	srv.ConnectCB = func(c *pptcp.Connection) { ms.conns <- c }
+	go func() { ms.served <- srv.Serve() }()
+	t.Cleanup(srv.Stop)
+	return ms
+}
+
+func dialMem(t *testing.T, u *url.URL, s *Service, kx *KXConfig) *TCPCliConn {
+	t.Helper()
+	cli, err := DailKX(u, nil, s, time.Second, kx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+func respValue(t *testing.T, resp interface{}, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.(*wrapperspb.StringValue).Value
+}
+
+func TestMemTransport(t *testing.T) {
+	h := newTestHandler()
+	srv := newMemServer(t, h.service(), nil)
+	cli := dialMem(t, srv.url, h.service(), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, resp, err := cli.Invoke(ctx, testCmdEcho, wrapperspb.String("mem"))
+	if v := respValue(t, resp, err); v != "echo:mem" {
+		t.Fatalf("resp: %q", v)
+	}
+
+	// 同一个名称只能监听一次
+	if _, err = NewRPCTCPServer(srv.url, nil); err == nil {
+		t.Fatal("listen twice")
+	}
+	// 没有监听的名称
+	u, _ := url.Parse("mem://" + t.Name() + "-none")
+	c, err := Dail(u, nil, NewService(), 100*time.Millisecond, nil)
+	c.Close()
+	if err == nil {
+		t.Fatal("dial without listener")
+	}
+}
@@ -0,0 +1,99 @@
+package pprpc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pprpc/packets"
+	"github.com/pprpc/trace"
+)
+
+// spanName 调用 span 的名称: CmdName, 没有注册时为 cmdid.
+func (s *Service) spanName(cmdid uint64) string {
+	if name := s.cmdName(cmdid); name != "" {
+		return name
+	}
+	return fmt.Sprintf("cmdid:%d", cmdid)
+}
+
+// startClientSpan 客户端调用的 span, 并将跟踪上下文写入请求元数据.
+func (s *Service) startClientSpan(ctx context.Context, c RPCConn, cmd *packets.CmdPacket) *trace.Span {
+	return s.startSpan(ctx, s.spanName(cmd.CmdID), trace.SpanKindClient, c, cmd)
+}
+
+// startSendSpan 异步调用(InvokeAsync)的 span: 只包含发送, 写入请求后结束, 不记录应答的状态码.
+func (s *Service) startSendSpan(ctx context.Context, c RPCConn, cmd *packets.CmdPacket) *trace.Span {
+	return s.startSpan(ctx, s.spanName(cmd.CmdID)+" send", trace.SpanKindProducer, c, cmd)
+}
+
+func (s *Service) startSpan(ctx context.Context, name string, kind trace.SpanKind, c RPCConn, cmd *packets.CmdPacket) *trace.Span {
+	_, sp := trace.Start(ctx, name, kind)
+	if sp == nil {
+		return nil
+	}
+	setSpanAttrs(sp, s.cmdName(cmd.CmdID), cmd.CmdID, c)
+	cmd.Metadata = injectTrace(cmd.Metadata, sp.SpanContext())
+	return sp
+}
+
+// injectTrace 返回附加跟踪上下文的元数据副本(不修改调用方的 MD).
+func injectTrace(md map[string]string, sc trace.SpanContext) map[string]string {
+	if !sc.IsValid() {
+		return md
+	}
+	out := make(map[string]string, len(md)+2)
+	for k, v := range md {
+		out[k] = v
+	}
+	trace.Inject(sc, out)
+	return out
+}
+
+// startServerSpan 服务端处理请求的 span, 父 span 为对端元数据中的跟踪上下文.
+func (s *Service) startServerSpan(ctx context.Context, c RPCConn, pkg *packets.CmdPacket) context.Context {
+	if sc, ok := trace.Extract(pkg.Metadata); ok {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+	}
+	ctx, sp := trace.Start(ctx, s.spanName(pkg.CmdID), trace.SpanKindServer)
+	setSpanAttrs(sp, s.cmdName(pkg.CmdID), pkg.CmdID, c)
+	return ctx
+}
+
+func setSpanAttrs(sp *trace.Span, name string, cmdid uint64, c RPCConn) {
+	if !sp.IsRecording() {
+		return
+	}
+	sp.SetAttr(trace.AttrRPCSystem, "pprpc")
+	sp.SetAttr(trace.AttrRPCMethod, name)
+	sp.SetAttr(trace.AttrCmdID, cmdid)
+	if addr := c.RemoteAddr(); addr != nil {
+		sp.SetAttr(trace.AttrPeerAddr, addr.String())
+	}
+}
+
+// endSendSpan 记录发送结果并结束异步调用的 span.
+func endSendSpan(sp *trace.Span, err error) {
+	if !sp.IsRecording() {
+		return
+	}
+	if err != nil {
+		sp.SetStatus(trace.StatusError, err.Error())
+	} else {
+		sp.SetStatus(trace.StatusOK, "")
+	}
+	sp.End()
+}
+
+// endSpan 记录状态码并结束 span.
+func endSpan(sp *trace.Span, code uint64, err error) {
+	if !sp.IsRecording() {
+		return
+	}
+	sp.SetAttr(trace.AttrCode, code)
+	if err != nil {
+		sp.SetStatus(trace.StatusError, err.Error())
+	} else {
+		sp.SetStatus(trace.StatusOK, "")
+	}
+	sp.End()
+}
@@ -0,0 +1,73 @@
+package pprpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pprpc/trace"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestTrace(t *testing.T) {
+	exp := trace.NewInMemoryExporter()
+	trace.SetExporter(exp)
+	defer trace.SetExporter(nil)
+
+	h := newTestHandler()
+	srv := newMemServer(t, h.service(), nil)
+	cli := dialMem(t, srv.url, h.service(), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ctx, root := trace.Start(ctx, "root", trace.SpanKindInternal)
+	_, resp, err := cli.Invoke(ctx, testCmdEcho, wrapperspb.String("trace"))
+	respValue(t, resp, err)
+	if err = cli.InvokeAsync(ctx, testCmdEcho, wrapperspb.String("async")); err != nil {
+		t.Fatal(err)
+	}
+	root.End()
+
+	spans := waitSpans(t, exp, 5)
+	byKind := make(map[trace.SpanKind][]*trace.SpanData)
+	for _, sd := range spans {
+		if sd.SpanContext.TraceID != root.SpanContext().TraceID {
+			t.Fatalf("span: %s, trace id: %s", sd.Name, sd.SpanContext.TraceID)
+		}
+		byKind[sd.Kind] = append(byKind[sd.Kind], sd)
+	}
+	if len(byKind[trace.SpanKindClient]) != 1 || len(byKind[trace.SpanKindProducer]) != 1 || len(byKind[trace.SpanKindServer]) != 2 {
+		t.Fatalf("spans: %v", byKind)
+	}
+	client, producer := byKind[trace.SpanKindClient][0], byKind[trace.SpanKindProducer][0]
+	if client.Name != "Echo" || client.Parent.SpanID != root.SpanContext().SpanID {
+		t.Fatalf("client span: %+v", client)
+	}
+	if producer.Name != "Echo send" || producer.StatusCode != trace.StatusOK {
+		t.Fatalf("producer span: %+v", producer)
+	}
+	// 服务端 span 的父 span 为客户端 span
+	parents := map[trace.SpanID]bool{}
+	for _, sd := range byKind[trace.SpanKindServer] {
+		parents[sd.Parent.SpanID] = true
+	}
+	if !parents[client.SpanContext.SpanID] || !parents[producer.SpanContext.SpanID] {
+		t.Fatalf("server span parents: %v", parents)
+	}
+}
+
+// waitSpans 等待导出 n 个 span(服务端 span 在应答之后结束).
+func waitSpans(t *testing.T, exp *trace.InMemoryExporter, n int) []*trace.SpanData {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		spans := exp.Spans()
+		if len(spans) >= n || time.Now().After(deadline) {
+			if len(spans) != n {
+				t.Fatalf("spans: %d, expect: %d", len(spans), n)
+			}
+			return spans
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
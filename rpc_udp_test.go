@@ -0,0 +1,134 @@
+package pprpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pprpc/packets"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// lossyProxy 在客户端和服务端之间转发 UDP 报文, 每个方向每 drop 个报文丢弃一个.
+type lossyProxy struct {
+	front   *net.UDPConn // 客户端连接的地址
+	back    *net.UDPConn // 连接服务端
+	client  atomic.Value // *net.UDPAddr
+	drop    uint64
+	dropped uint64
+}
+
+func newLossyProxy(t *testing.T, srvAddr string, drop uint64) *lossyProxy {
+	t.Helper()
+	front, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raddr, _ := net.ResolveUDPAddr("udp", srvAddr)
+	back, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &lossyProxy{front: front, back: back, drop: drop}
+	go p.forward(true)
+	go p.forward(false)
+	t.Cleanup(func() {
+		front.Close()
+		back.Close()
+	})
+	return p
+}
+
+func (p *lossyProxy) forward(up bool) {
+	b := make([]byte, 65535)
+	var n uint64
+	for {
+		var l int
+		var err error
+		if up {
+			var addr *net.UDPAddr
+			l, addr, err = p.front.ReadFromUDP(b)
+			if addr != nil {
+				p.client.Store(addr)
+			}
+		} else {
+			l, err = p.back.Read(b)
+		}
+		if err != nil {
+			return
+		}
+		if n++; n%p.drop == 0 {
+			atomic.AddUint64(&p.dropped, 1)
+			continue
+		}
+		if up {
+			p.back.Write(b[:l])
+		} else if addr, ok := p.client.Load().(*net.UDPAddr); ok {
+			p.front.WriteToUDP(b[:l], addr)
+		}
+	}
+}
+
+func newUDPServer(t *testing.T, s *Service, reliable bool) string {
+	t.Helper()
+	srv, err := NewRPCUDPServer("127.0.0.1", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Service = s
+	srv.SetReliable(reliable)
+	go srv.Serve()
+	t.Cleanup(srv.Stop)
+	return srv.Addr().String()
+}
+
+func TestUDPReliable(t *testing.T) {
+	h := newTestHandler()
+	addr := newUDPServer(t, h.service(), true)
+	proxy := newLossyProxy(t, addr, 7)
+	cli, err := DailUDPReliable(proxy.front.LocalAddr().String(), h.service(), 5, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cli.SetCrypt(packets.AES256GCM)
+	for i := 0; i < 30; i++ {
+		req := fmt.Sprintf("%d:%s", i, strings.Repeat("x", 512))
+		_, resp, err := cli.Invoke(ctx, testCmdEcho, wrapperspb.String(req))
+		if v := respValue(t, resp, err); v != "echo:"+req {
+			t.Fatalf("resp: %q", v)
+		}
+		<-h.started
+	}
+	if atomic.LoadUint64(&proxy.dropped) == 0 {
+		t.Fatal("no packet dropped")
+	}
+}
+
+// bigPayload 超过 ppudp.MAXPKGSIZE, 需要分片.
+var bigPayload = strings.Repeat("0123456789abcdef", 8*1024)
+
+func TestUDPFragment(t *testing.T) {
+	h := newTestHandler()
+	addr := newUDPServer(t, h.service(), false)
+	cli, err := DailUDP(addr, h.service(), 5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	cli.SetCrypt(packets.AES256GCM)
+	_, resp, err := cli.Invoke(ctx, testCmdEcho, wrapperspb.String(bigPayload))
+	if v := respValue(t, resp, err); v != "echo:"+bigPayload {
+		t.Fatalf("resp length: %d", len(v))
+	}
+}
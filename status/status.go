@@ -0,0 +1,204 @@
+// Package status RPC 调用的错误状态: 状态码, 错误信息和可选的详细数据.
+//
+// 错误应答为 RPCRESP, CmdPacket.Code 为状态码, Payload 为 Encode 编码的错误信息和详细数据
+// (protobuf 编码: 1 message string; 2 details bytes).
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Code 状态码, 与 CmdPacket.Code 对应.
+type Code uint64
+
+// 状态码
+const (
+	// OK 成功
+	OK Code = 0
+	// Unimplemented 命令ID没有注册或者不支持(同 CmdIDNotReg)
+	Unimplemented Code = 1
+	// Canceled 调用被取消
+	Canceled Code = 2
+	// InvalidArgument 参数错误
+	InvalidArgument Code = 3
+	// DeadlineExceeded 调用超时
+	DeadlineExceeded Code = 4
+	// NotFound 请求的对象不存在
+	NotFound Code = 5
+	// AlreadyExists 对象已经存在
+	AlreadyExists Code = 6
+	// PermissionDenied 没有权限
+	PermissionDenied Code = 7
+	// ResourceExhausted 资源耗尽(限流, 配额)
+	ResourceExhausted Code = 8
+	// FailedPrecondition 当前状态不允许该操作
+	FailedPrecondition Code = 9
+	// Aborted 操作被中止(并发冲突等)
+	Aborted Code = 10
+	// OutOfRange 超出有效范围
+	OutOfRange Code = 11
+	// Internal 服务内部错误
+	Internal Code = 13
+	// Unavailable 服务不可用(连接断开, 服务关闭), 可以重试
+	Unavailable Code = 14
+	// DataLoss 数据丢失或者损坏
+	DataLoss Code = 15
+	// Unauthenticated 没有认证
+	Unauthenticated Code = 16
+	// Unknown 未知错误(同 packets.RETERR), 处理函数返回的普通 error
+	Unknown Code = 99
+)
+
+var codeNames = map[Code]string{
+	OK:                 "OK",
+	Unimplemented:      "Unimplemented",
+	Canceled:           "Canceled",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+	Unknown:            "Unknown",
+}
+
+func (c Code) String() string {
+	if s, ok := codeNames[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("Code(%d)", uint64(c))
+}
+
+// Status 错误状态, 实现 error 接口.
+type Status struct {
+	Code    Code
+	Message string
+	Details []byte // 详细数据, 由业务定义(如 protobuf 编码的消息)
+}
+
+// New 创建 Status
+func New(c Code, msg string) *Status {
+	return &Status{Code: c, Message: msg}
+}
+
+// Newf 创建 Status, 格式化错误信息
+func Newf(c Code, format string, a ...interface{}) *Status {
+	return New(c, fmt.Sprintf(format, a...))
+}
+
+// Error 创建 error, c 为 OK 时返回 nil.
+func Error(c Code, msg string) error {
+	return New(c, msg).Err()
+}
+
+// Errorf 创建 error, 格式化错误信息; c 为 OK 时返回 nil.
+func Errorf(c Code, format string, a ...interface{}) error {
+	return Newf(c, format, a...).Err()
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("pprpc status: code = %s, message = %s", s.Code, s.Message)
+}
+
+// Err Code 为 OK 时返回 nil.
+func (s *Status) Err() error {
+	if s == nil || s.Code == OK {
+		return nil
+	}
+	return s
+}
+
+// WithDetails 返回附加详细数据的副本.
+func (s *Status) WithDetails(details []byte) *Status {
+	out := *s
+	out.Details = details
+	return &out
+}
+
+// Encode 编码错误信息和详细数据(错误应答的 Payload).
+func (s *Status) Encode() []byte {
+	var b []byte
+	if s.Message != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, s.Message)
+	}
+	if len(s.Details) > 0 {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, s.Details)
+	}
+	return b
+}
+
+// Decode 由应答的 Code 和 Payload 解码 Status; 无法解析的 Payload 作为错误信息(兼容旧版本).
+func Decode(code uint64, payload []byte) *Status {
+	s := &Status{Code: Code(code)}
+	b := payload
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || typ != protowire.BytesType {
+			return &Status{Code: Code(code), Message: string(payload)}
+		}
+		b = b[n:]
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return &Status{Code: Code(code), Message: string(payload)}
+		}
+		b = b[n:]
+		switch num {
+		case 1:
+			s.Message = string(v)
+		case 2:
+			s.Details = append([]byte(nil), v...)
+		}
+	}
+	return s
+}
+
+// FromError 获取 err 中的 Status; 不是 Status 时返回 Unknown(context 错误返回 Canceled/DeadlineExceeded) 和 false.
+// err 为 nil 时返回 nil, true.
+func FromError(err error) (*Status, bool) {
+	if err == nil {
+		return nil, true
+	}
+	var s *Status
+	if errors.As(err, &s) {
+		return s, true
+	}
+	return FromContextError(err), false
+}
+
+// Convert 将 err 转换为 Status, err 为 nil 时返回 nil.
+func Convert(err error) *Status {
+	s, _ := FromError(err)
+	return s
+}
+
+// CodeOf 获取 err 的状态码, err 为 nil 时返回 OK.
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	return Convert(err).Code
+}
+
+// FromContextError context.Canceled/context.DeadlineExceeded 转换为对应的 Status, 其他为 Unknown.
+func FromContextError(err error) *Status {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return New(Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(DeadlineExceeded, err.Error())
+	}
+	return New(Unknown, err.Error())
+}
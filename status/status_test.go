@@ -0,0 +1,146 @@
+package status
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pprpc/packets"
+)
+
+func TestCodeString(t *testing.T) {
+	// 名称与状态码一一对应
+	seen := make(map[string]Code)
+	for c, name := range codeNames {
+		if c.String() != name {
+			t.Fatalf("Code: %d, String: %s, want: %s", uint64(c), c, name)
+		}
+		if prev, ok := seen[name]; ok {
+			t.Fatalf("name %s used by %d and %d", name, uint64(prev), uint64(c))
+		}
+		seen[name] = c
+	}
+	for c, want := range map[Code]string{
+		OK:       "OK",
+		NotFound: "NotFound",
+		Internal: "Internal",
+		Unknown:  "Unknown",
+		12:       "Code(12)",
+		100:      "Code(100)",
+	} {
+		if c.String() != want {
+			t.Fatalf("Code: %d, String: %s, want: %s", uint64(c), c, want)
+		}
+	}
+}
+
+// roundTrip 将 err 作为错误应答写入 CmdPacket, 读取后解码.
+func roundTrip(t *testing.T, err error) *Status {
+	t.Helper()
+	st := Convert(err)
+	pkg := packets.NewCmdPacket(packets.TYPEPBBIN)
+	pkg.CmdSeq = 1
+	pkg.CmdID = 10
+	pkg.EncType = packets.AESNONE
+	pkg.RPCType = packets.RPCRESP
+	pkg.Code = uint64(st.Code)
+	pkg.Payload = st.Encode()
+
+	var buf bytes.Buffer
+	if _, err := pkg.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	pp, err := packets.ReadTCPPacket(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := pp.(*packets.CmdPacket)
+	if resp.Code != uint64(st.Code) {
+		t.Fatalf("Code: %d, want: %d", resp.Code, st.Code)
+	}
+	return Decode(resp.Code, resp.Payload)
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	err := Errorf(NotFound, "user %d not found", 7)
+	got := roundTrip(t, err)
+	if got.Code != NotFound || got.Message != "user 7 not found" || got.Details != nil {
+		t.Fatalf("status: %+v", got)
+	}
+	if got.Error() != err.Error() {
+		t.Fatalf("Error: %s, want: %s", got, err)
+	}
+
+	// 详细数据
+	err = New(InvalidArgument, "bad name").WithDetails([]byte{0, 1, 2}).Err()
+	got = roundTrip(t, err)
+	if got.Code != InvalidArgument || got.Message != "bad name" || !bytes.Equal(got.Details, []byte{0, 1, 2}) {
+		t.Fatalf("status: %+v", got)
+	}
+
+	// 包装的 Status
+	wrapped := fmt.Errorf("load: %w", Error(PermissionDenied, "denied"))
+	if st, ok := FromError(wrapped); !ok || st.Code != PermissionDenied {
+		t.Fatalf("FromError: %v, %t", st, ok)
+	}
+	if got = roundTrip(t, wrapped); got.Code != PermissionDenied || got.Message != "denied" {
+		t.Fatalf("status: %+v", got)
+	}
+
+	// 普通 error 为 Unknown
+	st, ok := FromError(errors.New("boom"))
+	if ok || st.Code != Unknown || st.Message != "boom" {
+		t.Fatalf("FromError: %+v, %t", st, ok)
+	}
+	if got = roundTrip(t, errors.New("boom")); got.Code != Unknown || got.Message != "boom" {
+		t.Fatalf("status: %+v", got)
+	}
+
+	// 旧版本的应答: Payload 为错误信息文本
+	if got = Decode(uint64(Unknown), []byte("legacy error")); got.Code != Unknown || got.Message != "legacy error" {
+		t.Fatalf("status: %+v", got)
+	}
+
+	// OK 和 nil
+	if Errorf(OK, "ok") != nil || New(OK, "").Err() != nil {
+		t.Fatal("OK status as error")
+	}
+	if st, ok := FromError(nil); st != nil || !ok {
+		t.Fatalf("FromError(nil): %v, %t", st, ok)
+	}
+}
+
+func TestFromContextError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dctx, dcancel := context.WithTimeout(context.Background(), 0)
+	defer dcancel()
+	<-dctx.Done()
+
+	for _, tc := range []struct {
+		err  error
+		code Code
+	}{
+		{ctx.Err(), Canceled},
+		{dctx.Err(), DeadlineExceeded},
+		{fmt.Errorf("call: %w", context.DeadlineExceeded), DeadlineExceeded},
+		{errors.New("boom"), Unknown},
+	} {
+		st := FromContextError(tc.err)
+		if st.Code != tc.code || st.Message != tc.err.Error() {
+			t.Fatalf("err: %v, status: %+v, want: %s", tc.err, st, tc.code)
+		}
+		// FromError 对 context 错误同样转换, 但不是 Status
+		if st, ok := FromError(tc.err); ok || st.Code != tc.code {
+			t.Fatalf("FromError(%v): %+v, %t", tc.err, st, ok)
+		}
+		if CodeOf(tc.err) != tc.code {
+			t.Fatalf("CodeOf(%v): %s", tc.err, CodeOf(tc.err))
+		}
+	}
+	if CodeOf(nil) != OK {
+		t.Fatalf("CodeOf(nil): %s", CodeOf(nil))
+	}
+}
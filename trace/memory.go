@@ -0,0 +1,35 @@
+package trace
+
+import "sync"
+
+// InMemoryExporter 在内存中保存导出的 span, 用于测试.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*SpanData
+}
+
+// NewInMemoryExporter 创建 InMemoryExporter
+func NewInMemoryExporter() *InMemoryExporter {
+	return new(InMemoryExporter)
+}
+
+// ExportSpan 实现 Exporter.
+func (e *InMemoryExporter) ExportSpan(sd *SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, sd)
+}
+
+// Spans 已经导出的 span(按结束顺序).
+func (e *InMemoryExporter) Spans() []*SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*SpanData(nil), e.spans...)
+}
+
+// Reset 清空已经导出的 span.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
@@ -0,0 +1,332 @@
+// Package trace 分布式跟踪: span 的创建, 上下文传递和导出.
+//
+// 跟踪上下文按 W3C Trace Context(traceparent/tracestate) 格式通过调用元数据传递,
+// 可以与 OpenTelemetry 等实现互通. 默认不记录 span(仍然传递对端的跟踪上下文),
+// 通过 SetExporter 设置导出器后记录; InMemoryExporter 可用于测试.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 元数据键(W3C Trace Context)
+const (
+	TraceparentKey = "traceparent"
+	TracestateKey  = "tracestate"
+)
+
+// pprpc 调用 span 的属性
+const (
+	AttrRPCSystem = "rpc.system"           // 固定为 pprpc
+	AttrRPCMethod = "rpc.method"           // CmdName
+	AttrCmdID     = "pprpc.cmd_id"         // CmdID
+	AttrCode      = "pprpc.code"           // 应答的状态码
+	AttrPeerAddr  = "network.peer.address" // 对端地址
+)
+
+// TraceID 跟踪ID
+type TraceID [16]byte
+
+// IsValid 不全为 0
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID span ID
+type SpanID [8]byte
+
+// IsValid 不全为 0
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext 需要跨进程传递的 span 信息.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string // W3C tracestate, 原样传递
+	Remote     bool   // 由对端传递
+}
+
+// IsValid TraceID 和 SpanID 都有效
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent W3C traceparent 格式: 00-{TraceID}-{SpanID}-{flags}.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent 解析 W3C traceparent.
+func ParseTraceparent(s string) (sc SpanContext, err error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		err = fmt.Errorf("traceparent: %q, invalid format", s)
+		return
+	}
+	// 版本 ff 无效; 版本 00 只能有 4 个字段, 更高版本忽略多余字段
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		err = fmt.Errorf("traceparent: %q, invalid version", s)
+		return
+	}
+	var flags [1]byte
+	if _, err = hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return
+	}
+	if _, err = hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return
+	}
+	if _, err = hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return
+	}
+	if !sc.IsValid() {
+		err = fmt.Errorf("traceparent: %q, invalid id", s)
+		return
+	}
+	sc.Sampled = flags[0]&0x01 != 0
+	sc.Remote = true
+	return
+}
+
+// Inject 将 sc 写入元数据.
+func Inject(sc SpanContext, md map[string]string) {
+	if !sc.IsValid() {
+		return
+	}
+	md[TraceparentKey] = sc.Traceparent()
+	if sc.TraceState != "" {
+		md[TracestateKey] = sc.TraceState
+	}
+}
+
+// Extract 由元数据解析对端的 SpanContext.
+func Extract(md map[string]string) (SpanContext, bool) {
+	v, ok := md[TraceparentKey]
+	if !ok {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(v)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.TraceState = md[TracestateKey]
+	return sc, true
+}
+
+// SpanKind span 类型
+type SpanKind int
+
+// span 类型
+const (
+	SpanKindInternal SpanKind = iota
+	SpanKindServer
+	SpanKindClient
+	// SpanKindProducer 只发送不等待应答(InvokeAsync)
+	SpanKindProducer
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	case SpanKindProducer:
+		return "producer"
+	}
+	return "internal"
+}
+
+// StatusCode span 状态
+type StatusCode int
+
+// span 状态
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+// SpanData 结束的 span, 交给 Exporter 导出.
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	Parent        SpanContext // 没有父 span 时无效
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    map[string]interface{}
+	StatusCode    StatusCode
+	StatusMessage string
+}
+
+// Exporter span 导出器, 需要支持并发调用.
+type Exporter interface {
+	ExportSpan(sd *SpanData)
+}
+
+type holder struct {
+	e Exporter
+}
+
+var exporter atomic.Value
+
+// SetExporter 设置导出器, nil 停止记录 span.
+func SetExporter(e Exporter) {
+	exporter.Store(holder{e})
+}
+
+func getExporter() Exporter {
+	h, _ := exporter.Load().(holder)
+	return h.e
+}
+
+// Span 一次操作(调用)的跟踪记录. 方法可以并发调用, nil *Span 的方法不执行任何操作.
+type Span struct {
+	sc  SpanContext
+	exp Exporter // nil 不记录, 只传递跟踪上下文
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithSpan 返回附加 span 的 context.
+func ContextWithSpan(ctx context.Context, sp *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, sp)
+}
+
+// SpanFromContext 获取 ctx 中的 span, 没有返回 nil.
+func SpanFromContext(ctx context.Context) *Span {
+	sp, _ := ctx.Value(spanKey{}).(*Span)
+	return sp
+}
+
+// ContextWithRemoteSpanContext 返回附加对端 SpanContext 的 context, 作为后续 Start 的父 span.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	sc.Remote = true
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext 获取 ctx 中 span 或者对端的 SpanContext.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if sp := SpanFromContext(ctx); sp != nil {
+		return sp.sc
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// Start 创建 span, 父 span 为 ctx 中的 span 或者对端的 SpanContext.
+// 没有设置导出器(或者父 span 未采样)时返回的 span 不记录, 只沿用父 span 的上下文;
+// 同时没有父 span 时返回 nil.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+	exp := getExporter()
+	if exp == nil || (parent.IsValid() && !parent.Sampled) {
+		if !parent.IsValid() {
+			return ctx, nil
+		}
+		sp := &Span{sc: parent}
+		return ContextWithSpan(ctx, sp), sp
+	}
+
+	sc := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.TraceState = parent.TraceState
+	} else {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+
+	sp := &Span{sc: sc, exp: exp}
+	sp.data = SpanData{
+		Name:        name,
+		Kind:        kind,
+		SpanContext: sc,
+		Parent:      parent,
+		StartTime:   time.Now(),
+		Attributes:  make(map[string]interface{}),
+	}
+	return ContextWithSpan(ctx, sp), sp
+}
+
+// SpanContext span 的上下文
+func (sp *Span) SpanContext() SpanContext {
+	if sp == nil {
+		return SpanContext{}
+	}
+	return sp.sc
+}
+
+// IsRecording 是否记录(导出)
+func (sp *Span) IsRecording() bool {
+	return sp != nil && sp.exp != nil
+}
+
+// SetAttr 设置属性
+func (sp *Span) SetAttr(key string, value interface{}) {
+	if !sp.IsRecording() {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if !sp.ended {
+		sp.data.Attributes[key] = value
+	}
+}
+
+// SetStatus 设置状态
+func (sp *Span) SetStatus(code StatusCode, msg string) {
+	if !sp.IsRecording() {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if !sp.ended {
+		sp.data.StatusCode = code
+		sp.data.StatusMessage = msg
+	}
+}
+
+// End 结束 span 并导出, 多次调用只导出一次.
+func (sp *Span) End() {
+	if !sp.IsRecording() {
+		return
+	}
+	sp.mu.Lock()
+	if sp.ended {
+		sp.mu.Unlock()
+		return
+	}
+	sp.ended = true
+	sp.data.EndTime = time.Now()
+	sd := sp.data
+	sp.mu.Unlock()
+	sp.exp.ExportSpan(&sd)
+}